@@ -0,0 +1,168 @@
+// Package analytics records how often links are fetched, so that we can tell how many times each preview
+// was scraped and clicked through
+package analytics
+
+import (
+	"fmt"
+	"gopkg.in/redis.v5"
+	"sync"
+	"time"
+)
+
+// Recorder records the hits links get, along with the user agent that made them, and aggregates them.
+type Recorder interface {
+	RecordHit(slug, userAgent string, ts time.Time) error
+	Stats(slug string) (*Stats, error)
+	clear()
+}
+
+// Stats are the hits a link got, counted per user agent. LastHitAt is nil when the link got none.
+type Stats struct {
+	Hits       uint64
+	UserAgents map[string]uint64
+	LastHitAt  *time.Time
+}
+
+func newStats() *Stats {
+	return &Stats{UserAgents: make(map[string]uint64)}
+}
+
+/*
+	In-memory implementation
+*/
+
+// InMemoryRecorder is an in-memory implementation of a Recorder, safe for concurrent use.
+type InMemoryRecorder struct {
+	mutex sync.RWMutex
+	stats map[string]*Stats
+}
+
+// NewInMemoryRecorder creates a new in-memory recorder.
+func NewInMemoryRecorder() *InMemoryRecorder {
+	return &InMemoryRecorder{stats: make(map[string]*Stats)}
+}
+
+// RecordHit counts a hit to the link with the given slug.
+func (recorder *InMemoryRecorder) RecordHit(slug, userAgent string, ts time.Time) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	stats, ok := recorder.stats[slug]
+	if !ok {
+		stats = newStats()
+		recorder.stats[slug] = stats
+	}
+
+	stats.Hits++
+	stats.UserAgents[userAgent]++
+	if stats.LastHitAt == nil || ts.After(*stats.LastHitAt) {
+		stats.LastHitAt = &ts
+	}
+
+	return nil
+}
+
+// Stats returns a copy of the hits the link with the given slug got.
+func (recorder *InMemoryRecorder) Stats(slug string) (*Stats, error) {
+	recorder.mutex.RLock()
+	defer recorder.mutex.RUnlock()
+
+	copied := newStats()
+	stats, ok := recorder.stats[slug]
+	if !ok {
+		return copied, nil
+	}
+
+	copied.Hits = stats.Hits
+	copied.LastHitAt = stats.LastHitAt
+	for userAgent, hits := range stats.UserAgents {
+		copied.UserAgents[userAgent] = hits
+	}
+
+	return copied, nil
+}
+
+func (recorder *InMemoryRecorder) clear() {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.stats = make(map[string]*Stats)
+}
+
+/*
+	Redis implementation
+*/
+
+// RedisRecorder is a Redis based implementation of a Recorder, keeping a hash of hits per user agent for each link.
+type RedisRecorder struct {
+	client *redis.Client
+}
+
+// NewRedisRecorder creates a new RedisRecorder.
+func NewRedisRecorder(host, port, password string) *RedisRecorder {
+	return &RedisRecorder{
+		client: redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%s", host, port),
+			Password: password,
+			DB:       3,
+		}),
+	}
+}
+
+// RecordHit counts a hit to the link with the given slug.
+func (recorder *RedisRecorder) RecordHit(slug, userAgent string, ts time.Time) error {
+	_, err := recorder.client.Pipelined(func(pipe *redis.Pipeline) error {
+		pipe.HIncrBy(hitsKey(slug), userAgent, 1)
+		pipe.Set(lastHitKey(slug), ts.UTC().Format(time.RFC3339Nano), 0)
+		return nil
+	})
+
+	return err
+}
+
+// Stats returns the hits the link with the given slug got.
+func (recorder *RedisRecorder) Stats(slug string) (*Stats, error) {
+	userAgents, err := recorder.client.HGetAll(hitsKey(slug)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := newStats()
+	for userAgent, raw := range userAgents {
+		var hits uint64
+		if _, err = fmt.Sscan(raw, &hits); err != nil {
+			return nil, fmt.Errorf("Unexpected hit count %q stored for %s: %s", raw, slug, err)
+		}
+
+		stats.Hits += hits
+		stats.UserAgents[userAgent] = hits
+	}
+
+	raw, err := recorder.client.Get(lastHitKey(slug)).Result()
+	if err == redis.Nil {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lastHitAt, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return nil, err
+	}
+	stats.LastHitAt = &lastHitAt
+
+	return stats, nil
+}
+
+func (recorder *RedisRecorder) clear() {
+	recorder.client.FlushDb()
+}
+
+func hitsKey(slug string) string {
+	return "hits:" + slug
+}
+
+func lastHitKey(slug string) string {
+	return "last-hit:" + slug
+}
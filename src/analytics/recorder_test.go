@@ -0,0 +1,105 @@
+package analytics
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+	Generic test suite for recorders
+*/
+
+func behavesLikeARecorder(t *testing.T, recorder Recorder) {
+	recorder.clear()
+	testStatsWithoutHits(t, recorder)
+
+	recorder.clear()
+	testRecordHits(t, recorder)
+}
+
+func testStatsWithoutHits(t *testing.T, recorder Recorder) {
+	stats, err := recorder.Stats("missing")
+	if err != nil {
+		t.Fatalf("Unexpected error getting the stats of a link without hits: %s", err)
+	}
+
+	if stats.Hits != 0 || len(stats.UserAgents) != 0 || stats.LastHitAt != nil {
+		t.Errorf("Expected a link without hits to have empty stats. Instead, got %+v", stats)
+	}
+}
+
+func testRecordHits(t *testing.T, recorder Recorder) {
+	first := time.Date(2018, time.November, 1, 10, 0, 0, 0, time.UTC)
+	last := first.Add(time.Minute)
+
+	hits := []struct {
+		slug      string
+		userAgent string
+		ts        time.Time
+	}{
+		{"some-slug", "facebookexternalhit/1.1", first},
+		{"some-slug", "Mozilla/5.0 Chrome/70.0", first.Add(time.Second)},
+		{"some-slug", "facebookexternalhit/1.1", last},
+		{"other-slug", "Twitterbot/1.0", last.Add(time.Hour)},
+	}
+
+	for _, hit := range hits {
+		if err := recorder.RecordHit(hit.slug, hit.userAgent, hit.ts); err != nil {
+			t.Fatalf("Unexpected error recording a hit: %s", err)
+		}
+	}
+
+	stats, err := recorder.Stats("some-slug")
+	if err != nil {
+		t.Fatalf("Unexpected error getting the stats: %s", err)
+	}
+
+	if stats.Hits != 3 || stats.UserAgents["facebookexternalhit/1.1"] != 2 || stats.UserAgents["Mozilla/5.0 Chrome/70.0"] != 1 {
+		t.Errorf("Expected the hits to be counted per user agent. Instead, got %+v", stats)
+	}
+
+	if stats.LastHitAt == nil || !stats.LastHitAt.Equal(last) {
+		t.Errorf("Expected the last hit to be at %s. Instead, got %v", last, stats.LastHitAt)
+	}
+}
+
+/*
+	Tests for the specific implementations
+*/
+
+func TestInMemoryRecorder(t *testing.T) {
+	behavesLikeARecorder(t, NewInMemoryRecorder())
+}
+
+func TestInMemoryRecorderConcurrentAccess(t *testing.T) {
+	recorder := NewInMemoryRecorder()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			recorder.RecordHit("some-slug", "some-agent", time.Now())
+		}()
+		go func() {
+			defer wg.Done()
+			recorder.Stats("some-slug")
+		}()
+	}
+	wg.Wait()
+
+	if stats, _ := recorder.Stats("some-slug"); stats.Hits != 50 {
+		t.Errorf("Expected every concurrent hit to be counted. Instead, got %d", stats.Hits)
+	}
+}
+
+func TestRedisRecorder(t *testing.T) {
+	recorder := NewRedisRecorder(
+		os.Getenv("REDIS_HOST"),
+		os.Getenv("REDIS_PORT"),
+		os.Getenv("REDIS_PASS"),
+	)
+	behavesLikeARecorder(t, recorder)
+}
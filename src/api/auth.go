@@ -0,0 +1,61 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+)
+
+// Requires an Authorization: Bearer header carrying one of the configured API keys.
+// When no keys are configured, every request is let through
+func requireAPIKey(next handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		if len(c.APIKeys) == 0 {
+			next(w, r, ps, c)
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fakelink"`)
+			errorResponse(w, http.StatusUnauthorized, "Missing API key", errors.New("No bearer token in the Authorization header"), c)
+			return
+		}
+
+		if !validAPIKey(token, c.APIKeys) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fakelink", error="invalid_token"`)
+			errorResponse(w, http.StatusUnauthorized, "Invalid API key", errors.New("The bearer token is not a configured API key"), c)
+			return
+		}
+
+		next(w, r, ps, c)
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || strings.ToLower(header[:len(prefix)]) != prefix {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[len(prefix):])
+	return token, token != ""
+}
+
+// Compares the token against every key in constant time. Both sides are hashed first, so that
+// neither the position of a match nor the length of the keys leaks through the timing
+func validAPIKey(token string, keys []string) bool {
+	hashed := sha256.Sum256([]byte(token))
+
+	valid := 0
+	for _, key := range keys {
+		hashedKey := sha256.Sum256([]byte(key))
+		valid |= subtle.ConstantTimeCompare(hashed[:], hashedKey[:])
+	}
+
+	return valid == 1
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"github.com/devlucky/fakelink/src/links"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func authConf() *Config {
+	config := inMemoryConf()
+	config.APIKeys = []string{"first-key", "second-key"}
+	return config
+}
+
+func postWithAuthorization(t *testing.T, config *Config, authorization string) *httptest.ResponseRecorder {
+	req := newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink()})
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuthWithValidKey(t *testing.T) {
+	config := authConf()
+
+	expectStatus(t, postWithAuthorization(t, config, "Bearer first-key"), http.StatusCreated)
+	expectStatus(t, postWithAuthorization(t, config, "bearer second-key"), http.StatusCreated)
+}
+
+func TestAuthWithInvalidKey(t *testing.T) {
+	config := authConf()
+
+	for _, authorization := range []string{"Bearer wrong-key", "Bearer first-ke", "Bearer first-key-and-more", "Basic first-key"} {
+		rr := postWithAuthorization(t, config, authorization)
+		expectStatus(t, rr, http.StatusUnauthorized)
+		expectHeaderToContain(t, rr, "WWW-Authenticate", []string{"Bearer"})
+	}
+}
+
+func TestAuthWithMissingKey(t *testing.T) {
+	config := authConf()
+
+	for _, authorization := range []string{"", "Bearer ", "Bearer"} {
+		rr := postWithAuthorization(t, config, authorization)
+		expectStatus(t, rr, http.StatusUnauthorized)
+		expectHeaderToContain(t, rr, "WWW-Authenticate", []string{"Bearer"})
+	}
+
+	if list, _, _ := config.LinkStore.List("", 10); len(list) != 0 {
+		t.Error("Expected unauthorized requests not to create links")
+	}
+}
+
+func TestAuthOnlyGuardsMutatingRoutes(t *testing.T) {
+	config := authConf()
+	slug := config.LinkStore.Create(links.RandomLink())
+
+	expectStatus(t, putLinkRequest(t, config, slug, &postLinkInput{Link: *links.RandomLink()}), http.StatusUnauthorized)
+	expectStatus(t, deleteLinkRequest(t, config, slug), http.StatusUnauthorized)
+
+	req, err := http.NewRequest("GET", "/links/"+slug, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+}
+
+func TestAuthDisabledWithoutKeys(t *testing.T) {
+	expectStatus(t, postWithAuthorization(t, inMemoryConf(), ""), http.StatusCreated)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodyBytes is how large the body of a request creating or updating links may be, if the Config
+// does not say. It leaves room for an image as large as the ones we mirror, along with the link's JSON.
+const DefaultMaxBodyBytes = 11 << 20
+
+// Caps the size of the request body, so that a huge one can't exhaust our memory. Reading past the limit fails,
+// which the handlers answer with a 413
+func limitBody(next handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes(c))
+		next(w, r, ps, c)
+	}
+}
+
+func maxBodyBytes(c *Config) int64 {
+	if c.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+
+	return c.MaxBodyBytes
+}
+
+// Whether reading the body failed because it went past the limit. The error http.MaxBytesReader returns has
+// no type of its own, and multipart wraps it in its own message
+func bodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+func bodyTooLargeResponse(w http.ResponseWriter, err error, c *Config) {
+	errorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("The request body is larger than %d bytes", maxBodyBytes(c)), err, c)
+}
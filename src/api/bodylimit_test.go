@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPostLinkWithOversizeJSONBody(t *testing.T) {
+	config := inMemoryConf()
+	config.MaxBodyBytes = 1024
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", Description: strings.Repeat("a", 2048)}}}
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusRequestEntityTooLarge)
+	if config.LinkStore.FindRandom() != "" {
+		t.Error("Expected no link to be created")
+	}
+}
+
+func TestPostLinkWithOversizeUpload(t *testing.T) {
+	config := inMemoryConf()
+	config.MaxBodyBytes = 1024
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title"}}}
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequestWithImage(t, input, "huge.jpg", bytes.Repeat([]byte{0xff}, 4096)))
+
+	expectStatus(t, rr, http.StatusRequestEntityTooLarge)
+}
+
+func TestPostLinkWithinTheBodyLimit(t *testing.T) {
+	config := inMemoryConf()
+	config.MaxBodyBytes = 1024
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com"}}}
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusCreated)
+}
+
+func TestPutLinkWithOversizeBody(t *testing.T) {
+	config := inMemoryConf()
+	config.MaxBodyBytes = 1024
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Old title"}})
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: strings.Repeat("a", 2048)}}}
+	rr := putLinkRequest(t, config, slug, input)
+
+	expectStatus(t, rr, http.StatusRequestEntityTooLarge)
+	if link := config.LinkStore.Find(slug); link.Values.Title != "Old title" {
+		t.Error("Expected the link not to be updated")
+	}
+}
+
+func TestPostBulkLinksWithOversizeBody(t *testing.T) {
+	config := inMemoryConf()
+	config.MaxBodyBytes = 1024
+
+	body := "[" + strings.Repeat(`{"link": {"values": {"title": "some-title"}}},`, 100) + `{}]`
+	rr, _ := postBulkLinksRequest(t, config, body)
+
+	expectStatus(t, rr, http.StatusRequestEntityTooLarge)
+}
+
+func TestDefaultMaxBodyBytes(t *testing.T) {
+	if limit := maxBodyBytes(&Config{}); limit != DefaultMaxBodyBytes {
+		t.Errorf("Expected the default limit when none is configured. Instead, got %d", limit)
+	}
+}
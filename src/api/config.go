@@ -1,23 +1,367 @@
 package api
 
 import (
+	"fmt"
+	"github.com/devlucky/fakelink/src/analytics"
 	"github.com/devlucky/fakelink/src/images"
 	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/logs"
+	"github.com/devlucky/fakelink/src/templates"
 	"github.com/julienschmidt/httprouter"
-	"html/template"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Config is a container for all the interfaces and configuration options the API uses.
 // It will be injected to the endpoints in order to allow them to access these options in a DI way
 type Config struct {
-	RootPath       string
-	DebugMode      bool
-	Template       *template.Template
-	LinkStore      links.Store
-	ImageStore     images.Store
-	ImageMaxWidth  int
-	ImageMaxHeight int
+	RootPath            string
+	DebugMode           bool
+	PublicBaseURL       string
+	AllowedOrigins      []string
+	AllowedMethods      []string
+	AllowedHeaders      []string
+	Templates           *templates.Registry
+	LinkStore           links.Store
+	ImageStore          images.Store
+	ImageKeys           images.KeyStrategy
+	ImageMaxWidth       int
+	ImageMaxHeight      int
+	ImageMaxBytes       int64
+	ImageFetchTimeout   time.Duration
+	UnrestrictedFetches bool
+	ImageGCInterval     time.Duration
+	MaxBodyBytes        int64
+	PlaceholderImages   bool
+	SlugLength          int
+	DeterministicSlugs  bool
+	MaxDescriptionLen   int
+	ReservedSlugs       []string
+	AllowedHosts        []string
+	BlockedHosts        []string
+	PostRateLimit       float64
+	PostRateBurst       int
+	IdempotencyTTL      time.Duration
+	BehindProxy         bool
+	APIKeys             []string
+	ScraperUserAgents   []string
+	RenderCacheSize     int
+	PreviewCacheControl string
+	DefaultSiteName     string
+	DefaultType         string
+	Analytics           analytics.Recorder
+	Logger              *logs.Logger
+	ShutdownGracePeriod time.Duration
+
+	renderCacheOnce sync.Once
+	pages           *renderCache
+	orphans         orphanedImages
+}
+
+// DefaultPreviewCacheControl is the Cache-Control of rendered previews, if the Config does not say.
+const DefaultPreviewCacheControl = "public, max-age=300"
+
+// DefaultReservedSlugs are the custom slugs links can't take, as they are the names of the API's routes.
+var DefaultReservedSlugs = []string{"admin", "bulk", "healthz", "image", "images", "links", "metrics", "oembed", "random", "stats"}
+
+// NewEnvConf creates the production Config, where links are kept in Redis and images in S3, unless LINK_STORE
+// and IMAGE_STORE pick other stores. Their connection details are read from the environment.
+func NewEnvConf() *Config {
+	logger := logs.New(os.Stdout)
+
+	return &Config{
+		RootPath:            fmt.Sprintf("%s/src/github.com/devlucky/fakelink", os.Getenv("GOPATH")),
+		DebugMode:           os.Getenv("DEBUG") == "true",
+		PublicBaseURL:       envBaseURL("PUBLIC_BASE_URL"),
+		AllowedOrigins:      envList("CORS_ALLOWED_ORIGINS"),
+		AllowedMethods:      envList("CORS_ALLOWED_METHODS"),
+		AllowedHeaders:      envList("CORS_ALLOWED_HEADERS"),
+		Templates:           templates.DefaultRegistry,
+		LinkStore:           envLinkStore(logger),
+		ImageStore:          envImageStore(logger),
+		ImageKeys:           envImageKeys("IMAGE_KEYS"),
+		ImageMaxWidth:       512,
+		ImageMaxHeight:      512,
+		ImageMaxBytes:       10 << 20,
+		ImageFetchTimeout:   images.DefaultFetchTimeout,
+		UnrestrictedFetches: os.Getenv("UNRESTRICTED_FETCHES") == "true",
+		ImageGCInterval:     time.Duration(envFloat("IMAGE_GC_INTERVAL") * float64(time.Second)),
+		MaxBodyBytes:        int64(envFloat("MAX_BODY_BYTES")),
+		PlaceholderImages:   os.Getenv("PLACEHOLDER_IMAGES") == "true",
+		SlugLength:          int(envFloat("SLUG_LENGTH")),
+		DeterministicSlugs:  os.Getenv("DETERMINISTIC_SLUGS") == "true",
+		MaxDescriptionLen:   int(envFloat("MAX_DESCRIPTION_LENGTH")),
+		ReservedSlugs:       append(append([]string{}, DefaultReservedSlugs...), envList("RESERVED_SLUGS")...),
+		AllowedHosts:        envList("ALLOWED_HOSTS"),
+		BlockedHosts:        envList("BLOCKED_HOSTS"),
+		PostRateLimit:       envFloat("POST_RATE_LIMIT"),
+		PostRateBurst:       int(envFloat("POST_RATE_BURST")),
+		IdempotencyTTL:      time.Duration(envFloat("IDEMPOTENCY_TTL") * float64(time.Second)),
+		BehindProxy:         os.Getenv("BEHIND_PROXY") == "true",
+		APIKeys:             envList("API_KEYS"),
+		ScraperUserAgents:   envList("SCRAPER_USER_AGENTS"),
+		RenderCacheSize:     int(envFloat("RENDER_CACHE_SIZE")),
+		PreviewCacheControl: envCacheControl("PREVIEW_CACHE_CONTROL"),
+		DefaultSiteName:     os.Getenv("DEFAULT_SITE_NAME"),
+		DefaultType:         envType("DEFAULT_TYPE"),
+		Analytics:           envAnalytics(),
+		Logger:              logger,
+		ShutdownGracePeriod: time.Duration(envFloat("SHUTDOWN_GRACE_PERIOD") * float64(time.Second)),
+	}
+}
+
+// Reads a number from the environment, which is zero when the variable is not set
+func envFloat(name string) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("Invalid %s: %s", name, err)
+	}
+
+	return number
+}
+
+// Reads the URL the API is publicly reachable at from the environment, refusing to start without it
+func envBaseURL(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		log.Fatalf("Missing %s: the URL the API is publicly reachable at is required", name)
+	}
+
+	if err := validateBaseURL(value); err != nil {
+		log.Fatalf("Invalid %s: %s", name, err)
+	}
+
+	return value
+}
+
+// Shareable links and og:url values are built on top of the base URL, so it has to be an absolute http(s) URL
+func validateBaseURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("%q must use the http or https scheme", raw)
+	}
+
+	if parsed.Host == "" {
+		return fmt.Errorf("%q must have a host", raw)
+	}
+
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return fmt.Errorf("%q can't have a query or a fragment", raw)
+	}
+
+	return nil
+}
+
+// Reads the og:type of links that don't specify one from the environment, which is empty when the variable is not set
+func envType(name string) string {
+	value := os.Getenv(name)
+	if value != "" && !links.IsOpenGraphType(value) {
+		log.Fatalf("Invalid %s: %q is not an Open Graph type", name, value)
+	}
+
+	return value
+}
+
+// Reads the Cache-Control of rendered previews from the environment, which is empty when the variable is not set
+func envCacheControl(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return ""
+	}
+
+	if err := validateCacheControl(value); err != nil {
+		log.Fatalf("Invalid %s: %s", name, err)
+	}
+
+	return value
+}
+
+// Directives are tokens, optionally with a token or quoted value, such as max-age=300 or private="Set-Cookie"
+var cacheDirectivePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*(=([A-Za-z0-9-]+|"[^"]*"))?$`)
+
+// Cache-Control values are comma separated directives, whose ages must be a number of seconds
+func validateCacheControl(value string) error {
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		if !cacheDirectivePattern.MatchString(directive) {
+			return fmt.Errorf("%q is not a Cache-Control directive", directive)
+		}
+
+		parts := strings.SplitN(directive, "=", 2)
+		switch strings.ToLower(parts[0]) {
+		case "max-age", "s-maxage", "stale-while-revalidate", "stale-if-error":
+			if len(parts) < 2 {
+				return fmt.Errorf("%q needs a number of seconds", directive)
+			}
+			if _, err := strconv.ParseUint(parts[1], 10, 32); err != nil {
+				return fmt.Errorf("%q needs a number of seconds", directive)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reads how stored images are keyed from the environment, random keys being the default
+func envImageKeys(name string) images.KeyStrategy {
+	strategy, err := images.KeyStrategyNamed(envOr(name, "random"))
+	if err != nil {
+		log.Fatalf("Invalid %s: %s", name, err)
+	}
+
+	return strategy
+}
+
+// Reads a comma separated list from the environment, which is empty when the variable is not set
+func envList(name string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// Stores may fail for a little while at startup, such as S3 when its bucket is checked or Postgres when the
+// database is not up yet, so setting them up is retried with an increasing delay before giving up
+const (
+	storeAttempts = 5
+	storeBackoff  = time.Second
+)
+
+// Images are kept in S3 unless IMAGE_STORE names another type of store
+func envImageStore(logger *logs.Logger) images.Store {
+	config := images.StoreConfig{
+		Type: envOr("IMAGE_STORE", "s3"),
+		Options: images.Options{
+			Format:    images.JPEG,
+			Quality:   90,
+			MaxWidth:  1200,
+			MaxHeight: 1200,
+		},
+		Logger: logger,
+		// Without an access key, as when running on AWS with an instance or task role, the default credential chain is used
+		S3: images.S3Config{
+			Host:               os.Getenv("MINIO_HOST"),
+			Port:               os.Getenv("MINIO_PORT"),
+			AccessKey:          os.Getenv("MINIO_ACCESS_KEY"),
+			AccessSecret:       os.Getenv("MINIO_SECRET_KEY"),
+			Bucket:             os.Getenv("MINIO_BUCKET"),
+			KeyPrefix:          os.Getenv("MINIO_KEY_PREFIX"),
+			PublicURL:          os.Getenv("MINIO_PUBLIC_URL"),
+			Region:             os.Getenv("MINIO_REGION"),
+			SSL:                os.Getenv("MINIO_SSL") == "true",
+			VirtualHostedStyle: os.Getenv("MINIO_VIRTUAL_HOSTED_STYLE") == "true",
+			Presign:            os.Getenv("MINIO_PRESIGN") == "true",
+			PresignTTL:         time.Duration(envFloat("MINIO_PRESIGN_TTL") * float64(time.Second)),
+		},
+		PublicURL:     os.Getenv("IMAGE_PUBLIC_URL"),
+		GCSBucket:     os.Getenv("GCS_BUCKET"),
+		GCSKeyPrefix:  os.Getenv("GCS_KEY_PREFIX"),
+		BaseDir:       os.Getenv("IMAGE_DIR"),
+		RedisHost:     os.Getenv("REDIS_HOST"),
+		RedisPort:     os.Getenv("REDIS_PORT"),
+		RedisPassword: os.Getenv("REDIS_PASS"),
+		RedisTTL:      time.Duration(envFloat("IMAGE_TTL") * float64(time.Second)),
+	}
+
+	var store images.Store
+	setUpStore(logger, "image", config.Type, images.StoreTypes(), images.ErrUnknownStoreType, func() (err error) {
+		store, err = images.NewStore(config)
+		return
+	})
+
+	return store
+}
+
+// Links are kept in Redis unless LINK_STORE names another type of store
+func envLinkStore(logger *logs.Logger) links.Store {
+	config := links.StoreConfig{
+		Type:          envOr("LINK_STORE", "redis"),
+		Capacity:      int(envFloat("LINK_STORE_CAPACITY")),
+		RedisHost:     os.Getenv("REDIS_HOST"),
+		RedisPort:     os.Getenv("REDIS_PORT"),
+		RedisPassword: os.Getenv("REDIS_PASS"),
+		PostgresURL:   os.Getenv("POSTGRES_URL"),
+	}
+
+	var store links.Store
+	setUpStore(logger, "link", config.Type, links.StoreTypes(), links.ErrUnknownStoreType, func() (err error) {
+		store, err = links.NewStore(config)
+		return
+	})
+
+	return store
+}
+
+// Sets up a store of the given kind, image or link, with build. Unknown types are fatal right away, as retrying
+// would not help, while other errors are only once every attempt failed
+func setUpStore(logger *logs.Logger, kind, storeType string, types []string, unknownType error, build func() error) {
+	backoff := storeBackoff
+	for attempt := 1; ; attempt++ {
+		err := build()
+		if err == nil {
+			return
+		}
+
+		if err == unknownType {
+			logger.Fatal("Invalid "+strings.ToUpper(kind)+"_STORE", err, logs.Fields{"type": storeType, "types": types})
+			return
+		}
+
+		fields := logs.Fields{"type": storeType, "attempt": attempt, "attempts": storeAttempts}
+		if attempt == storeAttempts {
+			logger.Fatal("Setting up the "+kind+" store failed", err, fields)
+			return
+		}
+
+		logger.Error("Setting up the "+kind+" store failed, retrying", err, fields)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Reads a variable from the environment, falling back to the given value when it is not set
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// Hits are only recorded when ANALYTICS is set, in Redis or, for a single instance, in memory
+func envAnalytics() analytics.Recorder {
+	switch os.Getenv("ANALYTICS") {
+	case "redis":
+		return analytics.NewRedisRecorder(
+			os.Getenv("REDIS_HOST"),
+			os.Getenv("REDIS_PORT"),
+			os.Getenv("REDIS_PASS"),
+		)
+	case "memory":
+		return analytics.NewInMemoryRecorder()
+	default:
+		return nil
+	}
 }
 
 // Wraps an endpoint handler with a function that has access to a Config
@@ -17,3 +17,61 @@ func TestInjectConfig(t *testing.T) {
 	f := injectConfig(config, handler)
 	f(nil, nil, nil)
 }
+
+func TestValidateBaseURL(t *testing.T) {
+	cases := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://fakelink.example.com", true},
+		{"http://localhost:8080/", true},
+		{"https://example.com/fakelink", true},
+		{"example.com", false},
+		{"/links", false},
+		{"//example.com", false},
+		{"ftp://example.com", false},
+		{"https://example.com/?query=1", false},
+		{"https://example.com/#fragment", false},
+		{"http://%zz", false},
+	}
+
+	for _, c := range cases {
+		err := validateBaseURL(c.url)
+		if c.valid && err != nil {
+			t.Errorf("Expected %q to be a valid base URL. Instead, got %s", c.url, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("Expected %q to be rejected as a base URL", c.url)
+		}
+	}
+}
+
+func TestValidateCacheControl(t *testing.T) {
+	cases := []struct {
+		value string
+		valid bool
+	}{
+		{"public, max-age=300", true},
+		{"public, max-age=31536000, immutable", true},
+		{"no-cache", true},
+		{`private="Set-Cookie", s-maxage=60`, true},
+		{"max-age=300,stale-while-revalidate=60", true},
+		{"", false},
+		{"public,", false},
+		{"max-age", false},
+		{"max-age=-1", false},
+		{"max-age=five", false},
+		{"public; max-age=300", false},
+		{"max age=300", false},
+	}
+
+	for _, c := range cases {
+		err := validateCacheControl(c.value)
+		if c.valid && err != nil {
+			t.Errorf("Expected %q to be a valid Cache-Control. Instead, got %s", c.value, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("Expected %q to be rejected as a Cache-Control", c.value)
+		}
+	}
+}
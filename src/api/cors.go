@@ -3,11 +3,61 @@ package api
 import (
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"strings"
 )
 
+var (
+	defaultAllowedMethods = []string{"GET", "POST", "OPTIONS", "PUT", "PATCH", "DELETE"}
+	defaultAllowedHeaders = []string{"Content-Type", "Authorization", "Idempotency-Key"}
+)
+
+// Answers preflight requests
 func cors(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, DELETE")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if _, ok := allowedOrigin(r, c); ok {
+		methods, headers := c.AllowedMethods, c.AllowedHeaders
+		if len(methods) == 0 {
+			methods = defaultAllowedMethods
+		}
+		if len(headers) == 0 {
+			headers = defaultAllowedHeaders
+		}
+
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
+
+// Sets the CORS origin header on every response of the wrapped handler
+func withCORS(next handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		if origin, ok := allowedOrigin(r, c); ok {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		// When origins are restricted the header depends on the request's origin, so caches must know
+		if len(c.AllowedOrigins) > 0 {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		next(w, r, ps, c)
+	}
+}
+
+// Returns the value of the Access-Control-Allow-Origin header for a request: any origin when none are
+// configured or, otherwise, the request's origin as long as it is one of them
+func allowedOrigin(r *http.Request, c *Config) (string, bool) {
+	if len(c.AllowedOrigins) == 0 {
+		return "*", true
+	}
+
+	origin := r.Header.Get("Origin")
+	for _, allowed := range c.AllowedOrigins {
+		if origin != "" && origin == allowed {
+			return origin, true
+		}
+	}
+
+	return "", false
+}
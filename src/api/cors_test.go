@@ -20,3 +20,57 @@ func TestCORSOptions(t *testing.T) {
 	expectHeaderToContain(t, rr, "Access-Control-Allow-Methods", []string{"GET", "POST", "OPTIONS", "PUT", "PATCH", "DELETE"})
 	expectHeaderToContain(t, rr, "Access-Control-Allow-Origin", []string{"*"})
 }
+
+func corsRequest(t *testing.T, config *Config, method, origin string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, "/random", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", origin)
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCORSMatchingOrigin(t *testing.T) {
+	config := inMemoryConf()
+	config.AllowedOrigins = []string{"https://admin.example.com", "https://other.example.com"}
+	config.AllowedMethods = []string{"GET", "DELETE"}
+	config.AllowedHeaders = []string{"Content-Type", "Authorization"}
+
+	preflight := corsRequest(t, config, "OPTIONS", "https://other.example.com")
+	expectHeaderToContain(t, preflight, "Access-Control-Allow-Origin", []string{"https://other.example.com"})
+	expectHeaderToContain(t, preflight, "Access-Control-Allow-Methods", []string{"GET, DELETE"})
+	expectHeaderToContain(t, preflight, "Access-Control-Allow-Headers", []string{"Content-Type, Authorization"})
+	expectHeaderToContain(t, preflight, "Vary", []string{"Origin"})
+
+	rr := corsRequest(t, config, "GET", "https://admin.example.com")
+	expectHeaderToContain(t, rr, "Access-Control-Allow-Origin", []string{"https://admin.example.com"})
+	expectHeaderToContain(t, rr, "Vary", []string{"Origin"})
+}
+
+func TestCORSNonMatchingOrigin(t *testing.T) {
+	config := inMemoryConf()
+	config.AllowedOrigins = []string{"https://admin.example.com"}
+
+	for _, method := range []string{"OPTIONS", "GET"} {
+		rr := corsRequest(t, config, method, "https://evil.example.com")
+
+		for _, header := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers"} {
+			if value := rr.Header().Get(header); value != "" {
+				t.Errorf("Expected no %s header for a non-matching origin. Instead, got %s", header, value)
+			}
+		}
+		expectHeaderToContain(t, rr, "Vary", []string{"Origin"})
+	}
+}
+
+func TestCORSWildcardWhenNoOriginsAreConfigured(t *testing.T) {
+	rr := corsRequest(t, inMemoryConf(), "GET", "https://anyone.example.com")
+
+	expectHeaderToContain(t, rr, "Access-Control-Allow-Origin", []string{"*"})
+	if vary := rr.Header().Get("Vary"); vary != "" {
+		t.Errorf("Expected no Vary header for wildcard origins. Instead, got %s", vary)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"github.com/devlucky/fakelink/src/images"
+	"github.com/devlucky/fakelink/src/templates"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Removes a link along with the images we stored for it. Images are deleted first, so that a failure
+// leaves the link around and the deletion can be retried
+func deleteLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	slug := ps.ByName("slug")
+
+	link := c.LinkStore.Find(slug)
+	if link == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := deleteStoredImages(r.Context(), link.Values, c); err != nil {
+		errorResponse(w, http.StatusBadGateway, "The link's image could not be deleted", err, c)
+		return
+	}
+
+	c.LinkStore.Delete(slug)
+	c.renderCache().invalidate(slug)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deletes the images of the values that live in our image store. Those keyed by their content and placeholders may
+// be shown by other links too, so they are left alone
+func deleteStoredImages(ctx context.Context, values templates.Values, c *Config) error {
+	for _, candidate := range values.ImageCandidates() {
+		key, ok := storedImageKey(candidate.URL, c)
+		if !ok || images.IsContentKey(key) || images.IsPlaceholderKey(key) {
+			continue
+		}
+
+		if err := c.ImageStore.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Only images whose URL is one the store would give out are ours. Query strings are ignored, so that signed URLs
+// are told apart too
+func storedImageKey(url string, c *Config) (string, bool) {
+	if url == "" {
+		return "", false
+	}
+
+	key := path.Base(withoutQuery(url))
+	if withoutQuery(c.ImageStore.GetURL(key)) != withoutQuery(url) {
+		return "", false
+	}
+
+	return key, true
+}
+
+func withoutQuery(url string) string {
+	if i := strings.Index(url, "?"); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
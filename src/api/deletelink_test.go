@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/devlucky/fakelink/src/images"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type undeletableImageStore struct {
+	images.Store
+}
+
+func (store *undeletableImageStore) Delete(ctx context.Context, key string) error {
+	return errors.New("The store is down")
+}
+
+// Signs its URLs differently every time, as presigning S3 stores do, for them to be valid for validFor
+type presigningImageStore struct {
+	images.Store
+	validFor   time.Duration
+	signatures int
+}
+
+func (store *presigningImageStore) SignURL(key string) string {
+	store.signatures++
+	return fmt.Sprintf("%s?signature=%d", store.GetURL(key), store.signatures)
+}
+
+func (store *presigningImageStore) SignedFor() time.Duration {
+	return store.validFor
+}
+
+func deleteLinkRequest(t *testing.T, config *Config, slug string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("/links/%s", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestDeleteLink(t *testing.T) {
+	config := inMemoryConf()
+	imageURL, _ := config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	config.ImageStore.Put(context.Background(), "other-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	slug := config.LinkStore.Create(
+		&links.Link{
+			Values: templates.Values{
+				Title:  "Some title",
+				Image:  imageURL,
+				Images: []templates.Image{{URL: "http://example.com/other-image"}},
+			},
+		},
+	)
+
+	rr := deleteLinkRequest(t, config, slug)
+
+	expectStatus(t, rr, http.StatusNoContent)
+	if config.LinkStore.Find(slug) != nil {
+		t.Error("Expected DELETE /links/:slug to remove the link")
+	}
+
+	if _, err := config.ImageStore.Get(context.Background(), "some-image"); err != images.ErrNotFound {
+		t.Error("Expected DELETE /links/:slug to remove the link's stored image")
+	}
+
+	if _, err := config.ImageStore.Get(context.Background(), "other-image"); err != nil {
+		t.Error("Expected DELETE /links/:slug to keep images that are not the link's")
+	}
+}
+
+func TestDeleteLinkKeepsImagesKeyedByContent(t *testing.T) {
+	config := inMemoryConf()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	key := images.ContentKey(img)
+	imageURL, _ := config.ImageStore.Put(context.Background(), key, img)
+
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title", Image: imageURL}})
+	config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Other title", Image: imageURL}})
+
+	rr := deleteLinkRequest(t, config, slug)
+
+	expectStatus(t, rr, http.StatusNoContent)
+	if _, err := config.ImageStore.Get(context.Background(), key); err != nil {
+		t.Error("Expected DELETE /links/:slug to keep images other links may share")
+	}
+}
+
+func TestDeleteLinkKeepsSharedPlaceholders(t *testing.T) {
+	config := inMemoryConf()
+	config.PlaceholderImages = true
+
+	slugs := make([]string, 2)
+	for i := range slugs {
+		input := &postLinkInput{Link: *links.RandomLink()}
+		input.Link.Values.SiteName = "DevLucky"
+		input.Link.Values.Image = ""
+		input.Link.Values.Images = nil
+
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+		expectStatus(t, rr, http.StatusCreated)
+
+		output := &postLinkOutput{}
+		json.Unmarshal(rr.Body.Bytes(), output)
+		slugs[i] = output.Slug
+	}
+
+	rr := deleteLinkRequest(t, config, slugs[0])
+
+	expectStatus(t, rr, http.StatusNoContent)
+	if _, err := config.ImageStore.Get(context.Background(), images.PlaceholderKey("DevLucky")); err != nil {
+		t.Error("Expected DELETE /links/:slug to keep the placeholder the other link still shows")
+	}
+}
+
+func TestDeleteLinkWithPresignedImage(t *testing.T) {
+	config := inMemoryConf()
+	store := &presigningImageStore{Store: config.ImageStore, validFor: time.Hour}
+	config.ImageStore = store
+
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title", Image: store.SignURL("some-image")}})
+
+	rr := deleteLinkRequest(t, config, slug)
+
+	expectStatus(t, rr, http.StatusNoContent)
+	if _, err := config.ImageStore.Get(context.Background(), "some-image"); err != images.ErrNotFound {
+		t.Error("Expected DELETE /links/:slug to remove the link's stored image, even though its URL was signed differently")
+	}
+}
+
+func TestDeleteMissingLink(t *testing.T) {
+	rr := deleteLinkRequest(t, inMemoryConf(), "missing")
+
+	expectStatus(t, rr, http.StatusNotFound)
+}
+
+func TestDeleteLinkWhenImageStoreFails(t *testing.T) {
+	config := inMemoryConf()
+	imageURL, _ := config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	config.ImageStore = &undeletableImageStore{config.ImageStore}
+
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title", Image: imageURL}})
+
+	rr := deleteLinkRequest(t, config, slug)
+
+	expectStatus(t, rr, http.StatusBadGateway)
+	if config.LinkStore.Find(slug) == nil {
+		t.Error("Expected the link to be kept when its image could not be deleted")
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"context"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/logs"
+)
+
+// Stores that drop links on their own, such as a capped links.InMemoryStore, tell which ones they evicted
+type evictingStore interface {
+	OnEvict(fn func(slug string, link *links.Link))
+}
+
+// Evicted links take their stored images, and their cached pages, with them
+func deleteEvictedImages(c *Config) {
+	store, ok := c.LinkStore.(evictingStore)
+	if !ok {
+		return
+	}
+
+	store.OnEvict(func(slug string, link *links.Link) {
+		c.renderCache().invalidate(slug)
+
+		if err := deleteStoredImages(context.Background(), link.Values, c); err != nil {
+			c.Logger.Error("The images of an evicted link could not be deleted", err, logs.Fields{"slug": slug})
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/images"
+	"github.com/devlucky/fakelink/src/links"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvictedLinksAreGoneWithTheirImages(t *testing.T) {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+
+	config := inMemoryConf()
+	config.LinkStore = links.NewInMemoryStoreWithCapacity(2)
+	router := NewRouter(config)
+
+	var slugs, imageURLs []string
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, newPostLinkRequestWithImage(t, &postLinkInput{Link: *links.ExampleLinks[i]}, "preview.png", buf.Bytes()))
+		expectStatus(t, rr, http.StatusCreated)
+
+		output := &postLinkOutput{}
+		json.Unmarshal(rr.Body.Bytes(), output)
+		slugs = append(slugs, output.Slug)
+
+		if link := config.LinkStore.Find(output.Slug); link != nil {
+			imageURLs = append(imageURLs, link.Values.Image)
+		}
+	}
+
+	var kept []string
+	for _, slug := range slugs {
+		if config.LinkStore.Find(slug) != nil {
+			kept = append(kept, slug)
+		}
+	}
+	if len(kept) != 2 || kept[0] != slugs[1] || kept[1] != slugs[2] {
+		t.Fatalf("Expected creating a link beyond the capacity to evict exactly the oldest one. Instead, %v were kept out of %v", kept, slugs)
+	}
+
+	expectStatus(t, getLinkWithUserAgent(t, config, slugs[0], facebookUserAgent), http.StatusNotFound)
+
+	key, _ := storedImageKey(imageURLs[0], config)
+	if _, err := config.ImageStore.Get(context.Background(), key); err != images.ErrNotFound {
+		t.Error("Expected the image of the evicted link to be deleted along with it")
+	}
+}
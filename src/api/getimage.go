@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/devlucky/fakelink/src/images"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Serves a stored image in the format it is asked for, either through an extension, as in /images/:key.png, or
+// a format parameter, as in /images/:key?format=png. Keys have no dots, so anything after one is an extension
+func getImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	key := ps.ByName("key")
+	name := r.URL.Query().Get("format")
+	if ext := path.Ext(key); ext != "" {
+		key = strings.TrimSuffix(key, ext)
+		name = strings.TrimPrefix(ext, ".")
+	}
+
+	var format images.Format
+	if name != "" {
+		var err error
+		if format, err = images.ParseFormat(strings.ToLower(name)); err != nil {
+			errorResponse(w, http.StatusBadRequest, "Images can only be served as jpeg, png or gif", err, c)
+			return
+		}
+	}
+
+	serveImage(w, r, key, format, true, c)
+}
+
+// Serves the main image of a link, when it is one of ours
+func getLinkImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	link := c.LinkStore.Find(ps.ByName("slug"))
+	if link == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if link.Expired() {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	key, ok := storedImageKey(link.Values.MainImage(), c)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The main image of a link changes when the link is updated, so it is only ever revalidated through its ETag
+	serveImage(w, r, key, "", false, c)
+}
+
+// Stored images never change under their key, so they can be cached for long and any Last-Modified date is
+// accurate: the server's start time is used, as stores don't keep track of when their images were put
+const imageCacheControl = "public, max-age=31536000, immutable"
+
+var imagesLastModified = time.Now().Truncate(time.Second)
+
+// Images are converted to the given format, or by default served as GIFs when animated and as JPEGs otherwise.
+// They are tagged with a hash of their content, and conditional requests are answered with a 304 when it did not
+// change. Only immutable images, those served by their key, are cached for long and dated
+func serveImage(w http.ResponseWriter, r *http.Request, key string, format images.Format, immutable bool, c *Config) {
+	img, err := c.ImageStore.Get(r.Context(), key)
+	if err == images.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errorResponse(w, http.StatusBadGateway, "The image could not be retrieved", err, c)
+		return
+	}
+
+	if format == "" {
+		format = images.JPEG
+		if _, ok := img.(*images.Animation); ok {
+			format = images.GIF
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err = images.Encode(buf, img, format); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "The image could not be encoded", err, c)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", format.ContentType())
+	header.Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(buf.Bytes())))
+
+	lastModified := time.Time{}
+	if immutable {
+		header.Set("Cache-Control", imageCacheControl)
+		lastModified = imagesLastModified
+	}
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(buf.Bytes()))
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/devlucky/fakelink/src/images"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type failingImageStore struct {
+	images.Store
+}
+
+func (store *failingImageStore) Get(ctx context.Context, key string) (image.Image, error) {
+	return nil, errors.New("The store is down")
+}
+
+func TestGetExistingImage(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	req, err := http.NewRequest("GET", "/images/some-image", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"image/jpeg"})
+
+	img, err := jpeg.Decode(rr.Body)
+	if err != nil {
+		t.Fatalf("Expected the response to be a JPEG. Instead, decoding failed with %s", err)
+	}
+
+	if img.Bounds().Dx() != 8 || img.Bounds().Dy() != 4 {
+		t.Error("Expected the served image to be the stored one")
+	}
+}
+
+func getImageRequest(t *testing.T, config *Config, key string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/images/"+key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetImageIsCacheable(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	rr := getImageRequest(t, config, "some-image", nil)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Cache-Control", []string{"public", "max-age="})
+	if rr.Header().Get("ETag") == "" || rr.Header().Get("Last-Modified") == "" {
+		t.Errorf("Expected GET /images/:key to set an ETag and a Last-Modified date. Instead, got %v", rr.Header())
+	}
+
+	again := getImageRequest(t, config, "some-image", nil)
+	if again.Header().Get("ETag") != rr.Header().Get("ETag") {
+		t.Error("Expected the ETag of an image to stay the same across requests")
+	}
+}
+
+func TestGetImageWithMatchingETag(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	etag := getImageRequest(t, config, "some-image", nil).Header().Get("ETag")
+
+	rr := getImageRequest(t, config, "some-image", map[string]string{"If-None-Match": etag})
+
+	expectStatus(t, rr, http.StatusNotModified)
+	if rr.Body.Len() != 0 {
+		t.Error("Expected a 304 to come without a body")
+	}
+
+	rr = getImageRequest(t, config, "some-image", map[string]string{"If-None-Match": `"something-else"`})
+	expectStatus(t, rr, http.StatusOK)
+}
+
+func TestGetImageIfModifiedSince(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	lastModified := getImageRequest(t, config, "some-image", nil).Header().Get("Last-Modified")
+
+	rr := getImageRequest(t, config, "some-image", map[string]string{"If-Modified-Since": lastModified})
+	expectStatus(t, rr, http.StatusNotModified)
+
+	rr = getImageRequest(t, config, "some-image", map[string]string{"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT"})
+	expectStatus(t, rr, http.StatusOK)
+}
+
+func TestGetAnimatedImage(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{Config: image.Config{Width: 8, Height: 4, ColorModel: palette}}
+	for i := 0; i < 3; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 8, 4), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-animation", &images.Animation{GIF: anim})
+
+	req, err := http.NewRequest("GET", "/images/some-animation", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"image/gif"})
+
+	served, err := gif.DecodeAll(rr.Body)
+	if err != nil {
+		t.Fatalf("Expected the response to be a GIF. Instead, decoding failed with %s", err)
+	}
+
+	if len(served.Image) != 3 {
+		t.Errorf("Expected every frame to be served. Instead, got %d", len(served.Image))
+	}
+}
+
+func TestGetMissingImage(t *testing.T) {
+	req, err := http.NewRequest("GET", "/images/missing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusNotFound)
+}
+
+func TestGetImageWhenStoreFails(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore = &failingImageStore{}
+
+	req, err := http.NewRequest("GET", "/images/some-image", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusBadGateway)
+}
+
+func getLinkImageRequest(t *testing.T, config *Config, slug string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/links/"+slug+"/image", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetLinkImage(t *testing.T) {
+	config := inMemoryConf()
+	imageURL, _ := config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{URL: "https://example.com", Image: imageURL}})
+
+	rr := getLinkImageRequest(t, config, slug)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"image/jpeg"})
+
+	img, err := jpeg.Decode(rr.Body)
+	if err != nil {
+		t.Fatalf("Expected the response to be a JPEG. Instead, decoding failed with %s", err)
+	}
+
+	if img.Bounds().Dx() != 8 || img.Bounds().Dy() != 4 {
+		t.Error("Expected the served image to be the link's one")
+	}
+}
+
+func TestGetLinkImageIsOnlyRevalidatedThroughItsETag(t *testing.T) {
+	config := inMemoryConf()
+	imageURL, _ := config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{URL: "https://example.com", Image: imageURL}})
+
+	rr := getLinkImageRequest(t, config, slug)
+
+	expectStatus(t, rr, http.StatusOK)
+	if rr.Header().Get("ETag") == "" {
+		t.Error("Expected GET /links/:slug/image to set an ETag")
+	}
+	if rr.Header().Get("Last-Modified") != "" || rr.Header().Get("Cache-Control") != "" {
+		t.Errorf("Expected the image of a link, which changes along with it, not to be dated nor cached. Instead, got %v", rr.Header())
+	}
+}
+
+func TestGetLinkImageOfMissingLink(t *testing.T) {
+	expectStatus(t, getLinkImageRequest(t, inMemoryConf(), "missing"), http.StatusNotFound)
+}
+
+func TestGetLinkImageWithoutStoredImage(t *testing.T) {
+	config := inMemoryConf()
+
+	for _, values := range []templates.Values{
+		{URL: "https://example.com"},
+		{URL: "https://example.com", Image: "https://elsewhere.com/image.jpg"},
+		{URL: "https://example.com", Image: config.ImageStore.GetURL("deleted-image")},
+	} {
+		slug := config.LinkStore.Create(&links.Link{Values: values})
+		expectStatus(t, getLinkImageRequest(t, config, slug), http.StatusNotFound)
+	}
+}
+
+func TestGetImageAsPNG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := images.NewFileStore(dir, "http://127.0.0.1/images", images.Options{Format: images.JPEG})
+	if err != nil {
+		t.Fatalf("Unexpected error creating the file store: %s", err)
+	}
+
+	config := inMemoryConf()
+	config.ImageStore = store
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	for _, key := range []string{"some-image.png", "some-image?format=png", "some-image.PNG"} {
+		rr := getImageRequest(t, config, key, nil)
+
+		expectStatus(t, rr, http.StatusOK)
+		expectHeaderToContain(t, rr, "Content-Type", []string{"image/png"})
+
+		img, err := png.Decode(rr.Body)
+		if err != nil {
+			t.Fatalf("Expected /images/%s to be a PNG. Instead, decoding failed with %s", key, err)
+		}
+
+		if img.Bounds().Dx() != 8 || img.Bounds().Dy() != 4 {
+			t.Errorf("Expected /images/%s to be the stored image", key)
+		}
+	}
+}
+
+func TestGetImageAsGIF(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	rr := getImageRequest(t, config, "some-image.gif", nil)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"image/gif"})
+	if _, err := gif.Decode(rr.Body); err != nil {
+		t.Errorf("Expected the response to be a GIF. Instead, decoding failed with %s", err)
+	}
+}
+
+func TestGetImageInUnsupportedFormat(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	for _, key := range []string{"some-image.webp", "some-image?format=bmp"} {
+		rr := getImageRequest(t, config, key, nil)
+
+		expectStatus(t, rr, http.StatusBadRequest)
+		expectBodyToContain(t, rr, []string{"jpeg, png or gif"})
+	}
+}
+
+func TestGetMissingImageAsPNG(t *testing.T) {
+	expectStatus(t, getImageRequest(t, inMemoryConf(), "missing.png", nil), http.StatusNotFound)
+}
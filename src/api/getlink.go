@@ -1,10 +1,22 @@
 package api
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/devlucky/fakelink/src/images"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/logs"
+	"github.com/devlucky/fakelink/src/templates"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// Stored links are immutable, so the rendered page is tagged with a strong ETag that scrapers can revalidate. Pages
+// showing signed image URLs are only cached for half as long as those are valid, so they are never served expired
 func getLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
 	slug := ps.ByName("slug")
 
@@ -14,6 +26,203 @@ func getLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Co
 		return
 	}
 
+	// Expired links are kept by the store for a while to be reported as gone, but their images are cleaned up right
+	// away. The link is then stored without its values, so that later requests have nothing left to delete
+	if link.Expired() {
+		if len(link.Values.ImageCandidates()) > 0 {
+			if err := deleteStoredImages(r.Context(), link.Values, c); err != nil {
+				requestLogger(r, c).Error("Deleting the images of an expired link failed", err, logs.Fields{"slug": slug})
+			} else {
+				c.LinkStore.Update(slug, &links.Link{Private: link.Private, ExpiresAt: link.ExpiresAt})
+			}
+		}
+
+		c.renderCache().invalidate(slug)
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	// Clients that would rather have JSON, such as a frontend rendering its own preview, get the stored values.
+	// Those are neither scrapes nor clicks, so they are not recorded
+	w.Header().Set("Vary", "Accept, User-Agent")
+	if prefersJSON(r.Header.Get("Accept")) {
+		jsonResp, err := json.Marshal(signImages(link.Values, c))
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
+			return
+		}
+
+		response(w, http.StatusOK, jsonResp)
+		return
+	}
+
+	// Scrapers checking the link with a HEAD before getting it would otherwise be counted twice
+	if c.Analytics != nil && r.Method != "HEAD" {
+		if err := c.Analytics.RecordHit(slug, r.UserAgent(), time.Now()); err != nil {
+			requestLogger(r, c).Error("Recording a hit failed", err, logs.Fields{"slug": slug})
+		}
+	}
+
+	page := linkPage(r, slug, link, c)
+
+	// Scrapers get the meta tags they came for, while people opening the link are sent to where it points to
+	if target := page.EffectiveTargetURL(); target != "" {
+		switch classifyClient(r.UserAgent(), c) {
+		case browserClient:
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		case unknownClient:
+			page.RedirectURL = target
+		}
+	}
+
+	variant := page.BaseURL + " " + page.RedirectURL
+	rendered, ok := c.renderCache().get(slug, variant)
+	if !ok {
+		body, err := renderLink(link, page, c)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "The link could not be rendered", err, c)
+			return
+		}
+
+		rendered = &renderedPage{body: body.Bytes(), etag: fmt.Sprintf(`"%x"`, sha256.Sum256(body.Bytes()))}
+		if signedFor := images.SignedFor(c.ImageStore); signedFor > 0 {
+			rendered.expiresAt = time.Now().Add(signedFor / 2)
+		}
+		c.renderCache().add(slug, variant, rendered)
+	}
+
+	w.Header().Set("ETag", rendered.etag)
+	w.Header().Set("Cache-Control", previewCacheControl(c))
+
+	if etagMatches(r.Header.Get("If-None-Match"), rendered.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	htmlHeaders(w)
 	w.WriteHeader(http.StatusOK)
-	c.Template.Execute(w, link.Values)
+	w.Write(rendered.body)
+}
+
+// Links can be updated, so their previews are only cached for a little while, unless configured otherwise
+func previewCacheControl(c *Config) string {
+	if c.PreviewCacheControl == "" {
+		return DefaultPreviewCacheControl
+	}
+
+	return c.PreviewCacheControl
+}
+
+// The data a link is rendered with. Links that are not stored yet, and have no slug, have no URLs of their own
+func linkPage(r *http.Request, slug string, link *links.Link, c *Config) *templates.Page {
+	page := &templates.Page{
+		Values:  signImages(link.Values.Resolve(baseURL(r, c)), c),
+		BaseURL: baseURL(r, c),
+	}
+
+	if slug != "" {
+		page.OEmbedURL = oEmbedURL(r, slug, c)
+		page.LinkURL = linkURL(r, slug, c)
+	}
+
+	return page
+}
+
+// Image stores of private buckets give out URLs that are only valid for a while, so links keep the unsigned ones
+// and the images we stored are signed every time they are shown
+func signImages(values templates.Values, c *Config) templates.Values {
+	if images.SignedFor(c.ImageStore) == 0 {
+		return values
+	}
+
+	// Every key is signed once, so that an Image also listed in Images keeps the very same URL
+	signed := make(map[string]string)
+	sign := func(url string) string {
+		if _, ok := signed[url]; !ok {
+			signed[url] = signedImageURL(url, c)
+		}
+		return signed[url]
+	}
+
+	values.Image = sign(values.Image)
+	if values.Images != nil {
+		candidates := make([]templates.Image, len(values.Images))
+		for i, image := range values.Images {
+			image.URL = sign(image.URL)
+			candidates[i] = image
+		}
+		values.Images = candidates
+	}
+
+	return values
+}
+
+// Signs the URL of an image we stored, leaving any other URL alone
+func signedImageURL(url string, c *Config) string {
+	key, ok := storedImageKey(url, c)
+	if !ok {
+		return url
+	}
+
+	return images.SignedURL(c.ImageStore, key)
+}
+
+// Links created with a template that is no longer registered fall back to the default one
+func renderLink(link *links.Link, page *templates.Page, c *Config) (*bytes.Buffer, error) {
+	tmpl, err := c.Templates.GetByName(link.TemplateName)
+	if err != nil {
+		tmpl = c.Templates.Default()
+	}
+
+	body := new(bytes.Buffer)
+	if err = tmpl.Execute(body, page); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+type client int
+
+const (
+	unknownClient client = iota
+	scraperClient
+	browserClient
+)
+
+// User agents that look like a browser but that are automated clients, which are better off with the meta tags
+var botUserAgents = []string{"bot", "crawler", "spider", "preview", "headless"}
+
+// Tells scrapers, those configured in ScraperUserAgents, from browsers. Clients that are neither, or that
+// can't be told apart, such as the ones without a user agent, are unknown
+func classifyClient(userAgent string, c *Config) client {
+	if _, ok := matchScraper(userAgent, c); ok {
+		return scraperClient
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	if !strings.HasPrefix(userAgent, "mozilla/") {
+		return unknownClient
+	}
+
+	for _, bot := range botUserAgents {
+		if strings.Contains(userAgent, bot) {
+			return unknownClient
+		}
+	}
+
+	return browserClient
+}
+
+// Whether an If-None-Match header, which may list several tags, matches the given one
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
 }
@@ -1,12 +1,20 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/devlucky/fakelink/src/analytics"
+	"github.com/devlucky/fakelink/src/images"
 	"github.com/devlucky/fakelink/src/links"
 	"github.com/devlucky/fakelink/src/templates"
+	"html/template"
+	"image"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetExistingLink(t *testing.T) {
@@ -30,7 +38,149 @@ func TestGetExistingLink(t *testing.T) {
 	NewRouter(config).ServeHTTP(rr, req)
 
 	expectStatus(t, rr, http.StatusOK)
-	expectBodyToContain(t, rr, []string{title})
+	expectHeaderToContain(t, rr, "Content-Type", []string{"text/html; charset=utf-8"})
+	expectHeaderToContain(t, rr, "X-Content-Type-Options", []string{"nosniff"})
+	expectBodyToContain(t, rr, []string{title, "application/json+oembed", "/oembed?url="})
+}
+
+func TestGetLinkWithNamedTemplate(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(
+		&links.Link{
+			Values:       templates.Values{Title: "some-title"},
+			TemplateName: "opengraph",
+		},
+	)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{"og:title"})
+	if strings.Contains(rr.Body.String(), "twitter:card") {
+		t.Error("Expected the link to be rendered with its own template")
+	}
+}
+
+func TestGetLinkWithConfigTemplate(t *testing.T) {
+	config := inMemoryConf()
+	config.Templates.Register("brand", template.Must(template.New("brand").Parse(`<p>brand: {{.Title}}</p>`)))
+	slug := config.LinkStore.Create(
+		&links.Link{
+			Values:       templates.Values{Title: "some-title"},
+			TemplateName: "brand",
+		},
+	)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{"brand: some-title"})
+}
+
+func TestGetLinkWithRelativeURL(t *testing.T) {
+	config := inMemoryConf()
+	config.PublicBaseURL = "https://fakelink.example.com/"
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "/about"}})
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{
+		`<meta property="og:url" content="https://fakelink.example.com/about" />`,
+		`"url":"https://fakelink.example.com/about"`,
+	})
+}
+
+func TestGetLinkETag(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title"}})
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := get("")
+	expectStatus(t, first, http.StatusOK)
+	etag := first.Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("Expected a strong ETag. Instead, got %q", etag)
+	}
+
+	if again := get(""); again.Header().Get("ETag") != etag {
+		t.Error("Expected the ETag to be stable across requests")
+	}
+
+	notModified := get(`"other", ` + etag)
+	expectStatus(t, notModified, http.StatusNotModified)
+	if notModified.Body.Len() != 0 {
+		t.Errorf("Expected a 304 without body. Instead, got %s", notModified.Body.String())
+	}
+
+	modified := get(`"other"`)
+	expectStatus(t, modified, http.StatusOK)
+	expectBodyToContain(t, modified, []string{"some-title"})
+}
+
+func TestGetExpiredLink(t *testing.T) {
+	config := inMemoryConf()
+	imageURL, _ := config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	expiresAt := time.Now().Add(-time.Second)
+	slug := config.LinkStore.Create(
+		&links.Link{
+			Values:    templates.Values{Title: "some-title", Image: imageURL},
+			ExpiresAt: &expiresAt,
+		},
+	)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusGone)
+	if _, err := config.ImageStore.Get(context.Background(), "some-image"); err != images.ErrNotFound {
+		t.Error("Expected the images of expired links to be deleted")
+	}
+
+	if link := config.LinkStore.Find(slug); link == nil || link.Values.Image != "" {
+		t.Errorf("Expected the expired link to be kept without the images that were deleted. Instead, got %+v", link)
+	}
+
+	again := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(again, req)
+	expectStatus(t, again, http.StatusGone)
 }
 
 func TestGetMissingLink(t *testing.T) {
@@ -44,3 +194,226 @@ func TestGetMissingLink(t *testing.T) {
 
 	expectStatus(t, rr, http.StatusNotFound)
 }
+
+const (
+	facebookUserAgent = "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)"
+	chromeUserAgent   = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/70.0.3538.77 Safari/537.36"
+)
+
+func getLinkWithUserAgent(t *testing.T, config *Config, slug, userAgent string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetLinkServesScrapers(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	rr := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Vary", []string{"User-Agent"})
+	expectBodyToContain(t, rr, []string{`<meta property="og:title" content="some-title" />`})
+	if strings.Contains(rr.Body.String(), "http-equiv") {
+		t.Error("Expected scrapers not to be redirected by a meta refresh")
+	}
+}
+
+func TestGetLinkRedirectsBrowsers(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{
+		Title:        "some-title",
+		URL:          "https://example.com/destination",
+		CanonicalURL: "/links/some-slug",
+	}})
+
+	rr := getLinkWithUserAgent(t, config, slug, chromeUserAgent)
+
+	expectStatus(t, rr, http.StatusFound)
+	expectHeaderToContain(t, rr, "Location", []string{"https://example.com/destination"})
+	expectHeaderToContain(t, rr, "Vary", []string{"User-Agent"})
+}
+
+func TestGetLinkRedirectsBrowsersToTheTargetURL(t *testing.T) {
+	config := inMemoryConf()
+	config.PublicBaseURL = "https://fakelink.example.com"
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "/about", TargetURL: "https://example.com/elsewhere"}})
+
+	rr := getLinkWithUserAgent(t, config, slug, chromeUserAgent)
+	expectStatus(t, rr, http.StatusFound)
+	expectHeaderToContain(t, rr, "Location", []string{"https://example.com/elsewhere"})
+
+	relative := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "other-title", URL: "/about"}})
+	rr = getLinkWithUserAgent(t, config, relative, chromeUserAgent)
+	expectStatus(t, rr, http.StatusFound)
+	expectHeaderToContain(t, rr, "Location", []string{"https://fakelink.example.com/about"})
+}
+
+func TestGetLinkWithoutURLIsRenderedForBrowsers(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title"}})
+
+	rr := getLinkWithUserAgent(t, config, slug, chromeUserAgent)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{"some-title"})
+}
+
+func TestGetLinkFallsBackToMetaRefresh(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	for _, userAgent := range []string{"", "curl/7.58.0", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"} {
+		rr := getLinkWithUserAgent(t, config, slug, userAgent)
+
+		expectStatus(t, rr, http.StatusOK)
+		expectBodyToContain(t, rr, []string{
+			`<meta property="og:title" content="some-title" />`,
+			`<meta http-equiv="refresh" content="0; url=https://example.com/destination" />`,
+		})
+	}
+}
+
+func TestGetLinkWithConfiguredScrapers(t *testing.T) {
+	config := inMemoryConf()
+	config.ScraperUserAgents = []string{"Chrome"}
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	expectStatus(t, getLinkWithUserAgent(t, config, slug, chromeUserAgent), http.StatusOK)
+
+	rr := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{"http-equiv"})
+}
+
+func TestGetLinkContentNegotiation(t *testing.T) {
+	config := inMemoryConf()
+	values := templates.Values{Title: "some-title", URL: "https://example.com/destination"}
+	slug := config.LinkStore.Create(&links.Link{Values: values})
+
+	cases := []struct {
+		accept string
+		json   bool
+	}{
+		{"", false},
+		{"text/html", false},
+		{"*/*", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"application/json, text/html", false},
+		{"application/json", true},
+		{"application/json, */*;q=0.1", true},
+		{"text/html;q=0.5, application/json", true},
+		{"application/*", true},
+	}
+
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", c.accept)
+		req.Header.Set("User-Agent", facebookUserAgent)
+
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, req)
+
+		expectStatus(t, rr, http.StatusOK)
+		expectHeaderToContain(t, rr, "Vary", []string{"Accept"})
+
+		if !c.json {
+			expectHeaderToContain(t, rr, "Content-Type", []string{"text/html"})
+			continue
+		}
+
+		expectHeaderToContain(t, rr, "Content-Type", []string{"application/json"})
+		output := templates.Values{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil || output.Title != values.Title || output.URL != values.URL {
+			t.Errorf("Expected %q to get the stored values as JSON. Instead, got %s", c.accept, rr.Body.String())
+		}
+	}
+}
+
+func TestGetLinkAsJSONFromABrowser(t *testing.T) {
+	config := inMemoryConf()
+	config.Analytics = analytics.NewInMemoryRecorder()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", chromeUserAgent)
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"application/json"})
+
+	if stats, _ := config.Analytics.Stats(slug); stats.Hits != 0 {
+		t.Errorf("Expected JSON requests not to be recorded as hits. Instead, got %d", stats.Hits)
+	}
+}
+
+func TestGetLinkCacheControl(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(links.RandomLink())
+
+	rr := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	expectHeaderToContain(t, rr, "Cache-Control", []string{DefaultPreviewCacheControl})
+
+	config.PreviewCacheControl = "public, max-age=86400, immutable"
+	rr = getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	if rr.Header().Get("Cache-Control") != config.PreviewCacheControl {
+		t.Errorf("Expected previews to be served with the configured Cache-Control. Instead, got %q", rr.Header().Get("Cache-Control"))
+	}
+}
+
+func TestGetLinkSignsStoredImages(t *testing.T) {
+	config := inMemoryConf()
+	store := &presigningImageStore{Store: config.ImageStore, validFor: time.Hour}
+	config.ImageStore = store
+
+	imageURL, _ := store.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	slug := config.LinkStore.Create(&links.Link{
+		Values: templates.Values{
+			Title:  "some-title",
+			Image:  imageURL,
+			Images: []templates.Image{{URL: imageURL, Width: 8, Height: 4}, {URL: "http://example.com/other-image.jpg"}},
+		},
+	})
+
+	rr := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{imageURL + "?signature=1", "http://example.com/other-image.jpg"})
+	if store.signatures != 1 {
+		t.Errorf("Expected the stored image to be signed once, however often it is listed. Instead, it was signed %d times", store.signatures)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	rr = httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	output := templates.Values{}
+	json.Unmarshal(rr.Body.Bytes(), &output)
+	if output.Image != imageURL+"?signature=2" || output.Images[0].URL != output.Image {
+		t.Errorf("Expected the JSON values to show the stored image signed. Instead, got %s", rr.Body.String())
+	}
+
+	if link := config.LinkStore.Find(slug); link.Values.Image != imageURL || link.Values.Images[0].URL != imageURL {
+		t.Errorf("Expected the link to keep the unsigned URL. Instead, it has %+v", link.Values)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"time"
+)
+
+type linkStatsOutput struct {
+	Slug      string            `json:"slug"`
+	Hits      uint64            `json:"hits"`
+	Scrapes   uint64            `json:"scrapes"`
+	Clicks    uint64            `json:"clicks"`
+	Others    uint64            `json:"others"`
+	Scrapers  map[string]uint64 `json:"scrapers"`
+	LastHitAt *time.Time        `json:"last_hit_at"`
+}
+
+// Aggregates the hits a link got: the scrapes by the known scrapers, each on its own, the clicks by browsers,
+// and the hits from any other client. There are no stats unless an analytics recorder is configured
+func getLinkStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	slug := ps.ByName("slug")
+
+	if c.Analytics == nil || c.LinkStore.Find(slug) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	stats, err := c.Analytics.Stats(slug)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "The link's stats could not be read", err, c)
+		return
+	}
+
+	output := &linkStatsOutput{Slug: slug, Hits: stats.Hits, Scrapers: make(map[string]uint64), LastHitAt: stats.LastHitAt}
+	for userAgent, hits := range stats.UserAgents {
+		switch classifyClient(userAgent, c) {
+		case scraperClient:
+			scraper, _ := matchScraper(userAgent, c)
+			output.Scrapes += hits
+			output.Scrapers[scraper] += hits
+		case browserClient:
+			output.Clicks += hits
+		default:
+			output.Others += hits
+		}
+	}
+
+	jsonResp, err := json.Marshal(output)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
+		return
+	}
+
+	response(w, http.StatusOK, jsonResp)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/devlucky/fakelink/src/analytics"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getLinkStatsRequest(t *testing.T, config *Config, slug string) (*httptest.ResponseRecorder, *linkStatsOutput) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("/links/%s/stats", slug), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	output := &linkStatsOutput{}
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), output); err != nil {
+			t.Fatalf("Expected a JSON response. Instead, got %s", rr.Body.String())
+		}
+	}
+
+	return rr, output
+}
+
+func TestGetLinkStats(t *testing.T) {
+	config := inMemoryConf()
+	config.Analytics = analytics.NewInMemoryRecorder()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	for _, userAgent := range []string{facebookUserAgent, facebookUserAgent, "Twitterbot/1.0", chromeUserAgent, "curl/7.58.0"} {
+		getLinkWithUserAgent(t, config, slug, userAgent)
+	}
+
+	rr, output := getLinkStatsRequest(t, config, slug)
+
+	expectStatus(t, rr, http.StatusOK)
+	if output.Slug != slug || output.Hits != 5 || output.Scrapes != 3 || output.Clicks != 1 || output.Others != 1 {
+		t.Errorf("Expected the hits to be told apart into scrapes, clicks and others. Instead, got %s", rr.Body.String())
+	}
+
+	if output.Scrapers["facebookexternalhit"] != 2 || output.Scrapers["Twitterbot"] != 1 {
+		t.Errorf("Expected the scrapes to be counted per scraper. Instead, got %v", output.Scrapers)
+	}
+
+	if output.LastHitAt == nil {
+		t.Error("Expected the time of the last hit")
+	}
+}
+
+func TestGetLinkStatsWithoutHits(t *testing.T) {
+	config := inMemoryConf()
+	config.Analytics = analytics.NewInMemoryRecorder()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title"}})
+
+	rr, output := getLinkStatsRequest(t, config, slug)
+
+	expectStatus(t, rr, http.StatusOK)
+	if output.Hits != 0 || output.LastHitAt != nil {
+		t.Errorf("Expected empty stats. Instead, got %s", rr.Body.String())
+	}
+}
+
+func TestGetMissingLinkStats(t *testing.T) {
+	config := inMemoryConf()
+	config.Analytics = analytics.NewInMemoryRecorder()
+
+	rr, _ := getLinkStatsRequest(t, config, "missing")
+	expectStatus(t, rr, http.StatusNotFound)
+}
+
+func TestGetLinkStatsWithoutAnalytics(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title"}})
+	getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+
+	rr, _ := getLinkStatsRequest(t, config, slug)
+	expectStatus(t, rr, http.StatusNotFound)
+}
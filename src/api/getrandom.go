@@ -2,14 +2,19 @@ package api
 
 import (
 	"fmt"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
 )
 
+// Redirects to a random public link or, when there are none yet, renders one of the example links inline
 func getRandom(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
 	slug := c.LinkStore.FindRandom()
 	if slug == "" {
-		w.WriteHeader(http.StatusNotFound)
+		htmlHeaders(w)
+		w.WriteHeader(http.StatusOK)
+		c.Templates.Default().Execute(w, &templates.Page{Values: links.RandomLink().Values, BaseURL: baseURL(r, c)})
 		return
 	}
 
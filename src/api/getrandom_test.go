@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestGetRandom(t *testing.T) {
@@ -39,5 +40,24 @@ func TestGetRandomWhenStoreIsEmpty(t *testing.T) {
 	rr := httptest.NewRecorder()
 	NewRouter(inMemoryConf()).ServeHTTP(rr, req)
 
-	expectStatus(t, rr, http.StatusNotFound)
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"text/html; charset=utf-8"})
+	expectBodyToContain(t, rr, []string{"og:title", "</html>"})
+}
+
+func TestGetRandomLeavesOutExpiredLinks(t *testing.T) {
+	config := inMemoryConf()
+	expiresAt := time.Now().Add(-time.Second)
+	config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title"}, ExpiresAt: &expiresAt})
+
+	req, err := http.NewRequest("GET", "/random", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{"og:title", "</html>"})
 }
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"compress/gzip"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compresses the responses of clients that accept gzip. Images are left alone, as they are compressed already
+func withGzip(next handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next(w, r, ps, c)
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: w}
+		defer writer.close()
+		next(writer, r, ps, c)
+	}
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(coding, ";")
+		if strings.ToLower(strings.TrimSpace(params[0])) != "gzip" {
+			continue
+		}
+
+		// An explicit zero quality means gzip is not acceptable
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				return err != nil || q > 0
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// Decides whether to compress once the handler is done setting the headers, when the status is written
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gzip        *gzip.Writer
+	wroteHeader bool
+}
+
+// Passes the error along to the middlewares further out
+func (writer *gzipResponseWriter) recordError(err error) {
+	if outer, ok := writer.ResponseWriter.(errorRecorder); ok {
+		outer.recordError(err)
+	}
+}
+
+func (writer *gzipResponseWriter) WriteHeader(status int) {
+	if writer.wroteHeader {
+		return
+	}
+	writer.wroteHeader = true
+
+	header := writer.Header()
+	if vary := header.Get("Vary"); vary != "" {
+		header.Set("Vary", vary+", Accept-Encoding")
+	} else {
+		header.Set("Vary", "Accept-Encoding")
+	}
+
+	if compressible(status, header) {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+
+		// The compressed body is a different representation, which a strong ETag would have to tell apart
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		writer.gzip = gzip.NewWriter(writer.ResponseWriter)
+	}
+
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !writer.wroteHeader {
+		if writer.Header().Get("Content-Type") == "" {
+			writer.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		writer.WriteHeader(http.StatusOK)
+	}
+
+	if writer.gzip != nil {
+		return writer.gzip.Write(data)
+	}
+
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *gzipResponseWriter) close() {
+	if writer.gzip != nil {
+		writer.gzip.Close()
+	}
+}
+
+// Responses without a body, or whose body is already compressed, are sent as they are
+func compressible(status int, header http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	return !strings.HasPrefix(header.Get("Content-Type"), "image/")
+}
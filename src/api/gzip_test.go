@@ -0,0 +1,101 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getWithGzip(t *testing.T, config *Config, path string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", facebookUserAgent)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetLinkCompressed(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	rr := getWithGzip(t, config, "/links/"+slug)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Encoding", []string{"gzip"})
+	expectHeaderToContain(t, rr, "Content-Type", []string{"text/html"})
+	expectHeaderToContain(t, rr, "Vary", []string{"Accept-Encoding"})
+
+	reader, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("Expected the body to be gzip compressed. Instead, reading it failed with %s", err)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), "some-title") {
+		t.Errorf("Expected the decompressed body to contain the title. Instead, it was %s", body)
+	}
+}
+
+func TestGetLinkUncompressed(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	rr := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+
+	expectStatus(t, rr, http.StatusOK)
+	if encoding := rr.Header().Get("Content-Encoding"); encoding != "" {
+		t.Errorf("Expected no Content-Encoding for clients that don't accept gzip. Instead, got %s", encoding)
+	}
+	expectBodyToContain(t, rr, []string{"some-title"})
+}
+
+func TestGetImageNotCompressed(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	rr := getWithGzip(t, config, "/images/some-image")
+
+	expectStatus(t, rr, http.StatusOK)
+	if encoding := rr.Header().Get("Content-Encoding"); encoding != "" {
+		t.Errorf("Expected images not to be compressed. Instead, got Content-Encoding %s", encoding)
+	}
+
+	if _, err := jpeg.Decode(rr.Body); err != nil {
+		t.Errorf("Expected the response to be a plain JPEG. Instead, decoding failed with %s", err)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, GZIP":     true,
+		"gzip;q=0.5, br":    true,
+		"gzip;q=0":          false,
+		"br, gzip ; q=0.0":  false,
+		"deflate, identity": false,
+	}
+
+	for acceptEncoding, expected := range cases {
+		if acceptsGzip(acceptEncoding) != expected {
+			t.Errorf("Expected acceptsGzip(%q) to be %t", acceptEncoding, expected)
+		}
+	}
+}
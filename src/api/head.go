@@ -0,0 +1,69 @@
+package api
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+// Answers HEAD requests through the GET handler, with the headers it would have sent but without the body.
+// The body is counted rather than written, so that the Content-Length can be told even when the handler did not
+// set it, as when rendering a link, unless the body is encoded
+func withHead(next handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		if r.Method != "HEAD" {
+			next(w, r, ps, c)
+			return
+		}
+
+		counter := &bodyCounter{ResponseWriter: w}
+		next(counter, r, ps, c)
+		counter.flush()
+	}
+}
+
+// Holds on to the status until the handler is done, counting the bytes of the body instead of writing them
+type bodyCounter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+// Passes errors along to the middlewares further out
+func (counter *bodyCounter) recordError(err error) {
+	if outer, ok := counter.ResponseWriter.(errorRecorder); ok {
+		outer.recordError(err)
+	}
+}
+
+func (counter *bodyCounter) WriteHeader(status int) {
+	if counter.status == 0 {
+		counter.status = status
+	}
+}
+
+func (counter *bodyCounter) Write(data []byte) (int, error) {
+	if counter.status == 0 {
+		counter.status = http.StatusOK
+	}
+	counter.length += len(data)
+	return len(data), nil
+}
+
+func (counter *bodyCounter) flush() {
+	if counter.status == 0 {
+		counter.status = http.StatusOK
+	}
+
+	// Encoded bodies, such as compressed ones, are streamed by GET without a length, so HEAD doesn't tell one either
+	header := counter.Header()
+	if header.Get("Content-Length") == "" && header.Get("Content-Encoding") == "" && bodyAllowed(counter.status) {
+		header.Set("Content-Length", strconv.Itoa(counter.length))
+	}
+	counter.ResponseWriter.WriteHeader(counter.status)
+}
+
+// Informational, 204 and 304 responses never have a body, nor a length
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
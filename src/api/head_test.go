@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"github.com/devlucky/fakelink/src/analytics"
+	"github.com/devlucky/fakelink/src/links"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func headRequest(t *testing.T, config *Config, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("HEAD", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func expectSameHeaders(t *testing.T, head, get *httptest.ResponseRecorder, names []string) {
+	for _, name := range names {
+		if head.Header().Get(name) != get.Header().Get(name) {
+			t.Errorf("Expected HEAD to answer with the %s of GET, %q. Instead, got %q", name, get.Header().Get(name), head.Header().Get(name))
+		}
+	}
+}
+
+func TestHeadLink(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(links.RandomLink())
+
+	get := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	head := headRequest(t, config, "/links/"+slug, map[string]string{"User-Agent": facebookUserAgent})
+
+	expectStatus(t, head, http.StatusOK)
+	expectSameHeaders(t, head, get, []string{"Content-Type", "ETag"})
+	if head.Header().Get("Content-Length") != strconv.Itoa(get.Body.Len()) {
+		t.Errorf("Expected HEAD to tell the length of the page, %d. Instead, got %q", get.Body.Len(), head.Header().Get("Content-Length"))
+	}
+
+	if head.Body.Len() != 0 {
+		t.Errorf("Expected HEAD to answer without a body. Instead, got %d bytes", head.Body.Len())
+	}
+}
+
+func TestHeadLinkWithGzip(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(links.RandomLink())
+	headers := map[string]string{"User-Agent": facebookUserAgent, "Accept-Encoding": "gzip"}
+
+	head := headRequest(t, config, "/links/"+slug, headers)
+
+	expectStatus(t, head, http.StatusOK)
+	expectHeaderToContain(t, head, "Content-Encoding", []string{"gzip"})
+	if head.Body.Len() != 0 {
+		t.Errorf("Expected HEAD to answer without a body. Instead, got %d bytes", head.Body.Len())
+	}
+}
+
+func TestHeadLinkWithGzipHasTheHeadersOfGet(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(links.RandomLink())
+	headers := map[string]string{"User-Agent": facebookUserAgent, "Accept-Encoding": "gzip"}
+
+	req, err := http.NewRequest("GET", "/links/"+slug, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	get := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(get, req)
+
+	head := headRequest(t, config, "/links/"+slug, headers)
+
+	expectStatus(t, head, http.StatusOK)
+	expectSameHeaders(t, head, get, []string{"Content-Type", "Content-Encoding", "Content-Length", "ETag", "Vary"})
+}
+
+func TestHeadLinkIsNotAHit(t *testing.T) {
+	config := inMemoryConf()
+	config.Analytics = analytics.NewInMemoryRecorder()
+	slug := config.LinkStore.Create(links.RandomLink())
+
+	headRequest(t, config, "/links/"+slug, map[string]string{"User-Agent": facebookUserAgent})
+
+	stats, err := config.Analytics.Stats(slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 0 {
+		t.Errorf("Expected HEAD not to be recorded as a hit. Instead, got %d hits", stats.Hits)
+	}
+}
+
+func TestHeadMissingLink(t *testing.T) {
+	head := headRequest(t, inMemoryConf(), "/links/missing", nil)
+
+	expectStatus(t, head, http.StatusNotFound)
+}
+
+func TestHeadImage(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	get := getImageRequest(t, config, "some-image", nil)
+	head := headRequest(t, config, "/images/some-image", nil)
+
+	expectStatus(t, head, http.StatusOK)
+	expectSameHeaders(t, head, get, []string{"Content-Type", "Content-Length", "ETag", "Cache-Control"})
+	if head.Header().Get("Content-Length") != strconv.Itoa(get.Body.Len()) {
+		t.Errorf("Expected HEAD to tell the length of the image, %d. Instead, got %q", get.Body.Len(), head.Header().Get("Content-Length"))
+	}
+
+	if head.Body.Len() != 0 {
+		t.Errorf("Expected HEAD to answer without a body. Instead, got %d bytes", head.Body.Len())
+	}
+}
+
+func TestHeadImageWithMatchingETag(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	etag := getImageRequest(t, config, "some-image", nil).Header().Get("ETag")
+
+	head := headRequest(t, config, "/images/some-image", map[string]string{"If-None-Match": etag})
+
+	expectStatus(t, head, http.StatusNotModified)
+}
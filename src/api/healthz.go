@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/logs"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthzOutput struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// Reports whether the stores the API depends on are reachable, so that unhealthy instances can be taken
+// out of rotation. Answers 503 when any of them is down
+func healthz(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	output := &healthzOutput{Status: "ok", Checks: make(map[string]healthCheck)}
+	status := http.StatusOK
+
+	for name, ping := range map[string]func() error{"links": c.LinkStore.Ping, "images": c.ImageStore.Ping} {
+		check := healthCheck{Status: "ok"}
+		if err := ping(); err != nil {
+			requestLogger(r, c).Error("A store is unreachable", err, logs.Fields{"store": name})
+
+			check.Status = "down"
+			if c.DebugMode {
+				check.Error = err.Error()
+			}
+			output.Status = "down"
+			status = http.StatusServiceUnavailable
+		}
+
+		output.Checks[name] = check
+	}
+
+	jsonResp, err := json.Marshal(output)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
+		return
+	}
+
+	response(w, status, jsonResp)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/devlucky/fakelink/src/images"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type unreachableImageStore struct {
+	images.Store
+}
+
+func (store *unreachableImageStore) Ping() error {
+	return errors.New("connection refused")
+}
+
+func healthzRequest(t *testing.T, config *Config) (*httptest.ResponseRecorder, *healthzOutput) {
+	req, err := http.NewRequest("GET", "/healthz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	output := &healthzOutput{}
+	if err := json.Unmarshal(rr.Body.Bytes(), output); err != nil {
+		t.Fatalf("Unexpected error unmarshaling the health status: %s", err)
+	}
+
+	return rr, output
+}
+
+func TestHealthz(t *testing.T) {
+	rr, output := healthzRequest(t, inMemoryConf())
+
+	expectStatus(t, rr, http.StatusOK)
+	if output.Status != "ok" || output.Checks["links"].Status != "ok" || output.Checks["images"].Status != "ok" {
+		t.Errorf("Expected every store to be reported as ok. Instead, got %+v", output)
+	}
+}
+
+func TestHealthzWithAStoreDown(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore = &unreachableImageStore{config.ImageStore}
+
+	rr, output := healthzRequest(t, config)
+
+	expectStatus(t, rr, http.StatusServiceUnavailable)
+	if output.Status != "down" || output.Checks["images"].Status != "down" {
+		t.Errorf("Expected the image store to be reported as down. Instead, got %+v", output)
+	}
+	if output.Checks["links"].Status != "ok" {
+		t.Errorf("Expected the link store to still be reported as ok. Instead, got %+v", output)
+	}
+	if output.Checks["images"].Error != "connection refused" {
+		t.Errorf("Expected the error to be reported in debug mode. Instead, got %q", output.Checks["images"].Error)
+	}
+}
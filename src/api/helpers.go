@@ -2,22 +2,39 @@ package api
 
 import (
 	"encoding/json"
+	"github.com/devlucky/fakelink/src/links"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 func response(w http.ResponseWriter, status int, json []byte) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	w.Write([]byte(json))
 }
 
+// Rendered previews must be recognized as HTML, otherwise some scrapers skip their meta tags
+func htmlHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
 type errorResponseOutput struct {
 	Message      string `json:"message"`
 	DebugMessage string `json:"debug_mesage"`
 }
 
+// Implemented by the response writers of middlewares that want to know why a request failed
+type errorRecorder interface {
+	recordError(err error)
+}
+
 func errorResponse(w http.ResponseWriter, status int, message string, err error, c *Config) {
+	if recorder, ok := w.(errorRecorder); ok {
+		recorder.recordError(err)
+	}
+
 	output := &errorResponseOutput{Message: message}
 	if c.DebugMode {
 		output.DebugMessage = err.Error()
@@ -30,3 +47,99 @@ func errorResponse(w http.ResponseWriter, status int, message string, err error,
 
 	response(w, status, jsonResp)
 }
+
+type validationErrorOutput struct {
+	Message      string             `json:"message"`
+	Errors       []links.FieldError `json:"errors"`
+	DebugMessage string             `json:"debug_mesage,omitempty"`
+}
+
+// Responds with a 400 listing every invalid field of a link, such as {"errors": [{"field": "url", "message": "required"}]}
+func validationErrorResponse(w http.ResponseWriter, fields []links.FieldError, err error, c *Config) {
+	if recorder, ok := w.(errorRecorder); ok {
+		recorder.recordError(err)
+	}
+
+	output := &validationErrorOutput{Message: "The link is invalid", Errors: fields}
+	if c.DebugMode {
+		output.DebugMessage = err.Error()
+	}
+
+	jsonResp, err := json.Marshal(output)
+	if err != nil {
+		jsonResp = []byte("{}")
+	}
+
+	response(w, http.StatusBadRequest, jsonResp)
+}
+
+// The URL the API is publicly reachable at: the configured one or, when missing, the one the request was addressed
+// to. The scheme the client used is only taken from X-Forwarded-Proto behind our proxy, as any client could forge it.
+// Servers set up from the environment always have a PublicBaseURL, so that clients can't choose the host of the
+// URLs in the previews they get cached
+func baseURL(r *http.Request, c *Config) string {
+	if c.PublicBaseURL != "" {
+		return strings.TrimSuffix(c.PublicBaseURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil || (c.BehindProxy && r.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// The shareable URL of a link
+func linkURL(r *http.Request, slug string, c *Config) string {
+	return baseURL(r, c) + "/links/" + slug
+}
+
+// Whether the Accept header prefers JSON over HTML. It is ambiguous when missing, or when both are equally
+// acceptable, as with */*, in which case HTML wins
+func prefersJSON(accept string) bool {
+	return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+}
+
+// The quality the Accept header gives to a media type, taken from its most specific matching range as described
+// in https://tools.ietf.org/html/rfc7231#section-5.3.2. Without an Accept header, every type is acceptable
+func acceptQuality(accept, mediaType string) float64 {
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+
+	mainType := strings.SplitN(mediaType, "/", 2)[0]
+	quality, specificity := 0.0, -1
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		rangeType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		rangeSpecificity := -1
+		switch rangeType {
+		case mediaType:
+			rangeSpecificity = 2
+		case mainType + "/*":
+			rangeSpecificity = 1
+		case "*/*":
+			rangeSpecificity = 0
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+
+		rangeQuality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					rangeQuality = q
+				}
+			}
+		}
+
+		quality, specificity = rangeQuality, rangeSpecificity
+	}
+
+	return quality
+}
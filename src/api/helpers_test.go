@@ -14,7 +14,7 @@ import (
 	GENERIC TEST HELPERS
 */
 func expectStatus(t *testing.T, rr *httptest.ResponseRecorder, status int) {
-	if status := rr.Code; status != status {
+	if rr.Code != status {
 		t.Errorf("Expected status to be %d. Instead, it was %d", status, rr.Code)
 	}
 }
@@ -44,10 +44,11 @@ func expectBodyToContain(t *testing.T, rr *httptest.ResponseRecorder, values []s
 func TestResponse(t *testing.T) {
 	endpoint := "/test_success"
 
-	router := NewRouter(inMemoryConf())
-	router.GET(endpoint, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	conf := inMemoryConf()
+	router := NewRouter(conf)
+	router.GET(endpoint, injectConfig(conf, chain(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
 		response(w, http.StatusTeapot, []byte("{\"some\": \"json\"}"))
-	})
+	}, withCORS)))
 
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
@@ -77,9 +78,9 @@ func testErrorResponseDebugMode(t *testing.T, debugMode bool) {
 	conf.DebugMode = debugMode
 
 	router := NewRouter(conf)
-	router.GET(endpoint, injectConfig(conf, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	router.GET(endpoint, injectConfig(conf, chain(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
 		errorResponse(w, http.StatusNotAcceptable, apiMessage, internalMessage, c)
-	}))
+	}, withCORS)))
 
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
@@ -111,3 +112,28 @@ func testErrorResponseDebugMode(t *testing.T, debugMode bool) {
 		t.Errorf("Expected error's debug message to be empty when debug mode is not on. Instead, it was %s", resp.DebugMessage)
 	}
 }
+
+func TestBaseURLOnlyTrustsForwardedProtoBehindProxy(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://fakelink.example/links/abc", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	config := inMemoryConf()
+	if url := baseURL(req, config); url != "http://fakelink.example" {
+		t.Errorf("Expected X-Forwarded-Proto to be ignored when not behind a proxy. Instead, got %s", url)
+	}
+
+	config.BehindProxy = true
+	if url := baseURL(req, config); url != "https://fakelink.example" {
+		t.Errorf("Expected the scheme to be taken from X-Forwarded-Proto behind a proxy. Instead, got %s", url)
+	}
+}
+
+func TestBaseURLIgnoresTheHostWithPublicBaseURL(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://evil.example/links/abc", nil)
+
+	config := inMemoryConf()
+	config.PublicBaseURL = "https://fakelink.example.com/"
+	if url := baseURL(req, config); url != "https://fakelink.example.com" {
+		t.Errorf("Expected the configured base URL to be used whatever the Host. Instead, got %s", url)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long the response to a request with an Idempotency-Key is replayed for, if the Config
+// does not say.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+const (
+	// Keys are pruned once there are this many, so that the cache's memory stays bounded
+	maxIdempotencyKeys = 10000
+	// Longer keys are rejected rather than kept around
+	maxIdempotencyKeyLength = 255
+)
+
+// The responses to requests that carried an Idempotency-Key, by key. Keys are scoped by the API key of the
+// request, so that clients can't replay each other's responses
+type idempotencyCache struct {
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[string]*idempotentResponse
+	now     func() time.Time
+}
+
+// A response to replay. Until the first request with its key is answered, it is pending
+type idempotentResponse struct {
+	pending  bool
+	status   int
+	location string
+	body     []byte
+	expires  time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+
+	return &idempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]*idempotentResponse),
+		now:     time.Now,
+	}
+}
+
+// Returns the response already given to the key, if any, or reserves the key for the caller to answer
+func (cache *idempotencyCache) begin(key string) (previous *idempotentResponse, reserved bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	now := cache.now()
+	if len(cache.entries) >= maxIdempotencyKeys {
+		cache.prune(now)
+	}
+
+	if entry, ok := cache.entries[key]; ok && now.Before(entry.expires) {
+		return entry, false
+	}
+
+	cache.entries[key] = &idempotentResponse{pending: true, expires: now.Add(cache.ttl)}
+	return nil, true
+}
+
+// Keeps the response given to a reserved key, to be replayed until the key expires
+func (cache *idempotencyCache) complete(key string, status int, location string, body []byte) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries[key] = &idempotentResponse{status: status, location: location, body: body, expires: cache.now().Add(cache.ttl)}
+}
+
+// Frees a reserved key, so that the request can be retried
+func (cache *idempotencyCache) release(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	delete(cache.entries, key)
+}
+
+func (cache *idempotencyCache) prune(now time.Time) {
+	for key, entry := range cache.entries {
+		if !now.Before(entry.expires) {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+// Replays the response to the first request with the same Idempotency-Key, rather than handling retries again.
+// Only successful creations are remembered, so failed requests can be retried with the same key. Retries sent while
+// the first request is still being handled get a 409
+func idempotency(ttl time.Duration) middleware {
+	cache := newIdempotencyCache(ttl)
+	return func(next handler) handler {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" {
+				next(w, r, ps, c)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				errorResponse(w, http.StatusBadRequest, "The Idempotency-Key header is too long", errors.New("Idempotency key too long"), c)
+				return
+			}
+
+			token, _ := bearerToken(r)
+			key = token + "\x00" + key
+
+			previous, reserved := cache.begin(key)
+			if !reserved && previous.pending {
+				errorResponse(w, http.StatusConflict, "A request with the same Idempotency-Key is still being handled", errors.New("Idempotency key in use"), c)
+				return
+			}
+			if !reserved {
+				if previous.location != "" {
+					w.Header().Set("Location", previous.location)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				response(w, previous.status, previous.body)
+				return
+			}
+
+			// The key is released even when the handler panics
+			recorder := &responseCapture{ResponseWriter: w}
+			defer func() {
+				if recorder.status == http.StatusCreated {
+					cache.complete(key, recorder.status, w.Header().Get("Location"), recorder.body.Bytes())
+				} else {
+					cache.release(key)
+				}
+			}()
+
+			next(recorder, r, ps, c)
+		}
+	}
+}
+
+// Writes a response through while keeping a copy of its status code and body
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// Passes errors along to the middlewares further out
+func (capture *responseCapture) recordError(err error) {
+	if outer, ok := capture.ResponseWriter.(errorRecorder); ok {
+		outer.recordError(err)
+	}
+}
+
+func (capture *responseCapture) WriteHeader(status int) {
+	if capture.status == 0 {
+		capture.status = status
+	}
+	capture.ResponseWriter.WriteHeader(status)
+}
+
+func (capture *responseCapture) Write(data []byte) (int, error) {
+	if capture.status == 0 {
+		capture.status = http.StatusOK
+	}
+	capture.body.Write(data)
+	return capture.ResponseWriter.Write(data)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/links"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func postLinkWithIdempotencyKey(t *testing.T, router http.Handler, input *postLinkInput, key string) *httptest.ResponseRecorder {
+	req := newPostLinkRequest(t, input)
+	req.Header.Set("Idempotency-Key", key)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func countLinks(t *testing.T, config *Config) int {
+	entries, _, err := config.LinkStore.List("", 100)
+	if err != nil {
+		t.Fatal("Unexpected error listing the links", err)
+	}
+	return len(entries)
+}
+
+func TestPostLinkWithTheSameIdempotencyKey(t *testing.T) {
+	config := inMemoryConf()
+	router := NewRouter(config)
+	input := &postLinkInput{Link: *links.RandomLink()}
+
+	first := postLinkWithIdempotencyKey(t, router, input, "retry-me")
+	second := postLinkWithIdempotencyKey(t, router, input, "retry-me")
+
+	expectStatus(t, first, http.StatusCreated)
+	expectStatus(t, second, http.StatusCreated)
+	expectHeaderToContain(t, second, "Idempotent-Replayed", []string{"true"})
+
+	firstOutput, secondOutput := &postLinkOutput{}, &postLinkOutput{}
+	json.Unmarshal(first.Body.Bytes(), firstOutput)
+	json.Unmarshal(second.Body.Bytes(), secondOutput)
+	if firstOutput.Slug == "" || firstOutput.Slug != secondOutput.Slug {
+		t.Errorf("Expected a retry with the same Idempotency-Key to get the same slug. Instead, got %q and %q", firstOutput.Slug, secondOutput.Slug)
+	}
+	if second.Header().Get("Location") != first.Header().Get("Location") {
+		t.Error("Expected the replayed response to point to the same link")
+	}
+
+	if n := countLinks(t, config); n != 1 {
+		t.Errorf("Expected a single link to be stored. Instead, there are %d", n)
+	}
+}
+
+func TestPostLinkWithDifferentIdempotencyKeys(t *testing.T) {
+	config := inMemoryConf()
+	router := NewRouter(config)
+
+	expectStatus(t, postLinkWithIdempotencyKey(t, router, &postLinkInput{Link: *links.ExampleLinks[0]}, "first"), http.StatusCreated)
+	expectStatus(t, postLinkWithIdempotencyKey(t, router, &postLinkInput{Link: *links.ExampleLinks[1]}, "second"), http.StatusCreated)
+
+	if n := countLinks(t, config); n != 2 {
+		t.Errorf("Expected a link per Idempotency-Key. Instead, there are %d", n)
+	}
+}
+
+func TestPostLinkRetriesFailedIdempotentRequests(t *testing.T) {
+	config := inMemoryConf()
+	router := NewRouter(config)
+
+	invalid := &postLinkInput{Link: links.Link{}}
+	expectStatus(t, postLinkWithIdempotencyKey(t, router, invalid, "retry-me"), http.StatusBadRequest)
+
+	rr := postLinkWithIdempotencyKey(t, router, &postLinkInput{Link: *links.RandomLink()}, "retry-me")
+	expectStatus(t, rr, http.StatusCreated)
+	if rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("Expected failed requests not to be replayed")
+	}
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	cache := newIdempotencyCache(time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	if _, reserved := cache.begin("some-key"); !reserved {
+		t.Fatal("Expected an unknown key to be reserved")
+	}
+
+	if previous, reserved := cache.begin("some-key"); reserved || !previous.pending {
+		t.Error("Expected a key that is still being handled to be reported as pending")
+	}
+
+	cache.complete("some-key", http.StatusCreated, "", []byte("{}"))
+	if previous, reserved := cache.begin("some-key"); reserved || previous.pending || previous.status != http.StatusCreated {
+		t.Errorf("Expected the response given to the key to be replayed. Instead, got %+v", previous)
+	}
+
+	now = now.Add(time.Minute)
+	if _, reserved := cache.begin("some-key"); !reserved {
+		t.Error("Expected keys to be forgotten once they expire")
+	}
+}
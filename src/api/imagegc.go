@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/devlucky/fakelink/src/logs"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type imageGCOutput struct {
+	Images  int `json:"images"`
+	Deleted int `json:"deleted"`
+	Pending int `json:"pending"`
+}
+
+// Collections delete images, which nobody but the API's own clients should be able to do. They are therefore
+// refused unless API keys are configured, as POST /admin/gc would be open to anyone otherwise
+var errImageGCWithoutAPIKeys = errors.New("Orphaned images are only collected when API keys are configured")
+
+// The images the last collection found no link showing, which the next one deletes if it still finds none
+type orphanedImages struct {
+	mutex sync.Mutex
+	keys  map[string]bool
+}
+
+// Deletes the stored images no link shows anymore, such as those of links that expired, and answers with how many
+// images there were, how many of them went away and how many are left for the next collection
+func collectImages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	output, err := collectImageGarbage(r.Context(), c)
+	if err == errImageGCWithoutAPIKeys {
+		errorResponse(w, http.StatusForbidden, "Orphaned images can only be collected when API_KEYS is set", err, c)
+		return
+	}
+	if err != nil {
+		errorResponse(w, http.StatusBadGateway, "The orphaned images could not be collected", err, c)
+		return
+	}
+
+	jsonResp, err := json.Marshal(output)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
+		return
+	}
+
+	response(w, http.StatusOK, jsonResp)
+}
+
+// An image is only deleted once two collections in a row found no link showing it. Links are created after their
+// image is stored, so an image whose link is being created while a collection lists the links is spared by it, and
+// shown by the time of the next one. Collections don't overlap, so that each of them sees what the previous one left.
+// Only the images the store lists are considered, so a bucket shared with other applications is left alone outside
+// of the store's key prefix
+func collectImageGarbage(ctx context.Context, c *Config) (*imageGCOutput, error) {
+	if len(c.APIKeys) == 0 {
+		return nil, errImageGCWithoutAPIKeys
+	}
+
+	c.orphans.mutex.Lock()
+	defer c.orphans.mutex.Unlock()
+
+	keys, err := c.ImageStore.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := c.LinkStore.All()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, entry := range entries {
+		for _, candidate := range entry.Link.Values.ImageCandidates() {
+			if key, ok := storedImageKey(candidate.URL, c); ok {
+				referenced[key] = true
+			}
+		}
+	}
+
+	output := &imageGCOutput{Images: len(keys)}
+	orphans := make(map[string]bool)
+	for _, key := range keys {
+		if referenced[key] {
+			continue
+		}
+
+		if !c.orphans.keys[key] {
+			orphans[key] = true
+			output.Pending++
+			continue
+		}
+
+		if err = c.ImageStore.Delete(ctx, key); err != nil {
+			return output, err
+		}
+		output.Deleted++
+	}
+
+	c.orphans.keys = orphans
+	return output, nil
+}
+
+// Collects the orphaned images every interval, until the returned function is called. A zero interval never does,
+// and neither does a server without API keys
+func collectImagesEvery(interval time.Duration, c *Config) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	if len(c.APIKeys) == 0 {
+		c.Logger.Error("Not collecting the orphaned images", errImageGCWithoutAPIKeys, logs.Fields{"interval": interval.String()})
+		return func() {}
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				output, err := collectImageGarbage(context.Background(), c)
+				if err != nil {
+					c.Logger.Error("Collecting the orphaned images failed", err, logs.Fields{})
+					continue
+				}
+				c.Logger.Info("Collected the orphaned images", logs.Fields{"images": output.Images, "deleted": output.Deleted, "pending": output.Pending})
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"github.com/devlucky/fakelink/src/images"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Collections only run when API keys are configured
+func imageGCConf() *Config {
+	config := inMemoryConf()
+	config.APIKeys = []string{"secret"}
+	return config
+}
+
+func collectImagesRequest(t *testing.T, config *Config) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/admin/gc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCollectImages(t *testing.T) {
+	config := imageGCConf()
+	imageURL, _ := config.ImageStore.Put(context.Background(), "referenced-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	privateURL, _ := config.ImageStore.Put(context.Background(), "private-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	config.ImageStore.Put(context.Background(), "orphaned-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title", Image: imageURL}})
+	config.LinkStore.Create(&links.Link{Private: true, Values: templates.Values{Title: "Other title", Images: []templates.Image{{URL: privateURL}}}})
+
+	first := collectImagesRequest(t, config)
+
+	expectStatus(t, first, http.StatusOK)
+	expectBodyToContain(t, first, []string{`"images":3`, `"deleted":0`, `"pending":1`})
+	if _, err := config.ImageStore.Get(context.Background(), "orphaned-image"); err != nil {
+		t.Error("Expected POST /admin/gc to keep the images found orphaned for the first time")
+	}
+
+	second := collectImagesRequest(t, config)
+
+	expectStatus(t, second, http.StatusOK)
+	expectBodyToContain(t, second, []string{`"images":3`, `"deleted":1`, `"pending":0`})
+	if _, err := config.ImageStore.Get(context.Background(), "orphaned-image"); err != images.ErrNotFound {
+		t.Error("Expected POST /admin/gc to delete the images no link showed twice in a row")
+	}
+
+	for _, key := range []string{"referenced-image", "private-image"} {
+		if _, err := config.ImageStore.Get(context.Background(), key); err != nil {
+			t.Errorf("Expected POST /admin/gc to keep %s, which a link shows", key)
+		}
+	}
+}
+
+// The image of a link is stored before the link is created, so a collection may find no link showing it yet
+func TestCollectImagesSparesImagesOfLinksBeingCreated(t *testing.T) {
+	config := imageGCConf()
+	imageURL, _ := config.ImageStore.Put(context.Background(), "new-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	if _, err := collectImageGarbage(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title", Image: imageURL}})
+
+	output, err := collectImageGarbage(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output.Deleted != 0 || output.Pending != 0 {
+		t.Errorf("Expected nothing to be deleted nor left pending. Instead, got %+v", output)
+	}
+	if _, err := config.ImageStore.Get(context.Background(), "new-image"); err != nil {
+		t.Error("Expected the image of a link created in between collections to be kept")
+	}
+}
+
+func TestCollectImagesRequiresAnAPIKey(t *testing.T) {
+	config := imageGCConf()
+	config.APIKeys = []string{"other-secret"}
+	config.ImageStore.Put(context.Background(), "orphaned-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	rr := collectImagesRequest(t, config)
+
+	expectStatus(t, rr, http.StatusUnauthorized)
+	if _, err := config.ImageStore.Get(context.Background(), "orphaned-image"); err != nil {
+		t.Error("Expected POST /admin/gc to delete nothing without an API key")
+	}
+}
+
+func TestCollectImagesWithoutAPIKeys(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "orphaned-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	for i := 0; i < 2; i++ {
+		expectStatus(t, collectImagesRequest(t, config), http.StatusForbidden)
+	}
+
+	if _, err := config.ImageStore.Get(context.Background(), "orphaned-image"); err != nil {
+		t.Error("Expected POST /admin/gc to delete nothing when API keys are not configured")
+	}
+}
+
+func TestCollectImagesWhenImageStoreFails(t *testing.T) {
+	config := imageGCConf()
+	config.ImageStore.Put(context.Background(), "orphaned-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	config.ImageStore = &undeletableImageStore{config.ImageStore}
+
+	expectStatus(t, collectImagesRequest(t, config), http.StatusOK)
+	expectStatus(t, collectImagesRequest(t, config), http.StatusBadGateway)
+}
+
+func TestCollectImagesEvery(t *testing.T) {
+	config := imageGCConf()
+	config.ImageStore.Put(context.Background(), "orphaned-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	stop := collectImagesEvery(time.Millisecond, config)
+	defer stop()
+
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		if _, err := config.ImageStore.Get(context.Background(), "orphaned-image"); err == images.ErrNotFound {
+			return
+		}
+	}
+
+	t.Error("Expected the orphaned images to be collected at every interval")
+}
+
+func TestCollectImagesEveryWithoutAPIKeys(t *testing.T) {
+	config := inMemoryConf()
+	config.ImageStore.Put(context.Background(), "orphaned-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	stop := collectImagesEvery(time.Millisecond, config)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+
+	if _, err := config.ImageStore.Get(context.Background(), "orphaned-image"); err != nil {
+		t.Error("Expected no images to be collected when API keys are not configured")
+	}
+}
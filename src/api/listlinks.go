@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+const maxListLimit = 100
+
+type listLinksOutput struct {
+	Links      []links.Entry `json:"links"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// Lists the public links page by page. Private links are never listed, as their slug is their only protection.
+// The "cursor" returned with each page is passed along to get the next one, until none is returned
+func listLinks(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	query := r.URL.Query()
+
+	limit := links.DefaultListLimit
+	if raw := query.Get("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			errorResponse(w, http.StatusBadRequest, fmt.Sprintf("The limit must be a number between 1 and %d", maxListLimit), fmt.Errorf("Invalid limit %q", raw), c)
+			return
+		}
+	}
+
+	entries, next, err := c.LinkStore.List(query.Get("cursor"), limit)
+	if err == links.ErrInvalidCursor {
+		errorResponse(w, http.StatusBadRequest, "The cursor must be one returned with a previous page", err, c)
+		return
+	}
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "The links could not be listed", err, c)
+		return
+	}
+
+	jsonResp, err := json.Marshal(&listLinksOutput{Links: entries, NextCursor: next})
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
+		return
+	}
+
+	response(w, http.StatusOK, jsonResp)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Fails to list, either as if it could not be reached or as if the cursor was not one of its own
+type unlistableLinkStore struct {
+	links.Store
+	err error
+}
+
+func (store *unlistableLinkStore) List(cursor string, limit int) ([]links.Entry, string, error) {
+	return nil, "", store.err
+}
+
+func listLinksRequest(t *testing.T, config *Config, query string) (*httptest.ResponseRecorder, *listLinksOutput) {
+	req, err := http.NewRequest("GET", "/links?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	output := &listLinksOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+	return rr, output
+}
+
+func createNumberedLinks(config *Config, n int) {
+	for i := 0; i < n; i++ {
+		config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title", URL: fmt.Sprintf("http://example.com/%d", i)}})
+	}
+}
+
+func TestListLinksWhenStoreIsEmpty(t *testing.T) {
+	rr, output := listLinksRequest(t, inMemoryConf(), "")
+
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{`"links":[]`})
+	if output.NextCursor != "" {
+		t.Errorf("Expected no next cursor. Instead, got %s", output.NextCursor)
+	}
+}
+
+func TestListLinksSinglePage(t *testing.T) {
+	config := inMemoryConf()
+	createNumberedLinks(config, 3)
+
+	rr, output := listLinksRequest(t, config, "")
+
+	expectStatus(t, rr, http.StatusOK)
+	if len(output.Links) != 3 || output.NextCursor != "" {
+		t.Errorf("Expected a single page with every link. Instead, got %s", rr.Body.String())
+	}
+
+	if entry := output.Links[0]; entry.Slug == "" || entry.Link.Values.Title != "Some title" {
+		t.Errorf("Expected every entry to hold its slug and values. Instead, got %+v", entry)
+	}
+}
+
+func TestListLinksLeavesOutExpiredLinks(t *testing.T) {
+	config := inMemoryConf()
+	createNumberedLinks(config, 2)
+	expiresAt := time.Now().Add(-time.Second)
+	config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Expired title"}, ExpiresAt: &expiresAt})
+
+	rr, output := listLinksRequest(t, config, "")
+
+	expectStatus(t, rr, http.StatusOK)
+	if len(output.Links) != 2 {
+		t.Errorf("Expected the 2 links that did not expire. Instead, got %s", rr.Body.String())
+	}
+	for _, entry := range output.Links {
+		if entry.Link.Expired() {
+			t.Errorf("Expected expired links not to be listed. Instead, got %s", entry.Slug)
+		}
+	}
+}
+
+func TestListLinksMultiplePages(t *testing.T) {
+	config := inMemoryConf()
+	createNumberedLinks(config, 5)
+
+	seen := make(map[string]bool)
+	pages := 0
+	cursor := ""
+	for {
+		rr, output := listLinksRequest(t, config, "limit=2&cursor="+cursor)
+		expectStatus(t, rr, http.StatusOK)
+		pages++
+
+		for _, entry := range output.Links {
+			seen[entry.Slug] = true
+		}
+
+		if output.NextCursor == "" || pages > 5 {
+			break
+		}
+		cursor = output.NextCursor
+	}
+
+	if pages != 3 || len(seen) != 5 {
+		t.Errorf("Expected 5 links over 3 pages. Instead, got %d links over %d pages", len(seen), pages)
+	}
+}
+
+func TestListLinksInvalidLimit(t *testing.T) {
+	for _, limit := range []string{"0", "-1", "101", "many"} {
+		rr, _ := listLinksRequest(t, inMemoryConf(), "limit="+limit)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected the limit %s to be rejected. Instead, got %d", limit, rr.Code)
+		}
+	}
+}
+
+func TestListLinksInvalidCursor(t *testing.T) {
+	config := inMemoryConf()
+	config.LinkStore = &unlistableLinkStore{Store: config.LinkStore, err: links.ErrInvalidCursor}
+
+	rr, _ := listLinksRequest(t, config, "cursor=bogus")
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestListLinksWhenTheStoreFails(t *testing.T) {
+	config := inMemoryConf()
+	config.LinkStore = &unlistableLinkStore{Store: config.LinkStore, err: errors.New("connection refused")}
+
+	rr, _ := listLinksRequest(t, config, "")
+	expectStatus(t, rr, http.StatusInternalServerError)
+}
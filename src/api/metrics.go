@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Prometheus metrics, written in its text exposition format without pulling the whole client library
+*/
+
+var (
+	defaultScraperUserAgents = []string{"facebookexternalhit", "Slackbot", "Twitterbot"}
+	latencyBuckets           = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+type requestLabels struct {
+	route  string
+	method string
+	status int
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// The counters and histograms collected by the middlewares, until they are scraped from GET /metrics
+type metrics struct {
+	mutex       sync.Mutex
+	requests    map[requestLabels]uint64
+	latencies   map[string]*histogram
+	scraperHits map[string]uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:    make(map[requestLabels]uint64),
+		latencies:   make(map[string]*histogram),
+		scraperHits: make(map[string]uint64),
+	}
+}
+
+// Records the requests to a route, with their status code and how long they took
+func (m *metrics) measure(route string) middleware {
+	return func(next handler) handler {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next(recorder, r, ps, c)
+
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+
+			m.requests[requestLabels{route: route, method: r.Method, status: recorder.statusCode()}]++
+
+			latency, ok := m.latencies[route]
+			if !ok {
+				latency = &histogram{counts: make([]uint64, len(latencyBuckets))}
+				m.latencies[route] = latency
+			}
+			latency.observe(time.Since(start).Seconds())
+		}
+	}
+}
+
+// Counts the requests made by the scrapers of known sites, the ones that end up rendering the previews
+func (m *metrics) countScrapers(next handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		if scraper, ok := matchScraper(r.UserAgent(), c); ok {
+			m.mutex.Lock()
+			m.scraperHits[scraper]++
+			m.mutex.Unlock()
+		}
+
+		next(w, r, ps, c)
+	}
+}
+
+// The configured scraper whose name the user agent contains, ignoring case
+func matchScraper(userAgent string, c *Config) (string, bool) {
+	scrapers := c.ScraperUserAgents
+	if len(scrapers) == 0 {
+		scrapers = defaultScraperUserAgents
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	for _, scraper := range scrapers {
+		if strings.Contains(userAgent, strings.ToLower(scraper)) {
+			return scraper, true
+		}
+	}
+
+	return "", false
+}
+
+// Exposes the collected metrics for Prometheus to scrape
+func (m *metrics) serve(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	buf := &bytes.Buffer{}
+
+	m.mutex.Lock()
+	m.writeRequests(buf)
+	m.writeLatencies(buf)
+	m.writeScraperHits(buf)
+	m.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func (m *metrics) writeRequests(buf *bytes.Buffer) {
+	lines := []string{}
+	for labels, count := range m.requests {
+		lines = append(lines, fmt.Sprintf("fakelink_http_requests_total{route=%s,method=%s,status=\"%d\"} %d\n",
+			quoteLabel(labels.route), quoteLabel(labels.method), labels.status, count))
+	}
+	sort.Strings(lines)
+
+	buf.WriteString("# HELP fakelink_http_requests_total Requests handled, by route, method and status code.\n")
+	buf.WriteString("# TYPE fakelink_http_requests_total counter\n")
+	buf.WriteString(strings.Join(lines, ""))
+}
+
+func (m *metrics) writeLatencies(buf *bytes.Buffer) {
+	routes := []string{}
+	for route := range m.latencies {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	buf.WriteString("# HELP fakelink_http_request_duration_seconds Time taken to handle requests, by route.\n")
+	buf.WriteString("# TYPE fakelink_http_request_duration_seconds histogram\n")
+	for _, route := range routes {
+		latency := m.latencies[route]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(buf, "fakelink_http_request_duration_seconds_bucket{route=%s,le=\"%g\"} %d\n", quoteLabel(route), bound, latency.counts[i])
+		}
+		fmt.Fprintf(buf, "fakelink_http_request_duration_seconds_bucket{route=%s,le=\"+Inf\"} %d\n", quoteLabel(route), latency.count)
+		fmt.Fprintf(buf, "fakelink_http_request_duration_seconds_sum{route=%s} %g\n", quoteLabel(route), latency.sum)
+		fmt.Fprintf(buf, "fakelink_http_request_duration_seconds_count{route=%s} %d\n", quoteLabel(route), latency.count)
+	}
+}
+
+func (m *metrics) writeScraperHits(buf *bytes.Buffer) {
+	lines := []string{}
+	for scraper, count := range m.scraperHits {
+		lines = append(lines, fmt.Sprintf("fakelink_scraper_hits_total{scraper=%s} %d\n", quoteLabel(scraper), count))
+	}
+	sort.Strings(lines)
+
+	buf.WriteString("# HELP fakelink_scraper_hits_total Links rendered for the scrapers of known sites, by scraper.\n")
+	buf.WriteString("# TYPE fakelink_scraper_hits_total counter\n")
+	buf.WriteString(strings.Join(lines, ""))
+}
+
+// Label values are quoted, escaping backslashes, quotes and line breaks
+func quoteLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return `"` + value + `"`
+}
+
+// Remembers the status code a handler answered with, and the error behind it if it failed
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	err    error
+}
+
+// Keeps the error for the middlewares, passing it along to the ones further out
+func (recorder *statusRecorder) recordError(err error) {
+	recorder.err = err
+	if outer, ok := recorder.ResponseWriter.(errorRecorder); ok {
+		outer.recordError(err)
+	}
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+func (recorder *statusRecorder) statusCode() int {
+	if recorder.status == 0 {
+		return http.StatusOK
+	}
+	return recorder.status
+}
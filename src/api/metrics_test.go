@@ -0,0 +1,91 @@
+package api
+
+import (
+	"github.com/devlucky/fakelink/src/links"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrapeMetrics(t *testing.T, router http.Handler) string {
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"text/plain"})
+	return rr.Body.String()
+}
+
+func getLinkAs(t *testing.T, router http.Handler, slug, userAgent string) {
+	req, err := http.NewRequest("GET", "/links/"+slug, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	router.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func expectMetric(t *testing.T, body, line string) {
+	if !strings.Contains(body, line+"\n") {
+		t.Errorf("Expected the metrics to contain %q. Instead, they were:\n%s", line, body)
+	}
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	config := inMemoryConf()
+	router := NewRouter(config)
+	slug := config.LinkStore.Create(links.RandomLink())
+
+	router.ServeHTTP(httptest.NewRecorder(), newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink()}))
+	getLinkAs(t, router, slug, "curl/7.50")
+	getLinkAs(t, router, slug, "curl/7.50")
+	getLinkAs(t, router, "missing", "curl/7.50")
+
+	body := scrapeMetrics(t, router)
+	expectMetric(t, body, `fakelink_http_requests_total{route="/links",method="POST",status="201"} 1`)
+	expectMetric(t, body, `fakelink_http_requests_total{route="/links/:slug",method="GET",status="200"} 2`)
+	expectMetric(t, body, `fakelink_http_requests_total{route="/links/:slug",method="GET",status="404"} 1`)
+	expectMetric(t, body, `fakelink_http_request_duration_seconds_bucket{route="/links/:slug",le="+Inf"} 3`)
+	expectMetric(t, body, `fakelink_http_request_duration_seconds_count{route="/links"} 1`)
+}
+
+func TestMetricsCountScrapers(t *testing.T) {
+	config := inMemoryConf()
+	router := NewRouter(config)
+	slug := config.LinkStore.Create(links.RandomLink())
+
+	getLinkAs(t, router, slug, "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)")
+	getLinkAs(t, router, slug, "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	getLinkAs(t, router, slug, "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	getLinkAs(t, router, slug, "Mozilla/5.0")
+
+	body := scrapeMetrics(t, router)
+	expectMetric(t, body, `fakelink_scraper_hits_total{scraper="facebookexternalhit"} 1`)
+	expectMetric(t, body, `fakelink_scraper_hits_total{scraper="Slackbot"} 2`)
+	if strings.Contains(body, `scraper="Twitterbot"`) {
+		t.Error("Expected scrapers that made no request not to be listed")
+	}
+}
+
+func TestMetricsWithConfiguredScrapers(t *testing.T) {
+	config := inMemoryConf()
+	config.ScraperUserAgents = []string{"Discordbot"}
+	router := NewRouter(config)
+	slug := config.LinkStore.Create(links.RandomLink())
+
+	getLinkAs(t, router, slug, "Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)")
+	getLinkAs(t, router, slug, "Twitterbot/1.0")
+
+	body := scrapeMetrics(t, router)
+	expectMetric(t, body, `fakelink_scraper_hits_total{scraper="Discordbot"} 1`)
+	if strings.Contains(body, `scraper="Twitterbot"`) {
+		t.Error("Expected the configured scrapers to replace the default ones")
+	}
+}
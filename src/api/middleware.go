@@ -0,0 +1,21 @@
+package api
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// A handler is an endpoint with access to the Config, as injectConfig expects it
+type handler func(http.ResponseWriter, *http.Request, httprouter.Params, *Config)
+
+// A middleware wraps a handler, adding behavior around it
+type middleware func(handler) handler
+
+// Wraps a handler with the given middlewares, the first one being the outermost
+func chain(h handler, middlewares ...middleware) handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+
+	return h
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"testing"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) middleware {
+		return func(next handler) handler {
+			return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+				calls = append(calls, name)
+				next(w, r, ps, c)
+			}
+		}
+	}
+
+	h := chain(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		calls = append(calls, "handler")
+	}, record("outer"), record("inner"))
+	h(nil, nil, nil, nil)
+
+	if len(calls) != 3 || calls[0] != "outer" || calls[1] != "inner" || calls[2] != "handler" {
+		t.Errorf("Expected middlewares to run outermost first, then the handler. Instead, got %v", calls)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// See https://oembed.com/ for the meaning of each field
+type oEmbedOutput struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Title        string `json:"title"`
+	ProviderName string `json:"provider_name,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// Describes one of our links, given its URL, as an oEmbed "link" resource. Only the JSON format is supported
+func oEmbed(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	query := r.URL.Query()
+
+	if format := query.Get("format"); format != "" && format != "json" {
+		errorResponse(w, http.StatusNotImplemented, "Only the json format is supported", fmt.Errorf("Unsupported format %s", format), c)
+		return
+	}
+
+	linkURL, err := url.Parse(query.Get("url"))
+	if err != nil || query.Get("url") == "" {
+		errorResponse(w, http.StatusBadRequest, "The 'url' parameter must be the URL of a link", errors.New("Missing or invalid url parameter"), c)
+		return
+	}
+
+	slug := strings.TrimPrefix(linkURL.Path, "/links/")
+	if slug == linkURL.Path || slug == "" || strings.Contains(slug, "/") {
+		errorResponse(w, http.StatusNotFound, "The URL does not point to a link", fmt.Errorf("Unexpected path %s", linkURL.Path), c)
+		return
+	}
+
+	link := c.LinkStore.Find(slug)
+	if link == nil {
+		errorResponse(w, http.StatusNotFound, "Link not found", fmt.Errorf("No link with slug %s", slug), c)
+		return
+	}
+
+	if link.Expired() {
+		errorResponse(w, http.StatusGone, "The link has expired", fmt.Errorf("Link %s expired at %s", slug, link.ExpiresAt), c)
+		return
+	}
+
+	output := &oEmbedOutput{
+		Type:         "link",
+		Version:      "1.0",
+		Title:        link.Values.Title,
+		ProviderName: link.Values.SiteName,
+		ThumbnailURL: signedImageURL(link.Values.MainImage(), c),
+	}
+
+	jsonResp, err := json.Marshal(output)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Could not encode the oEmbed response", err, c)
+		return
+	}
+
+	response(w, http.StatusOK, jsonResp)
+}
+
+// Builds the oEmbed discovery URL for a link
+func oEmbedURL(r *http.Request, slug string, c *Config) string {
+	return fmt.Sprintf("%s/oembed?url=%s", baseURL(r, c), url.QueryEscape(linkURL(r, slug, c)))
+}
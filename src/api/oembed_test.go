@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func getOEmbed(t *testing.T, config *Config, query string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", "/oembed?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestOEmbed(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(
+		&links.Link{
+			Values: templates.Values{
+				Title:    "Some title",
+				SiteName: "Some site",
+				Image:    "http://example.com/image.jpg",
+			},
+		},
+	)
+
+	rr := getOEmbed(t, config, "url="+url.QueryEscape("http://fakelink.example/links/"+slug))
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"application/json"})
+
+	output := &oEmbedOutput{}
+	if err := json.Unmarshal(rr.Body.Bytes(), output); err != nil {
+		t.Fatalf("Expected an oEmbed JSON response. Instead, got %s", rr.Body.String())
+	}
+
+	expected := oEmbedOutput{
+		Type:         "link",
+		Version:      "1.0",
+		Title:        "Some title",
+		ProviderName: "Some site",
+		ThumbnailURL: "http://example.com/image.jpg",
+	}
+	if *output != expected {
+		t.Errorf("Expected the oEmbed response to be %+v. Instead, got %+v", expected, *output)
+	}
+}
+
+func TestOEmbedSignsStoredImages(t *testing.T) {
+	config := inMemoryConf()
+	store := &presigningImageStore{Store: config.ImageStore, validFor: time.Hour}
+	config.ImageStore = store
+
+	imageURL, _ := store.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title", Image: imageURL}})
+
+	rr := getOEmbed(t, config, "url="+url.QueryEscape("http://fakelink.example/links/"+slug))
+
+	output := &oEmbedOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+	if output.ThumbnailURL != imageURL+"?signature=1" {
+		t.Errorf("Expected the thumbnail to be the stored image, signed. Instead, got %q", output.ThumbnailURL)
+	}
+}
+
+func TestOEmbedErrors(t *testing.T) {
+	cases := map[string]int{
+		"":                             http.StatusBadRequest,
+		"url=http://fakelink.example/": http.StatusNotFound,
+		"url=http://fakelink.example/links/missing":            http.StatusNotFound,
+		"url=http://fakelink.example/links/missing&format=xml": http.StatusNotImplemented,
+	}
+
+	for query, status := range cases {
+		rr := getOEmbed(t, inMemoryConf(), query)
+		if rr.Code != status {
+			t.Errorf("Expected /oembed?%s to respond with %d. Instead, got %d", query, status, rr.Code)
+		}
+	}
+}
+
+func TestOEmbedURL(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://fakelink.example/links/abc", nil)
+
+	expected := "http://fakelink.example/oembed?url=" + url.QueryEscape("http://fakelink.example/links/abc")
+	if discovery := oEmbedURL(req, "abc", inMemoryConf()); discovery != expected {
+		t.Errorf("Expected the discovery URL to be %s. Instead, got %s", expected, discovery)
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+const (
+	// How many links a single POST /links/bulk may create
+	maxBulkLinks = 1000
+	// How many slugs are tried for a link before giving up on one that no other link of the batch took
+	maxBulkSlugAttempts = 10
+)
+
+type bulkLinkResult struct {
+	Slug   string             `json:"slug,omitempty"`
+	URL    string             `json:"url,omitempty"`
+	Error  string             `json:"error,omitempty"`
+	Errors []links.FieldError `json:"errors,omitempty"`
+}
+
+// Creates many links at once from a JSON array of the inputs POST /links takes. Each link is validated and
+// created on its own, so that some may fail while the rest are created: the response lists, in order, either
+// the slug and URL of each link or why it could not be created
+func postBulkLinks(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	var inputs []*postLinkInput
+	err := json.NewDecoder(r.Body).Decode(&inputs)
+	if bodyTooLarge(err) {
+		bodyTooLargeResponse(w, err, c)
+		return
+	}
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "Invalid JSON request body, which must be an array of links", err, c)
+		return
+	}
+
+	if len(inputs) > maxBulkLinks {
+		errorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("At most %d links can be created at once", maxBulkLinks), fmt.Errorf("%d links were sent", len(inputs)), c)
+		return
+	}
+
+	results := make([]bulkLinkResult, len(inputs))
+	entries := make([]links.Entry, 0, len(inputs))
+	created := make([]int, 0, len(inputs))
+	taken := make(map[string]bool)
+
+	for i, input := range inputs {
+		if input == nil {
+			results[i].Error = "The link is missing"
+			continue
+		}
+
+		// Bulk creations are never previews
+		input.Preview = false
+		link, linkErr := buildLink(r.Context(), input, nil, c, "")
+		if linkErr != nil {
+			results[i].Error = bulkErrorMessage(linkErr)
+			results[i].Errors = linkErr.fields
+			continue
+		}
+
+		// Custom slugs are first come, first served, which the store decides on its own as for POST /links, so that
+		// a concurrent request can't take one in between. The images stored for the link are of no use then
+		slug := input.Slug
+		if slug != "" {
+			err := links.ErrSlugTaken
+			if !taken[input.Slug] {
+				slug, err = c.LinkStore.CreateIfAbsent(input.Slug, link)
+			}
+
+			switch {
+			case err == links.ErrSlugTaken:
+				deleteStoredImages(r.Context(), link.Values, c)
+				results[i].Error = "The slug is already taken"
+			case err != nil:
+				results[i].Error = "The link could not be stored"
+			default:
+				taken[input.Slug] = true
+				results[i].Slug = slug
+				results[i].URL = linkURL(r, slug, c)
+			}
+			continue
+		}
+		if c.DeterministicSlugs {
+			slug, _, err := createLink(r.Context(), link, c)
+			if err != nil {
+				results[i].Error = "The link could not be stored"
+				continue
+			}
+			results[i].Slug = slug
+			results[i].URL = linkURL(r, slug, c)
+			continue
+		}
+		slug, err = bulkSlug(taken, c)
+		if err != nil {
+			results[i].Error = "Could not generate a slug for the link"
+			continue
+		}
+
+		entries = append(entries, links.Entry{Slug: slug, Link: link})
+		created = append(created, i)
+	}
+
+	for j, slug := range c.LinkStore.CreateBatch(entries) {
+		result := &results[created[j]]
+		if slug == "" {
+			result.Error = "The link could not be stored"
+			continue
+		}
+
+		result.Slug = slug
+		result.URL = linkURL(r, slug, c)
+	}
+
+	jsonResp, err := json.Marshal(results)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
+		return
+	}
+
+	response(w, http.StatusOK, jsonResp)
+}
+
+// Generates a slug that is neither in the store nor taken by another link of the batch
+func bulkSlug(taken map[string]bool, c *Config) (string, error) {
+	for attempt := 0; attempt < maxBulkSlugAttempts; attempt++ {
+		slug, err := links.GenerateSlug(c.LinkStore, c.SlugLength)
+		if err != nil {
+			return "", err
+		}
+
+		if !taken[slug] {
+			taken[slug] = true
+			return slug, nil
+		}
+	}
+
+	return "", links.ErrNoFreeSlug
+}
+
+// The reason a link of the batch is invalid is part of the response, unlike the details of an unexpected failure.
+// Invalid fields are listed on their own
+func bulkErrorMessage(linkErr *linkError) string {
+	if linkErr.fields != nil || linkErr.status >= http.StatusInternalServerError {
+		return linkErr.message
+	}
+
+	return fmt.Sprintf("%s: %s", linkErr.message, linkErr.err)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func postBulkLinksRequest(t *testing.T, config *Config, body string) (*httptest.ResponseRecorder, []bulkLinkResult) {
+	req, err := http.NewRequest("POST", "/links/bulk", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	var results []bulkLinkResult
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+			t.Fatalf("Expected a JSON array in the response. Instead, got %s", rr.Body.String())
+		}
+	}
+
+	return rr, results
+}
+
+func TestPostBulkLinks(t *testing.T) {
+	config := inMemoryConf()
+
+	rr, results := postBulkLinksRequest(t, config, `[
+		{"link": {"values": {"title": "first", "url": "https://example.com/first"}}},
+		{"link": {"values": {"title": "invalid", "url": "javascript:alert(1)"}}},
+		{"link": {"values": {"title": "private", "target_url": "https://example.com/private"}, "private": true}, "ttl": 3600},
+		{"link": {"values": {"title": "missing template", "url": "https://example.com"}, "template_name": "missing"}},
+		{"link": {"values": {"url": "https://example.com"}}, "ttl": -1},
+		null
+	]`)
+
+	expectStatus(t, rr, http.StatusOK)
+	if len(results) != 6 {
+		t.Fatalf("Expected a result per link, in order. Instead, got %s", rr.Body.String())
+	}
+
+	for _, i := range []int{0, 2} {
+		result := results[i]
+		if result.Slug == "" || result.Error != "" || !strings.HasSuffix(result.URL, "/links/"+result.Slug) {
+			t.Errorf("Expected link %d to be created. Instead, got %+v", i, result)
+			continue
+		}
+
+		if config.LinkStore.Find(result.Slug) == nil {
+			t.Errorf("Expected link %d to be stored under %s", i, result.Slug)
+		}
+	}
+
+	if link := config.LinkStore.Find(results[2].Slug); link == nil || !link.Private || link.ExpiresAt == nil {
+		t.Errorf("Expected the link to be private and to expire. Instead, got %+v", link)
+	}
+
+	invalid := map[int][]links.FieldError{
+		1: {{Field: "url", Message: `must use the http or https scheme, but it was "javascript:alert(1)"`}},
+		3: {{Field: "template_name", Message: `there is no template named "missing"`}},
+		4: {{Field: "title", Message: "required"}, {Field: "ttl", Message: "can't be negative"}},
+	}
+	for i, fields := range invalid {
+		result := results[i]
+		if result.Slug != "" || result.URL != "" || result.Error == "" || !reflect.DeepEqual(result.Errors, fields) {
+			t.Errorf("Expected link %d to fail with %+v. Instead, got %+v", i, fields, result)
+		}
+	}
+
+	if results[5].Error != "The link is missing" {
+		t.Errorf("Expected a null link to fail. Instead, got %+v", results[5])
+	}
+}
+
+func TestPostBulkLinksWithCustomSlugs(t *testing.T) {
+	config := inMemoryConf()
+	config.LinkStore.CreateWithSlug("taken", links.RandomLink())
+
+	rr, results := postBulkLinksRequest(t, config, `[
+		{"link": {"values": {"title": "first", "url": "https://example.com/first"}}, "slug": "summer-sale"},
+		{"link": {"values": {"title": "second", "url": "https://example.com/second"}}, "slug": "summer-sale"},
+		{"link": {"values": {"title": "third", "url": "https://example.com/third"}}, "slug": "taken"}
+	]`)
+
+	expectStatus(t, rr, http.StatusOK)
+	if len(results) != 3 || results[0].Slug != "summer-sale" {
+		t.Fatalf("Expected the first link to get its custom slug. Instead, got %s", rr.Body.String())
+	}
+
+	for _, i := range []int{1, 2} {
+		if results[i].Slug != "" || results[i].Error != "The slug is already taken" {
+			t.Errorf("Expected link %d to fail as its slug is taken. Instead, got %+v", i, results[i])
+		}
+	}
+}
+
+// Lets another request take every slug right before the link asking for it is created
+type racingLinkStore struct {
+	links.Store
+}
+
+func (store *racingLinkStore) CreateIfAbsent(slug string, link *links.Link) (string, error) {
+	store.Store.CreateIfAbsent(slug, &links.Link{Values: templates.Values{Title: "concurrent"}})
+	return store.Store.CreateIfAbsent(slug, link)
+}
+
+func TestPostBulkLinksWithACustomSlugTakenConcurrently(t *testing.T) {
+	config := inMemoryConf()
+	config.LinkStore = &racingLinkStore{Store: config.LinkStore}
+
+	rr, results := postBulkLinksRequest(t, config, `[
+		{"link": {"values": {"title": "first", "url": "https://example.com/first"}}, "slug": "summer-sale"},
+		{"link": {"values": {"title": "second", "url": "https://example.com/second"}}}
+	]`)
+
+	expectStatus(t, rr, http.StatusOK)
+	if len(results) != 2 || results[0].Slug != "" || results[0].Error != "The slug is already taken" {
+		t.Fatalf("Expected the link to fail as its slug was taken in between. Instead, got %s", rr.Body.String())
+	}
+	if results[1].Slug == "" {
+		t.Errorf("Expected the rest of the links to be created. Instead, got %+v", results[1])
+	}
+
+	if link := config.LinkStore.Find("summer-sale"); link == nil || link.Values.Title != "concurrent" {
+		t.Errorf("Expected the link that took the slug first to be kept. Instead, found %+v", link)
+	}
+}
+
+func TestPostBulkLinksWithDeterministicSlugs(t *testing.T) {
+	config := inMemoryConf()
+	config.DeterministicSlugs = true
+	page := &links.Link{Values: templates.Values{Title: "first", URL: "https://example.com/first", Type: links.DefaultType, SiteName: "example.com"}}
+
+	rr, results := postBulkLinksRequest(t, config, `[
+		{"link": {"values": {"title": "first", "url": "https://example.com/first"}}},
+		{"link": {"values": {"title": "second", "url": "https://example.com/second"}}, "slug": "summer-sale"}
+	]`)
+
+	expectStatus(t, rr, http.StatusOK)
+	if len(results) != 2 || results[0].Slug != page.Slug() || results[1].Slug != "summer-sale" {
+		t.Fatalf("Expected links without a custom slug to get the one derived from their URL, as with POST /links. Instead, got %s", rr.Body.String())
+	}
+	if config.LinkStore.Find(page.Slug()) == nil {
+		t.Errorf("Expected the link to be stored under %s", page.Slug())
+	}
+}
+
+func TestPostBulkLinksWithInvalidBody(t *testing.T) {
+	for _, body := range []string{"", "{}", `{"link": {"values": {"title": "not an array"}}}`} {
+		rr, _ := postBulkLinksRequest(t, inMemoryConf(), body)
+		expectStatus(t, rr, http.StatusBadRequest)
+	}
+}
+
+func TestPostTooManyBulkLinks(t *testing.T) {
+	body := "[" + strings.Repeat(`{"link": {"values": {"title": "some-title"}}},`, maxBulkLinks) + `{"link": {"values": {"title": "one too many"}}}]`
+
+	config := inMemoryConf()
+	rr, _ := postBulkLinksRequest(t, config, body)
+
+	expectStatus(t, rr, http.StatusRequestEntityTooLarge)
+	if config.LinkStore.FindRandom() != "" {
+		t.Error("Expected no link to be created")
+	}
+}
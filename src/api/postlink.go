@@ -1,73 +1,457 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/devlucky/fakelink/src/images"
 	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
 	"github.com/julienschmidt/httprouter"
-	"github.com/satori/go.uuid"
 	"image"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type postLinkInput struct {
-	Link links.Link `json:"link"`
+	Link        links.Link `json:"link"`
+	Slug        string     `json:"slug,omitempty"`
+	MirrorImage bool       `json:"mirror_image"`
+	VerifyImage bool       `json:"verify_image"`
+	TTL         int        `json:"ttl"`
+	Preview     bool       `json:"preview,omitempty"`
 }
 
 type postLinkOutput struct {
 	Slug string `json:"slug"`
+	URL  string `json:"url"`
 }
 
-// We expect a multipart/form-data request containing:
+// We expect either an application/json request with the expected input as its body,
+// or a multipart/form-data request containing:
 // 	- an optional "image"
-// 	- a "json" with the expected input as values
+// 	- a "json" with the expected input as values.
+// If "mirror_image" is set, the remote image the values point to is downloaded and stored as if it had been uploaded.
+// If "verify_image" is set instead, the remote image is only checked to be there.
+// The link gets the custom "slug" it asks for, unless another link took it already, or one made up by createLink.
+// Posting a page that already has a link with DeterministicSlugs answers with that link, with a 200 rather than a 201.
+// A dry run, either through ?dryRun=true or "preview", validates and renders the link without storing anything
 func postLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
-	err := r.ParseMultipartForm(1024)
-	if err != nil {
-		errorResponse(w, http.StatusBadRequest, "Format is not multipart/form-data", err, c)
+	link, slug, preview := readLink(w, r, c, "")
+	if link == nil {
 		return
 	}
 
-	input := &postLinkInput{}
-	err = json.Unmarshal([]byte(r.FormValue("json")), &input)
-	if err != nil {
-		errorResponse(w, http.StatusBadRequest, "Invalid request body. Multipart form needs a 'json' key", err, c)
+	if preview {
+		previewLink(w, r, "", link, c)
 		return
 	}
 
-	// We pass the new link through the creator in order to validate the raw input
-	link, err := links.NewLink(input.Link.Values, input.Link.Private)
+	// Custom slugs are first come, first served, which the store decides on its own so that concurrent requests
+	// can't both take one. The images stored for the link are of no use then
+	var err error
+	status := http.StatusCreated
+	if slug != "" {
+		slug, err = c.LinkStore.CreateIfAbsent(slug, link)
+		if err == links.ErrSlugTaken {
+			deleteStoredImages(r.Context(), link.Values, c)
+			errorResponse(w, http.StatusConflict, "The slug is already taken", err, c)
+			return
+		}
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "The link could not be stored", err, c)
+			return
+		}
+	} else {
+		var created bool
+		slug, created, err = createLink(r.Context(), link, c)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "Could not generate a slug for the link", err, c)
+			return
+		}
+		if !created {
+			status = http.StatusOK
+		}
+	}
+
+	url := linkURL(r, slug, c)
+	jsonResp, err := json.Marshal(&postLinkOutput{Slug: slug, URL: url})
 	if err != nil {
-		errorResponse(w, http.StatusBadRequest, "The link's structure or values are invalid", err, c)
+		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
 		return
 	}
 
+	w.Header().Set("Location", url)
+	response(w, status, jsonResp)
+}
+
+// Creates a link without a custom slug, under a random slug of SlugLength characters or, with DeterministicSlugs,
+// under the one derived from its canonical URL, reporting whether it was created. A page that already has a link
+// keeps it as it is, another client's values, images and expiration included, and the new link's images are
+// deleted. Only expired links give their slug up, along with their images and rendered pages
+func createLink(ctx context.Context, link *links.Link, c *Config) (string, bool, error) {
+	// Private links get random slugs either way, as their slug is their only protection
+	if link.Private || !c.DeterministicSlugs {
+		slug, err := links.GenerateSlug(c.LinkStore, c.SlugLength)
+		if err != nil {
+			return "", false, err
+		}
+
+		return c.LinkStore.CreateWithSlug(slug, link), true, nil
+	}
+
+	slug, err := c.LinkStore.CreateIfAbsent(link.Slug(), link)
+	if err != links.ErrSlugTaken {
+		return slug, err == nil, err
+	}
+
+	slug = link.Slug()
+	existing := c.LinkStore.Find(slug)
+	if existing != nil && !existing.Expired() {
+		deleteStoredImages(ctx, link.Values, c)
+		return slug, false, nil
+	}
+
+	if existing != nil {
+		deleteStoredImages(ctx, existing.Values, c)
+	}
+	slug = c.LinkStore.CreateWithSlug(slug, link)
+	c.renderCache().invalidate(slug)
+	return slug, true, nil
+}
+
+// Reads the link sent in the request body and builds it, along with its uploaded image, returning the custom slug
+// it asked for, if any, and reporting whether it is only a preview. On failure, the error response has already been
+// written and nil is returned
+func readLink(w http.ResponseWriter, r *http.Request, c *Config, previousImage string) (*links.Link, string, bool) {
+	input := &postLinkInput{}
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+
+	if isJSON {
+		err := json.NewDecoder(r.Body).Decode(input)
+		if bodyTooLarge(err) {
+			bodyTooLargeResponse(w, err, c)
+			return nil, "", false
+		}
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "Invalid JSON request body", err, c)
+			return nil, "", false
+		}
+	} else {
+		// The body is capped by limitBody, so it can be kept in memory rather than spilling the image to disk
+		err := r.ParseMultipartForm(maxBodyBytes(c))
+		if bodyTooLarge(err) {
+			bodyTooLargeResponse(w, err, c)
+			return nil, "", false
+		}
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "Format is neither application/json nor multipart/form-data", err, c)
+			return nil, "", false
+		}
+
+		err = json.Unmarshal([]byte(r.FormValue("json")), &input)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "Invalid request body. Multipart form needs a 'json' key", err, c)
+			return nil, "", false
+		}
+	}
+
+	// The image is optional, so a missing file is not an error
+	var file multipart.File
+	if !isJSON {
+		file, _, _ = r.FormFile("image")
+	}
+
+	if r.URL.Query().Get("dryRun") == "true" {
+		input.Preview = true
+	}
+
+	link, linkErr := buildLink(r.Context(), input, file, c, previousImage)
+	if linkErr != nil && linkErr.fields != nil {
+		validationErrorResponse(w, linkErr.fields, linkErr.err, c)
+		return nil, "", false
+	}
+	if linkErr != nil {
+		errorResponse(w, linkErr.status, linkErr.message, linkErr.err, c)
+		return nil, "", false
+	}
+
+	return link, input.Slug, input.Preview
+}
+
+// Whether a custom slug is one links can't take, such as the name of a route
+func reservedSlug(slug string, c *Config) bool {
+	for _, reserved := range c.ReservedSlugs {
+		if slug == reserved {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the first of the link's URL and target URL whose host is blocked, or not allowed, if any. Both are checked,
+// as visitors are sent to the target URL
+func disallowedURL(values templates.Values, c *Config) string {
+	for _, raw := range []string{values.URL, values.TargetURL} {
+		if raw != "" && !links.HostAllowed(raw, c.AllowedHosts, c.BlockedHosts) {
+			return raw
+		}
+	}
+
+	return ""
+}
+
+// Why a link could not be built from its input, along with the status code to respond with. Invalid inputs
+// list every one of their invalid fields
+type linkError struct {
+	status  int
+	message string
+	err     error
+	fields  []links.FieldError
+}
+
+func badLink(status int, message string, err error) *linkError {
+	return &linkError{status: status, message: message, err: err}
+}
+
+// Validates the link in the input and stores its uploaded image, if any, the one embedded in it as a data: URI,
+// or its mirrored one. Remote images equal to previousImage are not mirrored again. Images of previews are
+// decoded, or fetched, but never stored
+func buildLink(ctx context.Context, input *postLinkInput, file multipart.File, c *Config, previousImage string) (*links.Link, *linkError) {
+	// Images embedded as data: URIs, such as screenshots taken by the browser extension, are stored like uploads
+	var embedded image.Image
+	var embeddedErr *links.FieldError
+	if images.IsDataURI(input.Link.Values.Image) {
+		embedded, embeddedErr = decodeDataURI(input.Link.Values.Image, c)
+		input.Link.Values.Image = ""
+	}
+
+	link, invalid := newLink(input, c)
+	if embeddedErr != nil {
+		invalid = append(invalid, *embeddedErr)
+	}
+	if input.Slug != "" && !links.IsValidCustomSlug(input.Slug) {
+		invalid = append(invalid, links.FieldError{Field: "slug", Message: "must be 3 to 64 lowercase letters, digits or dashes, not ending with a dash and a number"})
+	} else if reservedSlug(input.Slug, c) {
+		invalid = append(invalid, links.FieldError{Field: "slug", Message: fmt.Sprintf("%q is reserved", input.Slug)})
+	}
+	if len(invalid) > 0 {
+		return nil, &linkError{status: http.StatusBadRequest, message: "The link is invalid", err: &links.ValidationError{Fields: invalid}, fields: invalid}
+	}
+	if url := disallowedURL(link.Values, c); url != "" {
+		return nil, badLink(http.StatusForbidden, "Links can't point to this host", fmt.Errorf("The host of %s is not allowed", url))
+	}
+
 	// If a custom image was uploaded, we store it and point the values to the image's URL
-	file, _, err := r.FormFile("image")
-	if err == nil {
-		img, _, err := image.Decode(file)
+	var img image.Image
+	var err error
+	if file != nil {
+		img, err = decodeUpload(file)
+		switch err {
+		case nil:
+		case images.ErrUnsupportedImageFormat:
+			return nil, badLink(http.StatusUnsupportedMediaType, "The image must be a JPEG, PNG, GIF or WebP", err)
+		default:
+			return nil, badLink(http.StatusBadRequest, "The image could not be decoded", err)
+		}
+	} else if embedded != nil {
+		img = embedded
+	} else if input.MirrorImage && link.Values.Image != "" && link.Values.Image != previousImage {
+		img, err = images.Fetch(ctx, link.Values.Image, c.ImageMaxBytes, c.ImageFetchTimeout, c.UnrestrictedFetches)
+		switch err {
+		case nil:
+		case images.ErrForbiddenAddress:
+			return nil, badLink(http.StatusBadRequest, "The remote image must be on a public address, on port 80 or 443", err)
+		case images.ErrImageTooLarge:
+			return nil, badLink(http.StatusBadRequest, fmt.Sprintf("The remote image is larger than %d bytes", c.ImageMaxBytes), err)
+		case images.ErrFetchTimeout:
+			return nil, badLink(http.StatusGatewayTimeout, "The remote image took too long to download", err)
+		case images.ErrUnsupportedImageFormat:
+			return nil, badLink(http.StatusUnsupportedMediaType, "The remote image must be a JPEG, PNG, GIF or WebP", err)
+		default:
+			return nil, badLink(http.StatusBadRequest, "The remote image could not be mirrored", err)
+		}
+	} else if input.VerifyImage && remoteImage(link.Values.MainImage(), c) && link.Values.MainImage() != previousImage {
+		if err = images.Verify(ctx, link.Values.MainImage(), c.ImageFetchTimeout, c.UnrestrictedFetches); err != nil {
+			return nil, badLink(http.StatusUnprocessableEntity, "The remote image is unreachable or not an image", err)
+		}
+	}
+
+	if input.Preview {
+		return link, nil
+	}
+
+	if img != nil {
+		stored, err := storeImage(ctx, img, c)
 		if err != nil {
-			errorResponse(w, http.StatusBadRequest, "The image could not be decoded", err, c)
-			return
+			return nil, badLink(http.StatusInternalServerError, "Could upload image", err)
 		}
 
-		thumbnail := images.Thumbnail(img, c.ImageMaxWidth, c.ImageMaxHeight)
-		imageURL, err := c.ImageStore.Put(uuid.NewV4().String(), thumbnail)
+		link.Values.Image = stored.URL
+		link.Values.Images = append([]templates.Image{stored}, link.Values.Images...)
+	}
+
+	// Links left without any image get a generated placeholder, when enabled
+	if c.PlaceholderImages && link.Values.MainImage() == "" {
+		stored, err := storePlaceholder(ctx, link.Values, c)
 		if err != nil {
-			errorResponse(w, http.StatusInternalServerError, "Could upload image", err, c)
-			return
+			return nil, badLink(http.StatusInternalServerError, "Could not store the placeholder image", err)
+		}
+
+		link.Values.Image = stored.URL
+		link.Values.Images = []templates.Image{stored}
+	}
+
+	return link, nil
+}
+
+// Whether an image lives elsewhere than in our image store
+func remoteImage(url string, c *Config) bool {
+	_, stored := storedImageKey(url, c)
+	return url != "" && !stored
+}
+
+// Validates the input, passing its values through the link creator, and returns the link it describes. Title and
+// URL are required, the URL being either the values' url or their target_url. A missing site name or type is
+// taken from the Config. Every invalid field is listed
+func newLink(input *postLinkInput, c *Config) (*links.Link, []links.FieldError) {
+	var invalid []links.FieldError
+
+	values := input.Link.Values
+	if values.SiteName == "" {
+		values.SiteName = c.DefaultSiteName
+	}
+	if values.Type == "" {
+		values.Type = c.DefaultType
+	}
+
+	link, err := links.NewLinkWithMaxDescription(values, input.Link.Private, c.MaxDescriptionLen)
+	if validationErr, ok := err.(*links.ValidationError); ok {
+		invalid = append(invalid, validationErr.Fields...)
+	}
+
+	if values.URL == "" && values.TargetURL == "" {
+		invalid = append(invalid, links.FieldError{Field: "url", Message: "required"})
+	}
+
+	if _, err = c.Templates.GetByName(input.Link.TemplateName); err != nil {
+		invalid = append(invalid, links.FieldError{Field: "template_name", Message: fmt.Sprintf("there is no template named %q", input.Link.TemplateName)})
+	}
+
+	// Links expire either after a TTL, in seconds, or at an explicit date
+	var expiresAt *time.Time
+	if input.TTL < 0 {
+		invalid = append(invalid, links.FieldError{Field: "ttl", Message: "can't be negative"})
+	} else if input.TTL > 0 {
+		ttlExpiration := time.Now().Add(time.Duration(input.TTL) * time.Second)
+		expiresAt = &ttlExpiration
+	} else if input.Link.ExpiresAt != nil {
+		if !input.Link.ExpiresAt.After(time.Now()) {
+			invalid = append(invalid, links.FieldError{Field: "expires_at", Message: "has already passed"})
 		}
+		expiresAt = input.Link.ExpiresAt
+	}
 
-		link.Values.Image = imageURL
+	if len(invalid) > 0 {
+		return nil, invalid
 	}
 
-	slug := c.LinkStore.Create(link)
+	link.TemplateName = input.Link.TemplateName
+	link.ExpiresAt = expiresAt
+	return link, nil
+}
 
-	jsonResp, err := json.Marshal(&postLinkOutput{slug})
+// Decodes an uploaded image once its metadata is stripped, so that no GPS coordinates or the like
+// make it to the stores, even those that would keep the uploaded bytes as they are
+func decodeUpload(file multipart.File) (image.Image, error) {
+	data, err := ioutil.ReadAll(file)
 	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
-		return
+		return nil, err
 	}
 
-	response(w, http.StatusCreated, jsonResp)
+	return images.Decode(bytes.NewReader(images.StripEXIF(data)))
+}
+
+func decodeDataURI(uri string, c *Config) (image.Image, *links.FieldError) {
+	img, err := images.DecodeDataURI(uri, c.ImageMaxBytes)
+	switch err {
+	case nil:
+		return img, nil
+	case images.ErrImageTooLarge:
+		return nil, &links.FieldError{Field: "image", Message: fmt.Sprintf("is larger than %d bytes", c.ImageMaxBytes)}
+	case images.ErrUnsupportedImageFormat:
+		return nil, &links.FieldError{Field: "image", Message: "must be a JPEG, PNG, GIF or WebP"}
+	default:
+		return nil, &links.FieldError{Field: "image", Message: "is not a base64 data URI of the declared image type"}
+	}
+}
+
+// Stores a thumbnail of the image, returning the URL it can be accessed through along with how it was stored.
+// Images keyed by their content are not uploaded again when an identical one was already stored
+func storeImage(ctx context.Context, img image.Image, c *Config) (templates.Image, error) {
+	thumbnail := images.Thumbnail(img, c.ImageMaxWidth, c.ImageMaxHeight)
+	key := imageKey(thumbnail, c)
+
+	if images.IsContentKey(key) {
+		stored, err := c.ImageStore.Get(ctx, key)
+		if err == nil {
+			return storedImage(c.ImageStore.GetURL(key), stored, c), nil
+		}
+		if err != images.ErrNotFound {
+			return templates.Image{}, err
+		}
+	}
+
+	url, err := c.ImageStore.Put(ctx, key, thumbnail)
+	if err != nil {
+		return templates.Image{}, err
+	}
+
+	return storedImage(url, thumbnail, c), nil
+}
+
+// Derives the key of an image with the configured strategy, random keys by default
+func imageKey(img image.Image, c *Config) string {
+	if c.ImageKeys == nil {
+		return images.RandomKey(img)
+	}
+
+	return c.ImageKeys(img)
+}
+
+// Stores a placeholder showing the link's site name, or its title when missing. Placeholders are keyed by
+// their text, so one that was already stored is reused rather than generated again
+func storePlaceholder(ctx context.Context, values templates.Values, c *Config) (templates.Image, error) {
+	text := values.SiteName
+	if text == "" {
+		text = values.Title
+	}
+	key := images.PlaceholderKey(text)
+
+	img, err := c.ImageStore.Get(ctx, key)
+	if err == images.ErrNotFound {
+		img = images.Thumbnail(images.Placeholder(text), c.ImageMaxWidth, c.ImageMaxHeight)
+		_, err = c.ImageStore.Put(ctx, key, img)
+	}
+	if err != nil {
+		return templates.Image{}, err
+	}
+
+	return storedImage(c.ImageStore.GetURL(key), img, c), nil
+}
+
+// Describes an image as the image store keeps it, with the dimensions and format it was stored with
+func storedImage(url string, img image.Image, c *Config) templates.Image {
+	width, height := images.StoredSize(c.ImageStore, img)
+	return templates.Image{
+		URL:    url,
+		Type:   images.StoredFormat(c.ImageStore, img).ContentType(),
+		Width:  width,
+		Height: height,
+	}
 }
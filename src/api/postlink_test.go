@@ -2,16 +2,26 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/devlucky/fakelink/src/images"
 	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"image"
+	"image/png"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestPostLinkWithWrongFormat(t *testing.T) {
@@ -51,6 +61,12 @@ func TestPostInvalidLink(t *testing.T) {
 	expectHeaderToContain(t, rr, "Access-Control-Allow-Origin", []string{"*"})
 }
 
+// The link an input is stored as, whose description is truncated to the default length
+func storedLink(link links.Link) links.Link {
+	link.Values.Description = templates.Truncate(link.Values.Description, links.DefaultMaxDescriptionLength)
+	return link
+}
+
 func TestPostLinkWithoutImage(t *testing.T) {
 	bodyBuf := &bytes.Buffer{}
 	bodyWriter := multipart.NewWriter(bodyBuf)
@@ -99,7 +115,7 @@ func TestPostLinkWithoutImage(t *testing.T) {
 		t.Error("Expected POST /links to return the slug that identifies the links")
 	}
 
-	if !reflect.DeepEqual(*link, input.Link) {
+	if !reflect.DeepEqual(*link, storedLink(input.Link)) {
 		t.Error("Expected input and saved links to be the same")
 	}
 }
@@ -172,4 +188,1062 @@ func TestPostLinkWithImage(t *testing.T) {
 	if link.Values.Image == input.Link.Values.Image {
 		t.Errorf("Expected the link's Image to point to the uploaded file. Instead, it points to %s", link.Values.Image)
 	}
+
+	uploaded := link.Values.ImageCandidates()[0]
+	if uploaded.URL != link.Values.Image || uploaded.Width == 0 || uploaded.Width > config.ImageMaxWidth || uploaded.Height == 0 || uploaded.Height > config.ImageMaxHeight {
+		t.Errorf("Expected the uploaded image to be the first candidate, with the thumbnail's dimensions. Instead, got %+v", uploaded)
+	}
+}
+
+func TestPostLinkRendersTheStoredImageDimensions(t *testing.T) {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 1200, 630)))
+
+	config := inMemoryConf()
+	config.ImageMaxWidth, config.ImageMaxHeight = 1200, 1200
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequestWithImage(t, &postLinkInput{Link: *links.RandomLink()}, "preview.png", buf.Bytes()))
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	rr = getLinkWithUserAgent(t, config, output.Slug, facebookUserAgent)
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{`<meta property="og:image:width" content="1200" />`, `<meta property="og:image:height" content="630" />`})
+}
+
+func TestPostLinkRendersTheStoredImageTypeAndSecureURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+
+	store, err := images.NewFileStore(dir, "https://cdn.example.com/images", images.Options{Format: images.PNG})
+	if err != nil {
+		t.Fatalf("Unexpected error creating the file store: %s", err)
+	}
+
+	config := inMemoryConf()
+	config.ImageStore = store
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequestWithImage(t, &postLinkInput{Link: *links.RandomLink()}, "preview.png", buf.Bytes()))
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+	link := config.LinkStore.Find(output.Slug)
+
+	rr = getLinkWithUserAgent(t, config, output.Slug, facebookUserAgent)
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{
+		fmt.Sprintf(`<meta property="og:image:secure_url" content="%s" />`, link.Values.Image),
+		`<meta property="og:image:type" content="image/png" />`,
+	})
+}
+
+func TestPostLinkWithContentKeysStoresIdenticalImagesOnce(t *testing.T) {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+
+	config := inMemoryConf()
+	config.ImageKeys = images.ContentKey
+
+	var urls []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequestWithImage(t, &postLinkInput{Link: *links.RandomLink()}, "campaign.png", buf.Bytes()))
+		expectStatus(t, rr, http.StatusCreated)
+
+		output := &postLinkOutput{}
+		json.Unmarshal(rr.Body.Bytes(), output)
+		urls = append(urls, config.LinkStore.Find(output.Slug).Values.Image)
+	}
+
+	if urls[0] != urls[1] {
+		t.Errorf("Expected identical images to be stored under the same key. Instead, got %s and %s", urls[0], urls[1])
+	}
+}
+
+func TestPostLinkWithRandomKeysStoresEveryImage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+
+	config := inMemoryConf()
+	var urls []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequestWithImage(t, &postLinkInput{Link: *links.RandomLink()}, "campaign.png", buf.Bytes()))
+		expectStatus(t, rr, http.StatusCreated)
+
+		output := &postLinkOutput{}
+		json.Unmarshal(rr.Body.Bytes(), output)
+		urls = append(urls, config.LinkStore.Find(output.Slug).Values.Image)
+	}
+
+	if urls[0] == urls[1] {
+		t.Errorf("Expected every image to be stored under a key of its own. Instead, both got %s", urls[0])
+	}
+}
+
+func TestPostLinkWithJSONBody(t *testing.T) {
+	input := &postLinkInput{
+		Link: *links.RandomLink(),
+	}
+	inputBytes, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling input to JSON: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", "/links", bytes.NewReader(inputBytes))
+	if err != nil {
+		t.Fatalf("Unexpected error creating a request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	config := inMemoryConf()
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	link := config.LinkStore.Find(output.Slug)
+	if link == nil {
+		t.Fatal("Expected POST /links to return the slug that identifies the links")
+	}
+
+	if !reflect.DeepEqual(*link, storedLink(input.Link)) {
+		t.Error("Expected input and saved links to be the same")
+	}
+}
+
+func TestPostLinkWithInvalidJSONBody(t *testing.T) {
+	req, err := http.NewRequest("POST", "/links", bytes.NewReader([]byte("{")))
+	if err != nil {
+		t.Fatalf("Unexpected error creating a request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestPostLinkReturnsTheLinkURL(t *testing.T) {
+	config := inMemoryConf()
+	config.PublicBaseURL = "https://fake.link/"
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink()}))
+
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := map[string]string{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &output); err != nil {
+		t.Fatalf("Expected a JSON response. Instead, got %s", rr.Body.String())
+	}
+
+	if len(output) != 2 || output["slug"] == "" || output["url"] != "https://fake.link/links/"+output["slug"] {
+		t.Errorf("Expected the response to hold the slug and the link's URL. Instead, got %s", rr.Body.String())
+	}
+
+	expectHeaderToContain(t, rr, "Location", []string{"https://fake.link/links/" + output["slug"]})
+}
+
+func TestPostLinkWithTemplateName(t *testing.T) {
+	input := &postLinkInput{Link: *links.RandomLink()}
+	input.Link.TemplateName = "opengraph"
+
+	config := inMemoryConf()
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	if link := config.LinkStore.Find(output.Slug); link == nil || link.TemplateName != "opengraph" {
+		t.Errorf("Expected the link to keep its template name. Instead, got %+v", link)
+	}
+}
+
+func TestPostLinkWithUnknownTemplateName(t *testing.T) {
+	input := &postLinkInput{Link: *links.RandomLink()}
+	input.Link.TemplateName = "missing"
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestPostLinkWithTTL(t *testing.T) {
+	input := &postLinkInput{Link: *links.RandomLink(), TTL: 60}
+
+	config := inMemoryConf()
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	link := config.LinkStore.Find(output.Slug)
+	if link == nil || link.ExpiresAt == nil || link.ExpiresAt.After(time.Now().Add(time.Minute)) || link.Expired() {
+		t.Errorf("Expected the link to expire within a minute. Instead, got %+v", link)
+	}
+}
+
+func TestPostLinkWithInvalidExpiration(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	expired := &postLinkInput{Link: *links.RandomLink()}
+	expired.Link.ExpiresAt = &past
+
+	for _, input := range []*postLinkInput{{Link: *links.RandomLink(), TTL: -1}, expired} {
+		rr := httptest.NewRecorder()
+		NewRouter(inMemoryConf()).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+		expectStatus(t, rr, http.StatusBadRequest)
+	}
+}
+
+func TestPostLinkWithUnsupportedImage(t *testing.T) {
+	uploads := map[string]string{
+		"not-an-image.txt": "definitely not an image",
+		"vector.svg":       `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`,
+		"error.html":       "<!DOCTYPE html><html><body>502 Bad Gateway</body></html>",
+	}
+
+	for filename, data := range uploads {
+		rr := httptest.NewRecorder()
+		NewRouter(inMemoryConf()).ServeHTTP(rr, newPostLinkRequestWithImage(t, &postLinkInput{Link: *links.RandomLink()}, filename, []byte(data)))
+
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected uploading %s to be answered with a 415. Instead, got %d", filename, rr.Code)
+		}
+	}
+}
+
+func TestPostLinkWithTruncatedImage(t *testing.T) {
+	data, err := ioutil.ReadFile("../../assets/images/sharknado.jpg")
+	if err != nil {
+		t.Fatalf("Unexpected error opening file sharknado.jpg: %s", err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, newPostLinkRequestWithImage(t, &postLinkInput{Link: *links.RandomLink()}, "sharknado.jpg", data[:len(data)/2]))
+
+	expectStatus(t, rr, http.StatusBadRequest)
+	expectBodyToContain(t, rr, []string{"could not be decoded"})
+}
+
+func TestPostLinkWithWebPImage(t *testing.T) {
+	data, err := ioutil.ReadFile("../../assets/images/video.webp")
+	if err != nil {
+		t.Fatalf("Unexpected error opening file video.webp: %s", err)
+	}
+	req := newPostLinkRequestWithImage(t, &postLinkInput{Link: *links.RandomLink()}, "video.webp", data)
+
+	config := inMemoryConf()
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	link := config.LinkStore.Find(output.Slug)
+	if link == nil {
+		t.Fatal("Expected POST /links to return the slug that identifies the links")
+	}
+
+	if uploaded := link.Values.ImageCandidates()[0]; uploaded.URL != link.Values.Image || uploaded.Width == 0 || uploaded.Height == 0 {
+		t.Errorf("Expected the uploaded WebP to be stored as the link's image. Instead, got %+v", uploaded)
+	}
+}
+
+// Test image servers listen on the loopback interface, which images are only mirrored from when fetches are unrestricted
+func mirroringConf() *Config {
+	config := inMemoryConf()
+	config.UnrestrictedFetches = true
+	return config
+}
+
+func TestPostLinkMirroringImage(t *testing.T) {
+	imageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeFile(w, r, "../../assets/images/sharknado.jpg")
+	}))
+	defer imageServer.Close()
+
+	input := &postLinkInput{
+		Link:        *links.RandomLink(),
+		MirrorImage: true,
+	}
+	input.Link.Values.Image = imageServer.URL
+
+	config := mirroringConf()
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	link := config.LinkStore.Find(output.Slug)
+	if link == nil {
+		t.Fatal("Expected POST /links to return the slug that identifies the links")
+	}
+
+	if link.Values.Image == imageServer.URL {
+		t.Errorf("Expected the link's Image to point to the mirrored file. Instead, it points to %s", link.Values.Image)
+	}
+}
+
+func TestPostLinkMirroringNonImage(t *testing.T) {
+	pageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer pageServer.Close()
+
+	input := &postLinkInput{
+		Link:        *links.RandomLink(),
+		MirrorImage: true,
+	}
+	input.Link.Values.Image = pageServer.URL
+
+	rr := httptest.NewRecorder()
+	NewRouter(mirroringConf()).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestPostLinkMirroringUnsupportedImage(t *testing.T) {
+	pageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Some servers answer missing images with an error page, despite the image content type
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("<!DOCTYPE html><html><body>Not Found</body></html>"))
+	}))
+	defer pageServer.Close()
+
+	input := &postLinkInput{
+		Link:        *links.RandomLink(),
+		MirrorImage: true,
+	}
+	input.Link.Values.Image = pageServer.URL
+
+	rr := httptest.NewRecorder()
+	NewRouter(mirroringConf()).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusUnsupportedMediaType)
+}
+
+func TestPostLinkMirroringSlowImage(t *testing.T) {
+	release := make(chan struct{})
+	imageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer imageServer.Close()
+	defer close(release)
+
+	input := &postLinkInput{
+		Link:        *links.RandomLink(),
+		MirrorImage: true,
+	}
+	input.Link.Values.Image = imageServer.URL
+
+	config := mirroringConf()
+	config.ImageFetchTimeout = 50 * time.Millisecond
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusGatewayTimeout)
+}
+
+func TestPostLinkMirroringOversizedImage(t *testing.T) {
+	imageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeFile(w, r, "../../assets/images/sharknado.jpg")
+	}))
+	defer imageServer.Close()
+
+	input := &postLinkInput{
+		Link:        *links.RandomLink(),
+		MirrorImage: true,
+	}
+	input.Link.Values.Image = imageServer.URL
+
+	config := mirroringConf()
+	config.ImageMaxBytes = 1024
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusBadRequest)
+	expectBodyToContain(t, rr, []string{"larger than 1024 bytes"})
+}
+
+func TestPostLinkMirroringImageOnPrivateAddress(t *testing.T) {
+	input := &postLinkInput{
+		Link:        *links.RandomLink(),
+		MirrorImage: true,
+	}
+	input.Link.Values.Image = "http://169.254.169.254/latest/meta-data/"
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusBadRequest)
+	expectBodyToContain(t, rr, []string{"must be on a public address"})
+}
+
+func TestPostLinkVerifyingImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+		case "/page.txt":
+			w.Header().Set("Content-Type", "text/plain")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for path, status := range map[string]int{
+		"/image.jpg":   http.StatusCreated,
+		"/missing.jpg": http.StatusUnprocessableEntity,
+		"/page.txt":    http.StatusUnprocessableEntity,
+	} {
+		input := &postLinkInput{Link: *links.RandomLink(), VerifyImage: true}
+		input.Link.Values.Image = server.URL + path
+
+		config := mirroringConf()
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+		if rr.Code != status {
+			t.Errorf("Expected verifying %s to answer with a %d. Instead, got %d", path, status, rr.Code)
+		}
+		if keys, _ := config.ImageStore.Keys(context.Background()); len(keys) != 0 {
+			t.Errorf("Expected verified images not to be stored. Instead, got %v", keys)
+		}
+	}
+}
+
+func TestPostLinkDoesNotVerifyImagesByDefault(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	input := &postLinkInput{Link: *links.RandomLink()}
+	input.Link.Values.Image = server.URL + "/missing.jpg"
+
+	rr := httptest.NewRecorder()
+	NewRouter(mirroringConf()).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusCreated)
+}
+
+// Counts the images put in the underlying store
+type countingImageStore struct {
+	images.Store
+	puts int
+}
+
+func (store *countingImageStore) Put(ctx context.Context, key string, img image.Image) (string, error) {
+	store.puts++
+	return store.Store.Put(ctx, key, img)
+}
+
+func TestPostLinkWithoutImageGetsAPlaceholder(t *testing.T) {
+	config := inMemoryConf()
+	config.PlaceholderImages = true
+	store := &countingImageStore{Store: config.ImageStore}
+	config.ImageStore = store
+
+	for i := 0; i < 2; i++ {
+		input := &postLinkInput{Link: *links.RandomLink()}
+		input.Link.Values.SiteName = "DevLucky"
+		input.Link.Values.Image = ""
+		input.Link.Values.Images = nil
+
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+		expectStatus(t, rr, http.StatusCreated)
+
+		output := &postLinkOutput{}
+		json.Unmarshal(rr.Body.Bytes(), output)
+
+		link := config.LinkStore.Find(output.Slug)
+		if link == nil {
+			t.Fatal("Expected POST /links to return the slug that identifies the links")
+		}
+		if link.Values.Image != config.ImageStore.GetURL(images.PlaceholderKey("DevLucky")) {
+			t.Fatalf("Expected the link's Image to point to the placeholder. Instead, it points to %q", link.Values.Image)
+		}
+		if candidates := link.Values.ImageCandidates(); len(candidates) != 1 || candidates[0].Width == 0 || candidates[0].Height == 0 {
+			t.Errorf("Expected the placeholder to be the only candidate, with its dimensions. Instead, got %+v", candidates)
+		}
+	}
+
+	if store.puts != 1 {
+		t.Errorf("Expected identical placeholders to be stored once. Instead, they were stored %d times", store.puts)
+	}
+}
+
+func TestPostLinkWithImageGetsNoPlaceholder(t *testing.T) {
+	config := inMemoryConf()
+	config.PlaceholderImages = true
+
+	input := &postLinkInput{Link: *links.RandomLink()}
+	input.Link.Values.Image = "http://example.com/image.jpg"
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	if link := config.LinkStore.Find(output.Slug); link == nil || link.Values.Image != "http://example.com/image.jpg" {
+		t.Errorf("Expected links with an image to keep it. Instead, got %+v", link)
+	}
+}
+
+// Builds a multipart/form-data POST /links request whose "json" field holds the given input
+func newPostLinkRequest(t *testing.T, input *postLinkInput) *http.Request {
+	bodyBuf := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(bodyBuf)
+
+	inputBytes, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling input to JSON: %s", err)
+	}
+	err = bodyWriter.WriteField("json", string(inputBytes))
+	if err != nil {
+		t.Fatalf("Unexpected error writing multipart/form-data: %s", err)
+	}
+	bodyWriter.Close()
+
+	req, err := http.NewRequest("POST", "/links", bodyBuf)
+	if err != nil {
+		t.Fatalf("Unexpected error creating a request: %s", err)
+	}
+	req.Header.Set("Content-Type", bodyWriter.FormDataContentType())
+
+	return req
+}
+
+// Builds a multipart/form-data POST /links request uploading the given data as its "image"
+func newPostLinkRequestWithImage(t *testing.T, input *postLinkInput, filename string, data []byte) *http.Request {
+	bodyBuf := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(bodyBuf)
+
+	inputBytes, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling input to JSON: %s", err)
+	}
+	bodyWriter.WriteField("json", string(inputBytes))
+
+	fileWriter, err := bodyWriter.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatalf("Unexpected error writing multipart/form-data: %s", err)
+	}
+	fileWriter.Write(data)
+	bodyWriter.Close()
+
+	req, err := http.NewRequest("POST", "/links", bodyBuf)
+	if err != nil {
+		t.Fatalf("Unexpected error creating a request: %s", err)
+	}
+	req.Header.Set("Content-Type", bodyWriter.FormDataContentType())
+
+	return req
+}
+
+func TestPostLinkValidationErrors(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	cases := []struct {
+		name   string
+		input  *postLinkInput
+		fields []links.FieldError
+	}{
+		{
+			"missing title",
+			&postLinkInput{Link: links.Link{Values: templates.Values{URL: "https://example.com"}}},
+			[]links.FieldError{{Field: "title", Message: "required"}},
+		},
+		{
+			"missing url",
+			&postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title"}}},
+			[]links.FieldError{{Field: "url", Message: "required"}},
+		},
+		{
+			"missing title and url",
+			&postLinkInput{},
+			[]links.FieldError{{Field: "title", Message: "required"}, {Field: "url", Message: "required"}},
+		},
+		{
+			"invalid url",
+			&postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "ftp://example.com"}}},
+			[]links.FieldError{{Field: "url", Message: `must use the http or https scheme, but it was "ftp://example.com"`}},
+		},
+		{
+			"invalid image",
+			&postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com", Image: "ftp://example.com/a.jpg"}}},
+			[]links.FieldError{{Field: "image", Message: `must use the http or https scheme, but it was "ftp://example.com/a.jpg"`}},
+		},
+		{
+			"control characters in the description",
+			&postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com", Description: "a\nb"}}},
+			[]links.FieldError{{Field: "description", Message: "can't contain control characters"}},
+		},
+		{
+			"unknown template",
+			&postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com"}, TemplateName: "missing"}},
+			[]links.FieldError{{Field: "template_name", Message: `there is no template named "missing"`}},
+		},
+		{
+			"negative ttl",
+			&postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com"}}, TTL: -1},
+			[]links.FieldError{{Field: "ttl", Message: "can't be negative"}},
+		},
+		{
+			"past expiration date",
+			&postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com"}, ExpiresAt: &past}},
+			[]links.FieldError{{Field: "expires_at", Message: "has already passed"}},
+		},
+		{
+			"invalid custom slug",
+			&postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com"}}, Slug: "Summer_Sale"},
+			[]links.FieldError{{Field: "slug", Message: "must be 3 to 64 lowercase letters, digits or dashes, not ending with a dash and a number"}},
+		},
+	}
+
+	for _, c := range cases {
+		config := inMemoryConf()
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, c.input))
+
+		expectStatus(t, rr, http.StatusBadRequest)
+
+		output := &validationErrorOutput{}
+		if err := json.Unmarshal(rr.Body.Bytes(), output); err != nil {
+			t.Fatalf("%s: expected a JSON response. Instead, got %s", c.name, rr.Body.String())
+		}
+
+		if !reflect.DeepEqual(output.Errors, c.fields) {
+			t.Errorf("%s: expected the errors %+v. Instead, got %s", c.name, c.fields, rr.Body.String())
+		}
+
+		if config.LinkStore.FindRandom() != "" {
+			t.Errorf("%s: expected no link to be created", c.name)
+		}
+	}
+}
+
+func TestPostLinkWithCustomSlug(t *testing.T) {
+	config := inMemoryConf()
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink(), Slug: "summer-sale"}))
+
+	expectStatus(t, rr, http.StatusCreated)
+	expectHeaderToContain(t, rr, "Location", []string{"/links/summer-sale"})
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+	if output.Slug != "summer-sale" || config.LinkStore.Find("summer-sale") == nil {
+		t.Errorf("Expected the link to be stored under its custom slug. Instead, got %s", rr.Body.String())
+	}
+}
+
+func TestPostLinkGetsARandomSlug(t *testing.T) {
+	config := inMemoryConf()
+	config.SlugLength = 10
+	router := NewRouter(config)
+
+	var slugs []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.ExampleLinks[0]}))
+		expectStatus(t, rr, http.StatusCreated)
+
+		output := &postLinkOutput{}
+		json.Unmarshal(rr.Body.Bytes(), output)
+		slugs = append(slugs, output.Slug)
+	}
+
+	if len(slugs[0]) != 10 || slugs[0] == slugs[1] {
+		t.Errorf("Expected every link to get a random slug of the configured length. Instead, got %v", slugs)
+	}
+}
+
+func TestPostLinkTwiceWithDeterministicSlugs(t *testing.T) {
+	config := inMemoryConf()
+	config.DeterministicSlugs = true
+	config.RenderCacheSize = 10
+	router := NewRouter(config)
+
+	first := httptest.NewRecorder()
+	router.ServeHTTP(first, newPostLinkRequest(t, &postLinkInput{Link: *links.ExampleLinks[0]}))
+	expectStatus(t, first, http.StatusCreated)
+
+	firstOutput := &postLinkOutput{}
+	json.Unmarshal(first.Body.Bytes(), firstOutput)
+	page := getLinkWithUserAgent(t, config, firstOutput.Slug, facebookUserAgent)
+
+	again := *links.ExampleLinks[0]
+	again.Values.Title = "Another client's title"
+	second := httptest.NewRecorder()
+	router.ServeHTTP(second, newPostLinkRequest(t, &postLinkInput{Link: again}))
+	expectStatus(t, second, http.StatusOK)
+
+	secondOutput := &postLinkOutput{}
+	json.Unmarshal(second.Body.Bytes(), secondOutput)
+	if firstOutput.Slug != links.ExampleLinks[0].Slug() || secondOutput.Slug != firstOutput.Slug {
+		t.Errorf("Expected the link to get the slug derived from its URL both times, %s. Instead, got %s and %s", links.ExampleLinks[0].Slug(), firstOutput.Slug, secondOutput.Slug)
+	}
+	if second.Header().Get("Location") != first.Header().Get("Location") {
+		t.Error("Expected the second response to point to the existing link")
+	}
+
+	if link := config.LinkStore.Find(firstOutput.Slug); link == nil || link.Values.Title != links.ExampleLinks[0].Values.Title {
+		t.Error("Expected posting the same page again to leave its link as it was")
+	}
+	if n := countLinks(t, config); n != 1 {
+		t.Errorf("Expected posting the same page twice not to create a duplicate. Instead, there are %d links", n)
+	}
+	if rendered := getLinkWithUserAgent(t, config, firstOutput.Slug, facebookUserAgent); rendered.Body.String() != page.Body.String() {
+		t.Error("Expected the page of the link to stay the same")
+	}
+}
+
+func TestPostLinkAgainWithDeterministicSlugsDeletesTheUnusedImage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+
+	config := inMemoryConf()
+	config.DeterministicSlugs = true
+	router := NewRouter(config)
+	input := &postLinkInput{Link: *links.ExampleLinks[0]}
+
+	for _, status := range []int{http.StatusCreated, http.StatusOK} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, newPostLinkRequestWithImage(t, input, "preview.png", buf.Bytes()))
+		expectStatus(t, rr, status)
+	}
+
+	keys, err := config.ImageStore.Keys(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error listing the images: %s", err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("Expected only the image of the existing link to be kept. Instead, there are %d images", len(keys))
+	}
+}
+
+func TestPostLinkWithDeterministicSlugsReplacesAnExpiredLink(t *testing.T) {
+	config := inMemoryConf()
+	config.DeterministicSlugs = true
+	router := NewRouter(config)
+
+	expired := *links.ExampleLinks[0]
+	past := time.Now().Add(-time.Minute)
+	expired.ExpiresAt = &past
+	slug := config.LinkStore.Create(&expired)
+	expectStatus(t, getLinkWithUserAgent(t, config, slug, facebookUserAgent), http.StatusGone)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.ExampleLinks[0]}))
+	expectStatus(t, rr, http.StatusCreated)
+
+	expectStatus(t, getLinkWithUserAgent(t, config, slug, facebookUserAgent), http.StatusOK)
+}
+
+func TestPostPrivateLinkWithDeterministicSlugsGetsARandomSlug(t *testing.T) {
+	config := inMemoryConf()
+	config.DeterministicSlugs = true
+	router := NewRouter(config)
+	private := *links.ExampleLinks[0]
+	private.Private = true
+
+	var slugs []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: private}))
+		expectStatus(t, rr, http.StatusCreated)
+
+		output := &postLinkOutput{}
+		json.Unmarshal(rr.Body.Bytes(), output)
+		slugs = append(slugs, output.Slug)
+	}
+
+	public := &links.Link{Values: private.Values}
+	if slugs[0] == slugs[1] || strings.HasPrefix(slugs[0], public.Slug()) {
+		t.Errorf("Expected private links to get random slugs, so that their URL does not give them away. Instead, got %v", slugs)
+	}
+}
+
+func TestPostLinkWithReservedSlug(t *testing.T) {
+	for _, slug := range []string{"random", "healthz", "metrics", "links", "images"} {
+		config := inMemoryConf()
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink(), Slug: slug}))
+
+		expectStatus(t, rr, http.StatusBadRequest)
+		expectBodyToContain(t, rr, []string{`"field":"slug"`, "is reserved"})
+		if config.LinkStore.Find(slug) != nil {
+			t.Errorf("Expected no link to be created under the reserved slug %s", slug)
+		}
+	}
+}
+
+func TestPostLinkWithConfiguredReservedSlugs(t *testing.T) {
+	config := inMemoryConf()
+	config.ReservedSlugs = []string{"admin"}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink(), Slug: "admin"}))
+	expectStatus(t, rr, http.StatusBadRequest)
+
+	rr = httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink(), Slug: "summer-sale"}))
+	expectStatus(t, rr, http.StatusCreated)
+}
+
+func TestPostLinkWithTakenCustomSlug(t *testing.T) {
+	config := inMemoryConf()
+	existing := *links.RandomLink()
+	existing.Private = true
+	config.LinkStore.CreateWithSlug("summer-sale", &existing)
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink(), Slug: "summer-sale"}))
+
+	expectStatus(t, rr, http.StatusConflict)
+	if config.LinkStore.Find("summer-sale") != nil {
+		t.Error("Expected no public link to be created under a slug a private link took")
+	}
+}
+
+// Meant to be run with -race as well. Only one of the requests may take the slug, the others being told it is taken
+func TestPostLinkWithConcurrentCustomSlugs(t *testing.T) {
+	config := inMemoryConf()
+	router := NewRouter(config)
+
+	statuses := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink(), Slug: "summer-sale"}))
+			statuses <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("Expected every request to either take the slug or conflict. Instead, got %d", status)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("Expected exactly one request to take the slug. Instead, %d did", created)
+	}
+}
+
+func TestPostLinkWithOnlyATargetURL(t *testing.T) {
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", TargetURL: "https://example.com"}}}
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusCreated)
+}
+
+func TestPostLinkWithDataURIImage(t *testing.T) {
+	buf := new(bytes.Buffer)
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	input := &postLinkInput{Link: *links.RandomLink()}
+	input.Link.Values.Image = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	config := inMemoryConf()
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	link := config.LinkStore.Find(output.Slug)
+	if link == nil {
+		t.Fatal("Expected the link to be stored")
+	}
+
+	key, ok := storedImageKey(link.Values.Image, config)
+	if !ok {
+		t.Fatalf("Expected the image to be rewritten to a stored one. Instead, it was %.40s", link.Values.Image)
+	}
+
+	if _, err := config.ImageStore.Get(context.Background(), key); err != nil {
+		t.Errorf("Expected the embedded image to be stored. Instead, got %s", err)
+	}
+}
+
+func TestPostLinkWithInvalidDataURIImage(t *testing.T) {
+	buf := new(bytes.Buffer)
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 8, 4)))
+
+	config := inMemoryConf()
+	config.ImageMaxBytes = int64(buf.Len() - 1)
+
+	for uri, message := range map[string]string{
+		"data:image/png;base64,not base64!":                                        "is not a base64 data URI of the declared image type",
+		"data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("x")): "must be a JPEG, PNG, GIF or WebP",
+		"data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()):  fmt.Sprintf("is larger than %d bytes", config.ImageMaxBytes),
+	} {
+		input := &postLinkInput{Link: *links.RandomLink()}
+		input.Link.Values.Image = uri
+
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+		expectStatus(t, rr, http.StatusBadRequest)
+		expectBodyToContain(t, rr, []string{`"field":"image"`, message})
+	}
+}
+
+func TestPostLinkWithConfiguredDefaults(t *testing.T) {
+	config := inMemoryConf()
+	config.DefaultSiteName = "Fakelink"
+	config.DefaultType = "article"
+
+	withoutDefaults := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/a"}}}
+	withValues := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/b", SiteName: "IMDb", Type: "video.movie"}}}
+
+	for input, expected := range map[*postLinkInput]templates.Values{
+		withoutDefaults: {SiteName: "Fakelink", Type: "article"},
+		withValues:      {SiteName: "IMDb", Type: "video.movie"},
+	} {
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+		expectStatus(t, rr, http.StatusCreated)
+
+		output := &postLinkOutput{}
+		json.Unmarshal(rr.Body.Bytes(), output)
+
+		link := config.LinkStore.Find(output.Slug)
+		if link == nil || link.Values.SiteName != expected.SiteName || link.Values.Type != expected.Type {
+			t.Errorf("Expected the link to have the site name %q and type %q. Instead, got %+v", expected.SiteName, expected.Type, link)
+		}
+	}
+}
+
+func TestPostLinkWithoutType(t *testing.T) {
+	config := inMemoryConf()
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com"}}}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+
+	rr = getLinkWithUserAgent(t, config, output.Slug, facebookUserAgent)
+	expectBodyToContain(t, rr, []string{`<meta property="og:type" content="website" />`})
+}
+
+func TestPostLinkWithBogusType(t *testing.T) {
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com", Type: "vido.movie"}}}
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, newPostLinkRequest(t, input))
+
+	expectStatus(t, rr, http.StatusBadRequest)
+	expectBodyToContain(t, rr, []string{`"field":"type"`, "is not an Open Graph type, did you mean"})
+}
+
+func TestPostLinkToBlockedHost(t *testing.T) {
+	config := inMemoryConf()
+	config.BlockedHosts = []string{"*.phishing.net"}
+
+	for _, values := range []templates.Values{
+		{Title: "Some title", URL: "https://bank.phishing.net/login"},
+		{Title: "Some title", URL: "https://example.com", TargetURL: "https://bank.phishing.net/login"},
+	} {
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: links.Link{Values: values}}))
+
+		expectStatus(t, rr, http.StatusForbidden)
+		expectBodyToContain(t, rr, []string{"Links can't point to this host"})
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: links.Link{Values: templates.Values{Title: "Some title", URL: "https://example.com"}}}))
+	expectStatus(t, rr, http.StatusCreated)
+}
+
+func TestPostLinkToAllowedHosts(t *testing.T) {
+	config := inMemoryConf()
+	config.AllowedHosts = []string{"example.com", "*.example.com"}
+
+	for url, status := range map[string]int{
+		"https://example.com":          http.StatusCreated,
+		"https://shop.example.com":     http.StatusCreated,
+		"https://example.org":          http.StatusForbidden,
+		"https://example.com.evil.org": http.StatusForbidden,
+	} {
+		rr := httptest.NewRecorder()
+		NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, &postLinkInput{Link: links.Link{Values: templates.Values{Title: "Some title", URL: url}}}))
+
+		if rr.Code != status {
+			t.Errorf("Expected a link to %s to get a %d. Instead, got %d", url, status, rr.Code)
+		}
+	}
+}
+
+func TestPostLinkTruncatesLongDescriptions(t *testing.T) {
+	config := inMemoryConf()
+	config.MaxDescriptionLen = 20
+
+	input := &postLinkInput{Link: *links.RandomLink()}
+	input.Link.Values.Description = "Two imprisoned men bond over a number of years"
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequest(t, input))
+	expectStatus(t, rr, http.StatusCreated)
+
+	output := &postLinkOutput{}
+	json.Unmarshal(rr.Body.Bytes(), output)
+	if link := config.LinkStore.Find(output.Slug); link == nil || link.Values.Description != "Two imprisoned men…" {
+		t.Errorf("Expected the stored description to be truncated to the configured length. Instead, got %+v", link)
+	}
 }
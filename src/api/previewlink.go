@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/links"
+	"net/http"
+)
+
+type previewLinkOutput struct {
+	HTML string      `json:"html"`
+	Link *links.Link `json:"link"`
+}
+
+// Responds with the page scrapers would get for a link that was validated but not stored, along with the link
+// itself. New links have no slug yet, so their page has no URL of its own
+func previewLink(w http.ResponseWriter, r *http.Request, slug string, link *links.Link, c *Config) {
+	body, err := renderLink(link, linkPage(r, slug, link, c), c)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "The link could not be rendered", err, c)
+		return
+	}
+
+	jsonResp, err := json.Marshal(&previewLinkOutput{HTML: body.String(), Link: link})
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
+		return
+	}
+
+	response(w, http.StatusOK, jsonResp)
+}
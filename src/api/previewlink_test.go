@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func previewOutput(t *testing.T, rr *httptest.ResponseRecorder) *previewLinkOutput {
+	output := &previewLinkOutput{}
+	if err := json.Unmarshal(rr.Body.Bytes(), output); err != nil {
+		t.Fatalf("Expected a JSON response. Instead, got %s", rr.Body.String())
+	}
+
+	return output
+}
+
+func TestPostLinkDryRun(t *testing.T) {
+	config := inMemoryConf()
+	config.PlaceholderImages = true
+	store := &countingImageStore{Store: config.ImageStore}
+	config.ImageStore = store
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com"}}}
+	req := newPostLinkRequest(t, input)
+	req.URL.RawQuery = "dryRun=true"
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusOK)
+	output := previewOutput(t, rr)
+
+	if !strings.Contains(output.HTML, `<meta property="og:title" content="some-title" />`) || strings.Contains(output.HTML, "oembed") {
+		t.Errorf("Expected the page scrapers would get, without URLs of its own. Instead, got %s", output.HTML)
+	}
+
+	if output.Link == nil || output.Link.Values.Title != "some-title" {
+		t.Errorf("Expected the computed link. Instead, got %+v", output.Link)
+	}
+
+	if config.LinkStore.FindRandom() != "" || rr.Header().Get("Location") != "" {
+		t.Error("Expected no link to be stored")
+	}
+
+	if store.puts != 0 {
+		t.Errorf("Expected no placeholder to be stored. Instead, %d images were", store.puts)
+	}
+}
+
+func TestPostLinkPreviewWithImage(t *testing.T) {
+	config := inMemoryConf()
+	store := &countingImageStore{Store: config.ImageStore}
+	config.ImageStore = store
+
+	data, err := ioutil.ReadFile("../../assets/images/sharknado.jpg")
+	if err != nil {
+		t.Fatalf("Unexpected error opening file sharknado.jpg: %s", err)
+	}
+
+	input := &postLinkInput{Link: *links.RandomLink(), Preview: true}
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequestWithImage(t, input, "sharknado.jpg", data))
+
+	expectStatus(t, rr, http.StatusOK)
+	if store.puts != 0 || config.LinkStore.FindRandom() != "" {
+		t.Errorf("Expected neither the image nor the link to be stored. Instead, %d images were", store.puts)
+	}
+
+	rr = httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, newPostLinkRequestWithImage(t, input, "sharknado.txt", []byte("not an image")))
+	expectStatus(t, rr, http.StatusUnsupportedMediaType)
+}
+
+func TestPostLinkDryRunOfAnInvalidLink(t *testing.T) {
+	req := newPostLinkRequest(t, &postLinkInput{Link: links.Link{Values: templates.Values{Title: "some-title"}}})
+	req.URL.RawQuery = "dryRun=true"
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusBadRequest)
+	expectBodyToContain(t, rr, []string{`"field":"url"`})
+}
+
+func TestPutLinkDryRun(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Old title"}})
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "New title", URL: "https://example.com"}}, Preview: true}
+	rr := putLinkRequest(t, config, slug, input)
+
+	expectStatus(t, rr, http.StatusOK)
+	if output := previewOutput(t, rr); !strings.Contains(output.HTML, "New title") || !strings.Contains(output.HTML, "/oembed?url=") {
+		t.Errorf("Expected the page of the updated link. Instead, got %s", output.HTML)
+	}
+
+	if link := config.LinkStore.Find(slug); link.Values.Title != "Old title" {
+		t.Error("Expected the link not to be updated")
+	}
+}
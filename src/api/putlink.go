@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+type putLinkOutput struct {
+	Slug string      `json:"slug"`
+	URL  string      `json:"url"`
+	Link *links.Link `json:"link"`
+}
+
+// Replaces the values of an existing link, keeping its slug. The body is the same one POST /links takes,
+// although privacy can't change since it is part of the slug. Mirrored images are only fetched again
+// when the image changes. Like POST /links, it can be a dry run
+func putLink(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	slug := ps.ByName("slug")
+
+	existing := c.LinkStore.Find(slug)
+	if existing == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Links keep their slug, so a custom one in the body is ignored
+	link, _, preview := readLink(w, r, c, existing.Values.Image)
+	if link == nil {
+		return
+	}
+	link.Private = existing.Private
+
+	if preview {
+		previewLink(w, r, slug, link, c)
+		return
+	}
+
+	if !c.LinkStore.Update(slug, link) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	c.renderCache().invalidate(slug)
+
+	jsonResp, err := json.Marshal(&putLinkOutput{Slug: slug, URL: linkURL(r, slug, c), Link: link})
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "Unexpected error when marshaling the response into JSON", err, c)
+		return
+	}
+
+	response(w, http.StatusOK, jsonResp)
+}
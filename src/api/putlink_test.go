@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func putLinkRequest(t *testing.T, config *Config, slug string, input *postLinkInput) *httptest.ResponseRecorder {
+	inputBytes, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling input to JSON: %s", err)
+	}
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/links/%s", slug), bytes.NewReader(inputBytes))
+	if err != nil {
+		t.Fatalf("Unexpected error creating a request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPutLink(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Private: true, Values: templates.Values{Title: "Old title"}})
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "New title", URL: "https://example.com/new"}}}
+	rr := putLinkRequest(t, config, slug, input)
+
+	expectStatus(t, rr, http.StatusOK)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"application/json"})
+
+	output := &putLinkOutput{}
+	if err := json.Unmarshal(rr.Body.Bytes(), output); err != nil {
+		t.Fatalf("Expected a JSON response. Instead, got %s", rr.Body.String())
+	}
+
+	if output.Slug != slug || !strings.HasSuffix(output.URL, "/links/"+slug) || output.Link.Values.Title != "New title" {
+		t.Errorf("Expected the response to hold the updated link. Instead, got %s", rr.Body.String())
+	}
+
+	link := config.LinkStore.Find(slug)
+	if link == nil || link.Values.Title != "New title" || !link.Private {
+		t.Errorf("Expected the stored link to be updated, keeping its privacy. Instead, got %+v", link)
+	}
+}
+
+func TestPutMissingLink(t *testing.T) {
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "New title", URL: "https://example.com/new"}}}
+	rr := putLinkRequest(t, inMemoryConf(), "missing", input)
+
+	expectStatus(t, rr, http.StatusNotFound)
+}
+
+func TestPutInvalidLink(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Old title"}})
+
+	rr := putLinkRequest(t, config, slug, &postLinkInput{})
+
+	expectStatus(t, rr, http.StatusBadRequest)
+	if link := config.LinkStore.Find(slug); link.Values.Title != "Old title" {
+		t.Error("Expected an invalid update to leave the link untouched")
+	}
+}
+
+func TestPutLinkMirrorsOnlyChangedImages(t *testing.T) {
+	fetches := 0
+	imageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "image/jpeg")
+		http.ServeFile(w, r, "../../assets/images/sharknado.jpg")
+	}))
+	defer imageServer.Close()
+
+	config := mirroringConf()
+	mirrored := config.ImageStore.GetURL("mirrored")
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Some title", Image: mirrored}})
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "Other title", URL: "https://example.com/other", Image: mirrored}}, MirrorImage: true}
+	expectStatus(t, putLinkRequest(t, config, slug, input), http.StatusOK)
+
+	if fetches != 0 {
+		t.Error("Expected an unchanged image not to be mirrored again")
+	}
+
+	input.Link.Values.Image = imageServer.URL
+	expectStatus(t, putLinkRequest(t, config, slug, input), http.StatusOK)
+
+	if fetches != 1 {
+		t.Errorf("Expected a changed image to be mirrored once. Instead, it was fetched %d times", fetches)
+	}
+
+	if image := config.LinkStore.Find(slug).Values.Image; image == imageServer.URL || image == mirrored {
+		t.Errorf("Expected the link's Image to point to the newly mirrored file. Instead, it points to %s", image)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets are pruned once there are this many, so that the limiter's memory stays bounded. When too many clients
+// are still limited, the ones seen least recently are forgotten down to the low mark, so that pruning is rare
+const (
+	maxRateLimitBuckets     = 10000
+	rateLimitBucketsLowMark = maxRateLimitBuckets * 9 / 10
+)
+
+// A token bucket per client: each request takes a token, and tokens come back at a fixed rate up to the burst
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Takes a token from the client's bucket. When there is none left, it returns how long until the next one
+func (limiter *rateLimiter) take(client string) (ok bool, retryAfter time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	if len(limiter.buckets) >= maxRateLimitBuckets {
+		limiter.prune(now)
+	}
+
+	bucket, found := limiter.buckets[client]
+	if !found {
+		bucket = &tokenBucket{tokens: limiter.burst, last: now}
+		limiter.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// Forgets the clients whose buckets are full again, as they are no different from new ones, then the ones seen
+// least recently while there are still too many
+func (limiter *rateLimiter) prune(now time.Time) {
+	for client, bucket := range limiter.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate >= limiter.burst {
+			delete(limiter.buckets, client)
+		}
+	}
+
+	if len(limiter.buckets) < maxRateLimitBuckets {
+		return
+	}
+
+	clients := make([]string, 0, len(limiter.buckets))
+	for client := range limiter.buckets {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return limiter.buckets[clients[i]].last.Before(limiter.buckets[clients[j]].last)
+	})
+
+	for _, client := range clients[:len(clients)-rateLimitBucketsLowMark] {
+		delete(limiter.buckets, client)
+	}
+}
+
+// Limits every client to rate requests per second, with bursts of up to burst requests. A non-positive rate
+// disables the limit. Limited requests get a 429 with a Retry-After header
+func rateLimit(rate float64, burst int) middleware {
+	if rate <= 0 {
+		return func(next handler) handler { return next }
+	}
+
+	limiter := newRateLimiter(rate, burst)
+	return func(next handler) handler {
+		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+			ok, retryAfter := limiter.take(clientIP(r, c))
+			if !ok {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+				errorResponse(w, http.StatusTooManyRequests, "Too many requests, try again later", fmt.Errorf("Rate limited for %ds", seconds), c)
+				return
+			}
+
+			next(w, r, ps, c)
+		}
+	}
+}
+
+// The IP address of the client. Behind our proxy it is the last one X-Forwarded-For lists, as that is the
+// one the proxy itself appended. Otherwise the header is ignored, as any client could forge it
+func clientIP(r *http.Request, c *Config) string {
+	if c.BehindProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			ips := strings.Split(forwarded, ",")
+			return strings.TrimSpace(ips[len(ips)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
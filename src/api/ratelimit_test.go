@@ -0,0 +1,107 @@
+package api
+
+import (
+	"github.com/devlucky/fakelink/src/links"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func postFrom(t *testing.T, router http.Handler, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := newPostLinkRequest(t, &postLinkInput{Link: *links.RandomLink()})
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPostLinkRateLimit(t *testing.T) {
+	config := inMemoryConf()
+	config.PostRateLimit = 0.01
+	config.PostRateBurst = 3
+	router := NewRouter(config)
+
+	for i := 0; i < config.PostRateBurst; i++ {
+		expectStatus(t, postFrom(t, router, "10.0.0.1:1234", ""), http.StatusCreated)
+	}
+
+	rr := postFrom(t, router, "10.0.0.1:1234", "")
+	expectStatus(t, rr, http.StatusTooManyRequests)
+
+	retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 {
+		t.Errorf("Expected a Retry-After header in seconds. Instead, it was %q", rr.Header().Get("Retry-After"))
+	}
+
+	expectStatus(t, postFrom(t, router, "10.0.0.2:1234", ""), http.StatusCreated)
+}
+
+func TestPostLinkRateLimitBehindProxy(t *testing.T) {
+	config := inMemoryConf()
+	config.PostRateLimit = 0.01
+	config.PostRateBurst = 1
+	config.BehindProxy = true
+	router := NewRouter(config)
+
+	expectStatus(t, postFrom(t, router, "10.0.0.1:1234", "1.1.1.1"), http.StatusCreated)
+	expectStatus(t, postFrom(t, router, "10.0.0.1:1234", "1.1.1.1"), http.StatusTooManyRequests)
+	expectStatus(t, postFrom(t, router, "10.0.0.1:1234", "1.1.1.1, 2.2.2.2"), http.StatusCreated)
+}
+
+func TestPostLinkWithoutRateLimit(t *testing.T) {
+	router := NewRouter(inMemoryConf())
+
+	for i := 0; i < 20; i++ {
+		expectStatus(t, postFrom(t, router, "10.0.0.1:1234", ""), http.StatusCreated)
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(2, 1)
+	limiter.now = func() time.Time { return now }
+
+	if ok, _ := limiter.take("client"); !ok {
+		t.Fatal("Expected the first request to be allowed")
+	}
+
+	ok, retryAfter := limiter.take("client")
+	if ok {
+		t.Fatal("Expected the second request to be limited")
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("Expected to retry once a token is back. Instead, retry after %s", retryAfter)
+	}
+
+	now = now.Add(retryAfter)
+	if ok, _ := limiter.take("client"); !ok {
+		t.Error("Expected the request to be allowed once the bucket refilled")
+	}
+}
+
+func TestRateLimiterForgetsTheLeastRecentClientsOverTheCap(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(1, 1)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i <= maxRateLimitBuckets; i++ {
+		now = now.Add(time.Millisecond)
+		limiter.take("client-" + strconv.Itoa(i))
+	}
+
+	if len(limiter.buckets) > maxRateLimitBuckets {
+		t.Fatalf("Expected at most %d buckets. Instead, got %d", maxRateLimitBuckets, len(limiter.buckets))
+	}
+	if _, ok := limiter.buckets["client-0"]; ok {
+		t.Error("Expected the least recent client to be forgotten")
+	}
+	if ok, _ := limiter.take("client-" + strconv.Itoa(maxRateLimitBuckets)); ok {
+		t.Error("Expected the most recent client to still be limited")
+	}
+}
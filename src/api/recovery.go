@@ -0,0 +1,43 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/devlucky/fakelink/src/logs"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"runtime/debug"
+)
+
+// Turns a panicking request into a plain 500, logging the panic and its stack rather than showing them to the client.
+// It goes outside every other middleware, so that their panics are caught too, and finds the request's ID in the
+// X-Request-ID header withRequestLog set
+func withRecovery(next handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// Aborting a handler is how the standard library cuts off a response on purpose
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			err := fmt.Errorf("%v", recovered)
+			fields := logs.Fields{"method": r.Method, "path": r.URL.Path, "stack": string(debug.Stack())}
+			if id := w.Header().Get("X-Request-ID"); id != "" {
+				fields["request_id"] = id
+			}
+			c.Logger.Error("A handler panicked", err, fields)
+
+			errorResponse(w, http.StatusInternalServerError, "Unexpected internal error", errPanicked, c)
+		}()
+
+		next(w, r, ps, c)
+	}
+}
+
+// What clients are told in debug mode, as even there panics and their stacks are only logged
+var errPanicked = errors.New("The request could not be handled, the details are in the logs")
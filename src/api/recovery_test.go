@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/logs"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func panicking(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+	var values map[string]string
+	values["boom"] = "assignment to entry in nil map"
+}
+
+func TestRecoveryFromPanic(t *testing.T) {
+	buf := &bytes.Buffer{}
+	config := inMemoryConf()
+	config.Logger = logs.New(buf)
+
+	req, err := http.NewRequest("GET", "/panic", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "some-request-id")
+
+	router := httprouter.New()
+	router.GET("/panic", injectConfig(config, chain(panicking, withRecovery, withRequestLog)))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusInternalServerError)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"application/json"})
+	expectBodyToContain(t, rr, []string{`"message":"Unexpected internal error"`})
+
+	if strings.Contains(rr.Body.String(), "nil map") || strings.Contains(rr.Body.String(), "goroutine") {
+		t.Errorf("Expected the panic not to be shown to the client. Instead, the body was %s", rr.Body.String())
+	}
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &entry); err != nil {
+		t.Fatalf("Expected the panic to be logged as JSON. Instead, got %q", buf.String())
+	}
+
+	if entry["request_id"] != "some-request-id" {
+		t.Errorf("Expected the panic to be logged with the request ID. Instead, got %v", entry["request_id"])
+	}
+
+	if !strings.Contains(entry["error"].(string), "nil map") {
+		t.Errorf("Expected the panic to be logged. Instead, got %v", entry["error"])
+	}
+
+	if !strings.Contains(entry["stack"].(string), "panicking") {
+		t.Errorf("Expected the stack to be logged. Instead, got %v", entry["stack"])
+	}
+}
+
+func TestRecoveryWithoutPanic(t *testing.T) {
+	rr := getLinkWithUserAgent(t, inMemoryConf(), "missing", facebookUserAgent)
+	expectStatus(t, rr, http.StatusNotFound)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// The pages rendered for the most recently requested links, so that scrapers retrying the same link don't
+// execute its template every time. Links only change through PUT and DELETE, or when an expired one is replaced,
+// which invalidate their pages.
+// A nil cache, as when the Config's RenderCacheSize is zero, caches nothing
+type renderCache struct {
+	size    int
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+// A link renders differently depending on the base URL and on whether the client gets redirected,
+// so every slug keeps the variants it was requested with
+type renderCacheEntry struct {
+	slug     string
+	variants map[string]*renderedPage
+}
+
+// Pages that show signed URLs expire before those do. Others, with a zero expiresAt, are kept until invalidated
+type renderedPage struct {
+	body      []byte
+	etag      string
+	expiresAt time.Time
+}
+
+func newRenderCache(size int) *renderCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &renderCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (cache *renderCache) get(slug, variant string) (*renderedPage, bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[slug]; ok {
+		if page, ok := element.Value.(*renderCacheEntry).variants[variant]; ok && (page.expiresAt.IsZero() || time.Now().Before(page.expiresAt)) {
+			cache.order.MoveToFront(element)
+			cache.hits++
+			return page, true
+		}
+	}
+
+	cache.misses++
+	return nil, false
+}
+
+// Adds a page, evicting the least recently used slug when the cache is full
+func (cache *renderCache) add(slug, variant string, page *renderedPage) {
+	if cache == nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[slug]; ok {
+		element.Value.(*renderCacheEntry).variants[variant] = page
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	entry := &renderCacheEntry{slug: slug, variants: map[string]*renderedPage{variant: page}}
+	cache.entries[slug] = cache.order.PushFront(entry)
+
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*renderCacheEntry).slug)
+	}
+}
+
+// Drops every page of a link, which must be done whenever it changes
+func (cache *renderCache) invalidate(slug string) {
+	if cache == nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, ok := cache.entries[slug]; ok {
+		cache.order.Remove(element)
+		delete(cache.entries, slug)
+	}
+}
+
+// How many times a page was found in the cache, and how many times it had to be rendered
+func (cache *renderCache) stats() (hits, misses uint64) {
+	if cache == nil {
+		return 0, 0
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return cache.hits, cache.misses
+}
+
+// The Config's cache, created on first use so that RenderCacheSize can be set until then
+func (c *Config) renderCache() *renderCache {
+	c.renderCacheOnce.Do(func() {
+		c.pages = newRenderCache(c.RenderCacheSize)
+	})
+
+	return c.pages
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/templates"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func expectCacheStats(t *testing.T, config *Config, hits, misses uint64) {
+	actualHits, actualMisses := config.renderCache().stats()
+	if actualHits != hits || actualMisses != misses {
+		t.Errorf("Expected %d hits and %d misses in the render cache. Instead, got %d and %d", hits, misses, actualHits, actualMisses)
+	}
+}
+
+func TestGetLinkFromTheRenderCache(t *testing.T) {
+	config := inMemoryConf()
+	config.RenderCacheSize = 10
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	first := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	expectStatus(t, first, http.StatusOK)
+	expectCacheStats(t, config, 0, 1)
+
+	second := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	expectStatus(t, second, http.StatusOK)
+	expectCacheStats(t, config, 1, 1)
+
+	if first.Body.String() != second.Body.String() || first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Error("Expected the cached page to be the same one that was rendered")
+	}
+}
+
+func TestRenderCacheExpiresPagesWithSignedImages(t *testing.T) {
+	config := inMemoryConf()
+	config.RenderCacheSize = 10
+	store := &presigningImageStore{Store: config.ImageStore, validFor: time.Nanosecond}
+	config.ImageStore = store
+
+	imageURL, _ := store.Put(context.Background(), "some-image", image.NewRGBA(image.Rect(0, 0, 8, 4)))
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", Image: imageURL}})
+
+	first := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	time.Sleep(time.Millisecond)
+	second := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	expectCacheStats(t, config, 0, 2)
+
+	if first.Body.String() == second.Body.String() {
+		t.Error("Expected the page to be rendered again, with a new signature, once the signed URLs are about to expire")
+	}
+
+	store.validFor = time.Hour
+	getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	expectCacheStats(t, config, 1, 3)
+}
+
+func TestRenderCacheKeepsRedirectsApart(t *testing.T) {
+	config := inMemoryConf()
+	config.RenderCacheSize = 10
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	rr := getLinkWithUserAgent(t, config, slug, "curl/7.54.0")
+
+	expectStatus(t, rr, http.StatusOK)
+	expectBodyToContain(t, rr, []string{`http-equiv="refresh"`})
+	expectCacheStats(t, config, 0, 2)
+}
+
+func TestRenderCacheInvalidatedOnPut(t *testing.T) {
+	config := inMemoryConf()
+	config.RenderCacheSize = 10
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "Old title", URL: "https://example.com/destination"}})
+
+	getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+
+	input := &postLinkInput{Link: links.Link{Values: templates.Values{Title: "New title", URL: "https://example.com/destination"}}}
+	expectStatus(t, putLinkRequest(t, config, slug, input), http.StatusOK)
+
+	rr := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	expectBodyToContain(t, rr, []string{"New title"})
+	expectCacheStats(t, config, 0, 2)
+}
+
+func TestRenderCacheInvalidatedOnDelete(t *testing.T) {
+	config := inMemoryConf()
+	config.RenderCacheSize = 10
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+
+	req, err := http.NewRequest("DELETE", "/links/"+slug, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+	expectStatus(t, rr, http.StatusNoContent)
+
+	if _, ok := config.renderCache().entries[slug]; ok {
+		t.Error("Expected the deleted link's page to be dropped from the cache")
+	}
+}
+
+func TestRenderCacheDisabled(t *testing.T) {
+	config := inMemoryConf()
+	slug := config.LinkStore.Create(&links.Link{Values: templates.Values{Title: "some-title", URL: "https://example.com/destination"}})
+
+	getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+	rr := getLinkWithUserAgent(t, config, slug, facebookUserAgent)
+
+	expectStatus(t, rr, http.StatusOK)
+	if config.renderCache() != nil {
+		t.Error("Expected no render cache when its size is zero")
+	}
+	expectCacheStats(t, config, 0, 0)
+}
+
+func TestRenderCacheEvictsTheLeastRecentlyUsed(t *testing.T) {
+	cache := newRenderCache(2)
+	page := &renderedPage{body: []byte("some-page")}
+
+	cache.add("first", "", page)
+	cache.add("second", "", page)
+	cache.get("first", "")
+	cache.add("third", "", page)
+
+	if _, ok := cache.get("second", ""); ok {
+		t.Error("Expected the least recently used slug to be evicted")
+	}
+
+	for _, slug := range []string{"first", "third"} {
+		if _, ok := cache.get(slug, ""); !ok {
+			t.Errorf("Expected %s to still be cached", slug)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"github.com/devlucky/fakelink/src/logs"
+	"github.com/julienschmidt/httprouter"
+	"github.com/satori/go.uuid"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// Request IDs coming from a proxy are kept as long as they are safe to log and echo back
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Tags every request with an ID, returned in the X-Request-ID header, and logs it once handled.
+// An ID set by a proxy in the same header is kept, so that its logs and ours can be correlated
+func withRequestLog(next handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewV4().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r, ps, c)
+
+		fields := logs.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     recorder.statusCode(),
+			"latency_ms": float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+		}
+		if recorder.err != nil {
+			requestLogger(r, c).Error("Request failed", recorder.err, fields)
+			return
+		}
+		requestLogger(r, c).Info("Request handled", fields)
+	}
+}
+
+// The logger to use while handling a request, which tags every entry with the request's ID
+func requestLogger(r *http.Request, c *Config) *logs.Logger {
+	if id, ok := r.Context().Value(requestIDKey).(string); ok {
+		return c.Logger.With(logs.Fields{"request_id": id})
+	}
+
+	return c.Logger
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/devlucky/fakelink/src/logs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func loggedRequest(t *testing.T, method, path, requestID string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	buf := &bytes.Buffer{}
+	config := inMemoryConf()
+	config.Logger = logs.New(buf)
+
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(config).ServeHTTP(rr, req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+		t.Fatalf("Expected the request to be logged as JSON. Instead, got %q", buf.String())
+	}
+
+	return rr, entry
+}
+
+func TestRequestLog(t *testing.T) {
+	rr, entry := loggedRequest(t, "GET", "/links/missing", "")
+
+	id := rr.Header().Get("X-Request-ID")
+	if id == "" {
+		t.Fatal("Expected the response to carry a request ID")
+	}
+
+	if entry["request_id"] != id || entry["method"] != "GET" || entry["path"] != "/links/missing" || entry["status"] != float64(http.StatusNotFound) {
+		t.Errorf("Unexpected log entry %v", entry)
+	}
+	if _, ok := entry["latency_ms"].(float64); !ok {
+		t.Errorf("Expected the latency to be logged. Instead, got %v", entry)
+	}
+}
+
+func TestRequestLogKeepsTheProxyRequestID(t *testing.T) {
+	rr, entry := loggedRequest(t, "GET", "/random", "proxy-id-123")
+
+	expectHeaderToContain(t, rr, "X-Request-ID", []string{"proxy-id-123"})
+	if entry["request_id"] != "proxy-id-123" {
+		t.Errorf("Expected the proxy's request ID to be logged. Instead, got %v", entry)
+	}
+}
+
+func TestRequestLogReplacesUnsafeRequestIDs(t *testing.T) {
+	rr, _ := loggedRequest(t, "GET", "/random", "some id\" with quotes")
+
+	if id := rr.Header().Get("X-Request-ID"); id == "" || strings.Contains(id, "\"") {
+		t.Errorf("Expected an unsafe request ID to be replaced. Instead, got %q", id)
+	}
+}
+
+func TestRequestLogIncludesErrors(t *testing.T) {
+	_, entry := loggedRequest(t, "GET", "/links?limit=nope", "")
+
+	if entry["level"] != "error" || entry["status"] != float64(http.StatusBadRequest) || entry["error"] == nil {
+		t.Errorf("Expected the failed request to be logged with its error. Instead, got %v", entry)
+	}
+}
@@ -4,18 +4,42 @@ import (
 	"fmt"
 	"github.com/devlucky/fakelink/src/images"
 	"github.com/devlucky/fakelink/src/links"
+	"github.com/devlucky/fakelink/src/logs"
 	"github.com/devlucky/fakelink/src/templates"
 	"github.com/julienschmidt/httprouter"
+	"io/ioutil"
+	"net/http"
 	"os"
 )
 
 // NewRouter creates the router for the main API.
 func NewRouter(config *Config) *httprouter.Router {
+	limitPosts := rateLimit(config.PostRateLimit, config.PostRateBurst)
+	idempotent := idempotency(config.IdempotencyTTL)
+	stats := newMetrics()
+	deleteEvictedImages(config)
+
 	router := httprouter.New()
-	router.OPTIONS("/*path", injectConfig(config, cors))
-	router.GET("/random", injectConfig(config, getRandom))
-	router.GET("/links/:slug", injectConfig(config, getLink))
-	router.POST("/links", injectConfig(config, postLink))
+	router.OPTIONS("/*path", injectConfig(config, chain(cors, withRecovery, withRequestLog, stats.measure("/*path"), withCORS, withGzip)))
+	router.GET("/random", injectConfig(config, chain(getRandom, withRecovery, withRequestLog, stats.measure("/random"), withCORS, withGzip)))
+	router.GET("/links", injectConfig(config, chain(listLinks, withRecovery, withRequestLog, stats.measure("/links"), withCORS, withGzip)))
+	router.GET("/links/:slug", injectConfig(config, chain(getLink, withRecovery, withRequestLog, stats.measure("/links/:slug"), withCORS, withGzip, stats.countScrapers)))
+	router.HEAD("/links/:slug", injectConfig(config, chain(getLink, withRecovery, withRequestLog, stats.measure("/links/:slug"), withCORS, withHead, withGzip)))
+	router.GET("/links/:slug/stats", injectConfig(config, chain(getLinkStats, withRecovery, withRequestLog, stats.measure("/links/:slug/stats"), withCORS, withGzip, requireAPIKey)))
+	router.GET("/links/:slug/image", injectConfig(config, chain(getLinkImage, withRecovery, withRequestLog, stats.measure("/links/:slug/image"), withCORS, withGzip)))
+	router.POST("/links", injectConfig(config, chain(postLink, withRecovery, withRequestLog, stats.measure("/links"), withCORS, withGzip, limitPosts, requireAPIKey, idempotent, limitBody)))
+	router.POST("/links/bulk", injectConfig(config, chain(postBulkLinks, withRecovery, withRequestLog, stats.measure("/links/bulk"), withCORS, withGzip, limitPosts, requireAPIKey, limitBody)))
+	router.PUT("/links/:slug", injectConfig(config, chain(putLink, withRecovery, withRequestLog, stats.measure("/links/:slug"), withCORS, withGzip, requireAPIKey, limitBody)))
+	router.DELETE("/links/:slug", injectConfig(config, chain(deleteLink, withRecovery, withRequestLog, stats.measure("/links/:slug"), withCORS, withGzip, requireAPIKey)))
+	router.POST("/admin/gc", injectConfig(config, chain(collectImages, withRecovery, withRequestLog, stats.measure("/admin/gc"), withCORS, withGzip, requireAPIKey)))
+	router.GET("/images/:key", injectConfig(config, chain(getImage, withRecovery, withRequestLog, stats.measure("/images/:key"), withCORS, withGzip)))
+	router.HEAD("/images/:key", injectConfig(config, chain(getImage, withRecovery, withRequestLog, stats.measure("/images/:key"), withCORS, withHead, withGzip)))
+	router.GET("/oembed", injectConfig(config, chain(oEmbed, withRecovery, withRequestLog, stats.measure("/oembed"), withCORS, withGzip)))
+	router.GET("/healthz", injectConfig(config, chain(healthz, withRecovery, withRequestLog, withCORS, withGzip)))
+	router.GET("/metrics", injectConfig(config, chain(stats.serve, withRecovery)))
+
+	router.NotFound = fallbackHandler(config, chain(routingError(http.StatusNotFound, "not found"), withRecovery, withRequestLog, withCORS))
+	router.MethodNotAllowed = fallbackHandler(config, chain(routingError(http.StatusMethodNotAllowed, "method not allowed"), withRecovery, withRequestLog, withCORS))
 
 	return router
 }
@@ -24,10 +48,14 @@ func inMemoryConf() *Config {
 	return &Config{
 		RootPath:       fmt.Sprintf("%s/src/github.com/devlucky/fakelink", os.Getenv("GOPATH")),
 		DebugMode:      true,
-		Template:       templates.Get(),
+		Templates:      templates.NewRegistry(),
 		LinkStore:      links.NewInMemoryStore(),
 		ImageStore:     images.NewInMemoryStore(),
 		ImageMaxWidth:  64,
 		ImageMaxHeight: 64,
+		ImageMaxBytes:  1 << 20,
+		SlugLength:     links.DefaultSlugLength,
+		ReservedSlugs:  DefaultReservedSlugs,
+		Logger:         logs.New(ioutil.Discard),
 	}
 }
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+type routingErrorOutput struct {
+	Error string `json:"error"`
+}
+
+// Answers the requests the router has no route for with a JSON error, as the endpoints do
+func routingError(status int, message string) handler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c *Config) {
+		jsonResp, err := json.Marshal(&routingErrorOutput{Error: message})
+		if err != nil {
+			jsonResp = []byte("{}")
+		}
+
+		response(w, status, jsonResp)
+	}
+}
+
+// Turns a handler into one the router can fall back to, which gets no params
+func fallbackHandler(c *Config, h handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, nil, c)
+	})
+}
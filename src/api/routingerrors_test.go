@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUnknownRoute(t *testing.T) {
+	req, err := http.NewRequest("GET", "/unknown/path", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusNotFound)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"application/json"})
+	if body := rr.Body.String(); body != `{"error":"not found"}` {
+		t.Errorf(`Expected the body to be {"error":"not found"}. Instead, it was %s`, body)
+	}
+}
+
+func TestWrongMethod(t *testing.T) {
+	req, err := http.NewRequest("DELETE", "/links", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewRouter(inMemoryConf()).ServeHTTP(rr, req)
+
+	expectStatus(t, rr, http.StatusMethodNotAllowed)
+	expectHeaderToContain(t, rr, "Content-Type", []string{"application/json"})
+	expectHeaderToContain(t, rr, "Allow", []string{"POST"})
+	if body := rr.Body.String(); body != `{"error":"method not allowed"}` {
+		t.Errorf(`Expected the body to be {"error":"method not allowed"}. Instead, it was %s`, body)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/devlucky/fakelink/src/logs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownGracePeriod is how long in-flight requests are given to finish when the server is stopped,
+// if the Config does not say.
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// ListenAndServe serves the API on the given address until the process gets a SIGINT or SIGTERM. The server then
+// stops accepting connections and waits for the in-flight requests to finish, for up to the ShutdownGracePeriod.
+// It only returns an error when serving fails, or when requests had to be cut off.
+func ListenAndServe(addr string, c *Config) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stopCollecting := collectImagesEvery(c.ImageGCInterval, c)
+	defer stopCollecting()
+
+	c.Logger.Info("Listening", logs.Fields{"addr": addr})
+	return serve(listener, NewRouter(c), c, signals)
+}
+
+func serve(listener net.Listener, handler http.Handler, c *Config, stop <-chan os.Signal) error {
+	server := &http.Server{Handler: handler}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	var sig os.Signal
+	select {
+	case err := <-served:
+		return err
+	case sig = <-stop:
+	}
+
+	gracePeriod := c.ShutdownGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultShutdownGracePeriod
+	}
+	c.Logger.Info("Shutting down", logs.Fields{"signal": sig.String(), "grace_period_s": gracePeriod.Seconds()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("In-flight requests did not finish within %s: %s", gracePeriod, err)
+	}
+
+	c.Logger.Info("The server stopped", nil)
+	return nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Serves a handler that takes the given time to answer, returning the address it listens on
+// along with the channel that stops it and the one serve's result comes through
+func serveSlowly(t *testing.T, c *Config, delay time.Duration) (string, chan os.Signal, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error listening: %s", err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(listener, handler, c, stop)
+	}()
+
+	return "http://" + listener.Addr().String(), stop, served
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	url, stop, served := serveSlowly(t, inMemoryConf(), 200*time.Millisecond)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Errorf("Expected the in-flight request to be answered. Instead, it failed with %s", err)
+		}
+		responses <- resp
+	}()
+
+	// Give the request time to reach the handler before stopping
+	time.Sleep(50 * time.Millisecond)
+	stop <- syscall.SIGTERM
+
+	if resp := <-responses; resp != nil && resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected the in-flight request to complete. Instead, its status was %d", resp.StatusCode)
+	}
+
+	if err := <-served; err != nil {
+		t.Errorf("Expected the server to stop cleanly. Instead, got %s", err)
+	}
+
+	if _, err := http.Get(url); err == nil {
+		t.Error("Expected the server to stop accepting connections")
+	}
+}
+
+func TestServeCutsOffRequestsAfterTheGracePeriod(t *testing.T) {
+	config := inMemoryConf()
+	config.ShutdownGracePeriod = 50 * time.Millisecond
+	url, stop, served := serveSlowly(t, config, time.Second)
+
+	go http.Get(url)
+	time.Sleep(50 * time.Millisecond)
+	stop <- syscall.SIGTERM
+
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Error("Expected cutting off in-flight requests to be reported")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Expected the server to stop once the grace period was over")
+	}
+}
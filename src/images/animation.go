@@ -0,0 +1,83 @@
+package images
+
+import (
+	"github.com/disintegration/imaging"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+// Animation is an animated GIF. Wherever a still image is expected it behaves as its first frame,
+// but the stores keep and serve every one of its frames.
+type Animation struct {
+	GIF *gif.GIF
+}
+
+// ColorModel returns the color model of the first frame.
+func (anim *Animation) ColorModel() color.Model {
+	return anim.GIF.Image[0].ColorModel()
+}
+
+// Bounds returns the dimensions of the whole animation, which every frame is drawn within.
+func (anim *Animation) Bounds() image.Rectangle {
+	if anim.GIF.Config.Width == 0 || anim.GIF.Config.Height == 0 {
+		return anim.GIF.Image[0].Bounds()
+	}
+
+	return image.Rect(0, 0, anim.GIF.Config.Width, anim.GIF.Config.Height)
+}
+
+// At returns the color of a pixel of the first frame.
+func (anim *Animation) At(x, y int) color.Color {
+	return anim.GIF.Image[0].At(x, y)
+}
+
+// Scales every frame down by the same ratio, so that the animation fits the given dimensions
+func (anim *Animation) fit(maxWidth, maxHeight int) *Animation {
+	bounds := anim.Bounds()
+	ratio := anim.fitRatio(maxWidth, maxHeight)
+	if ratio >= 1 {
+		return anim
+	}
+
+	scale := func(v int) int {
+		return scaleBy(v, ratio)
+	}
+
+	fitted := *anim.GIF
+	fitted.Image = make([]*image.Paletted, len(anim.GIF.Image))
+	fitted.Config.Width, fitted.Config.Height = scale(bounds.Dx()), scale(bounds.Dy())
+
+	for i, frame := range anim.GIF.Image {
+		frameBounds := frame.Bounds()
+		scaled := image.Rect(scale(frameBounds.Min.X), scale(frameBounds.Min.Y), scale(frameBounds.Max.X), scale(frameBounds.Max.Y))
+		if scaled.Empty() {
+			scaled.Max = scaled.Min.Add(image.Pt(1, 1))
+		}
+
+		resized := imaging.Resize(frame, scaled.Dx(), scaled.Dy(), imaging.Lanczos)
+		paletted := image.NewPaletted(scaled, frame.Palette)
+		draw.Draw(paletted, scaled, resized, image.Point{}, draw.Src)
+		fitted.Image[i] = paletted
+	}
+
+	return &Animation{GIF: &fitted}
+}
+
+// How much the animation has to be scaled by to fit the given dimensions, 1 or more when it already does
+func (anim *Animation) fitRatio(maxWidth, maxHeight int) float64 {
+	bounds := anim.Bounds()
+	return minFloat(float64(maxWidth)/float64(bounds.Dx()), float64(maxHeight)/float64(bounds.Dy()))
+}
+
+func scaleBy(v int, ratio float64) int {
+	return int(float64(v)*ratio + 0.5)
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,61 @@
+package images
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// ErrInvalidDataURI is returned when a data: URI is not a base64 encoded image.
+var ErrInvalidDataURI = errors.New("The data URI is not a base64 encoded image")
+
+// IsDataURI tells whether an image URL embeds the image itself, as in data:image/png;base64,...
+func IsDataURI(uri string) bool {
+	return strings.HasPrefix(strings.ToLower(uri), "data:")
+}
+
+// DecodeDataURI decodes the image embedded in a base64 data: URI. Its declared MIME type must be the one of
+// the decoded image, and the decoded image can't exceed maxBytes, or ErrImageTooLarge is returned. As with
+// uploads, the image's metadata is stripped before decoding it.
+func DecodeDataURI(uri string, maxBytes int64) (image.Image, error) {
+	comma := strings.Index(uri, ",")
+	if !IsDataURI(uri) || comma < 0 {
+		return nil, ErrInvalidDataURI
+	}
+
+	params := strings.Split(strings.ToLower(uri[len("data:"):comma]), ";")
+	if len(params) < 2 || params[len(params)-1] != "base64" {
+		return nil, ErrInvalidDataURI
+	}
+
+	mediaType := strings.TrimSpace(params[0])
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("%s: %q is not an image type", ErrInvalidDataURI, mediaType)
+	}
+
+	// Padding is optional for some encoders, and long payloads may be wrapped over several lines
+	payload := strings.Join(strings.Fields(uri[comma+1:]), "")
+	payload = strings.TrimRight(payload, "=")
+	if maxBytes > 0 && int64(base64.RawStdEncoding.DecodedLen(len(payload))) > maxBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidDataURI
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || !supportedFormats[format] {
+		return nil, ErrUnsupportedImageFormat
+	}
+
+	if declared := strings.TrimPrefix(mediaType, "image/"); declared != format && !(declared == "jpg" && format == "jpeg") {
+		return nil, fmt.Errorf("%s: it is declared as %s, but it is a %s image", ErrInvalidDataURI, mediaType, format)
+	}
+
+	return Decode(bytes.NewReader(StripEXIF(data)))
+}
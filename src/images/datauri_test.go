@@ -0,0 +1,87 @@
+package images
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func dataURI(mediaType string, data []byte) string {
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+func encodedPNG(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, generateRandomImageWithSize(8, 4)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodedJPEG(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, generateRandomImageWithSize(8, 4), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeDataURI(t *testing.T) {
+	for _, uri := range []string{
+		dataURI("image/png", encodedPNG(t)),
+		dataURI("image/jpeg", encodedJPEG(t)),
+		dataURI("image/jpg", encodedJPEG(t)),
+		strings.TrimRight(dataURI("IMAGE/PNG", encodedPNG(t)), "="),
+	} {
+		img, err := DecodeDataURI(uri, 1<<20)
+		if err != nil {
+			t.Errorf("Unexpected error decoding %.30s: %s", uri, err)
+			continue
+		}
+
+		if !img.Bounds().Eq(image.Rect(0, 0, 8, 4)) {
+			t.Errorf("Expected the embedded image to be decoded. Instead, got bounds %v", img.Bounds())
+		}
+	}
+}
+
+func TestDecodeMalformedDataURI(t *testing.T) {
+	for _, uri := range []string{
+		"https://example.com/image.png",
+		"data:image/png;base64",
+		"data:image/png," + string(encodedPNG(t)),
+		"data:image/png;base64,not base64!",
+		"data:text/plain;base64,aGVsbG8=",
+	} {
+		if _, err := DecodeDataURI(uri, 1<<20); err == nil || !strings.HasPrefix(err.Error(), ErrInvalidDataURI.Error()) {
+			t.Errorf("Expected %.40q to be an invalid data URI. Instead, got %v", uri, err)
+		}
+	}
+}
+
+func TestDecodeDataURIWithWrongType(t *testing.T) {
+	_, err := DecodeDataURI(dataURI("image/png", encodedJPEG(t)), 1<<20)
+	if err == nil || !strings.Contains(err.Error(), "declared as image/png, but it is a jpeg image") {
+		t.Errorf("Expected the declared type to be checked. Instead, got %v", err)
+	}
+}
+
+func TestDecodeDataURINotAnImage(t *testing.T) {
+	_, err := DecodeDataURI(dataURI("image/png", []byte("<svg></svg>")), 1<<20)
+	if err != ErrUnsupportedImageFormat {
+		t.Errorf("Expected an unsupported format error. Instead, got %v", err)
+	}
+}
+
+func TestDecodeOversizedDataURI(t *testing.T) {
+	data := encodedPNG(t)
+
+	_, err := DecodeDataURI(dataURI("image/png", data), int64(len(data)-1))
+	if err != ErrImageTooLarge {
+		t.Errorf("Expected a too large error. Instead, got %v", err)
+	}
+}
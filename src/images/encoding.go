@@ -0,0 +1,207 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+)
+
+// Format is the encoding the stores use when persisting an image.
+type Format string
+
+const (
+	// JPEG encodes images as lossy JPEGs. Transparency is lost.
+	JPEG Format = "jpeg"
+	// PNG encodes images as lossless PNGs, preserving transparency.
+	PNG Format = "png"
+	// GIF is the format animations are always kept in, regardless of the configured one.
+	GIF Format = "gif"
+)
+
+// FormatOf returns the format an image is persisted with: GIF for animations, the configured one otherwise.
+func (opts Options) FormatOf(img image.Image) Format {
+	if _, ok := img.(*Animation); ok {
+		return GIF
+	}
+
+	return opts.Format
+}
+
+// ContentType returns the MIME type of the format.
+func (format Format) ContentType() string {
+	return "image/" + string(format)
+}
+
+// ParseFormat returns the format with the given name: jpeg (or jpg), png or gif.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case JPEG, "jpg":
+		return JPEG, nil
+	case PNG, GIF:
+		return Format(name), nil
+	}
+
+	return "", fmt.Errorf("Unsupported image format %q, which must be jpeg, png or gif", name)
+}
+
+// Encode writes an image in the given format, at its own size. Animations keep every frame as GIFs, and are
+// reduced to their first one in other formats.
+func Encode(w io.Writer, img image.Image, format Format) error {
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, nil)
+	case PNG:
+		return png.Encode(w, img)
+	case GIF:
+		if anim, ok := img.(*Animation); ok {
+			return gif.EncodeAll(w, anim.GIF)
+		}
+		return gif.Encode(w, img, nil)
+	}
+
+	return fmt.Errorf("Unsupported image format %q", format)
+}
+
+// Options describes how the stores that persist raw bytes encode their images.
+type Options struct {
+	Format Format
+	// JPEG quality, ranging from 1 to 100. When zero, jpeg.DefaultQuality is used
+	Quality int
+	// Images exceeding these dimensions are downscaled preserving their aspect ratio. Zero means unbounded
+	MaxWidth  int
+	MaxHeight int
+}
+
+func (opts Options) validate() error {
+	if opts.Format != JPEG && opts.Format != PNG {
+		return fmt.Errorf("Unsupported image format %q", opts.Format)
+	}
+
+	if opts.Quality < 0 || opts.Quality > 100 {
+		return fmt.Errorf("JPEG quality must range from 1 to 100. Instead, it was %d", opts.Quality)
+	}
+
+	if opts.MaxWidth < 0 || opts.MaxHeight < 0 {
+		return fmt.Errorf("Maximum image dimensions cannot be negative")
+	}
+
+	return nil
+}
+
+func (opts Options) encode(w io.Writer, img image.Image) error {
+	if anim, ok := img.(*Animation); ok {
+		return gif.EncodeAll(w, opts.fitAnimation(anim).GIF)
+	}
+
+	img = opts.fit(img)
+
+	switch opts.Format {
+	case JPEG:
+		quality := opts.Quality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case PNG:
+		return png.Encode(w, img)
+	}
+
+	return fmt.Errorf("Unsupported image format %q", opts.Format)
+}
+
+// Downscales an image to the maximum dimensions. Images within them are returned untouched
+func (opts Options) fit(img image.Image) image.Image {
+	width, height := opts.fittedSize(img)
+	if bounds := img.Bounds(); width == bounds.Dx() && height == bounds.Dy() {
+		return img
+	}
+
+	return imaging.Resize(img, width, height, imaging.Lanczos)
+}
+
+// Sizes images the way imaging.Fit does, without actually resizing them
+func (opts Options) fittedSize(img image.Image) (width, height int) {
+	bounds := img.Bounds()
+	width, height = bounds.Dx(), bounds.Dy()
+	maxWidth, maxHeight := opts.maxDimensions(bounds)
+
+	if width <= maxWidth && height <= maxHeight {
+		return
+	}
+
+	aspectRatio := float64(width) / float64(height)
+	if aspectRatio > float64(maxWidth)/float64(maxHeight) {
+		return maxWidth, int(float64(maxWidth) / aspectRatio)
+	}
+	return int(float64(maxHeight) * aspectRatio), maxHeight
+}
+
+// The dimensions an image is persisted with, once downscaled to the maximum ones
+func (opts Options) storedSize(img image.Image) (width, height int) {
+	anim, ok := img.(*Animation)
+	if !ok {
+		return opts.fittedSize(img)
+	}
+
+	bounds := anim.Bounds()
+	width, height = bounds.Dx(), bounds.Dy()
+	ratio := anim.fitRatio(opts.maxDimensions(bounds))
+	if ratio >= 1 {
+		return
+	}
+	return scaleBy(width, ratio), scaleBy(height, ratio)
+}
+
+func (opts Options) fitAnimation(anim *Animation) *Animation {
+	return anim.fit(opts.maxDimensions(anim.Bounds()))
+}
+
+// The maximum dimensions, with the unbounded ones set to the image's own
+func (opts Options) maxDimensions(bounds image.Rectangle) (maxWidth, maxHeight int) {
+	maxWidth, maxHeight = opts.MaxWidth, opts.MaxHeight
+	if maxWidth == 0 {
+		maxWidth = bounds.Dx()
+	}
+	if maxHeight == 0 {
+		maxHeight = bounds.Dy()
+	}
+	return
+}
+
+// ErrUnsupportedImageFormat is returned when decoding anything but a JPEG, PNG, GIF or WebP image, e.g. an SVG or an HTML page.
+var ErrUnsupportedImageFormat = errors.New("The image is not a JPEG, PNG, GIF or WebP")
+
+// Raster formats we accept, as named by the image package. Others may be registered by our dependencies
+var supportedFormats = map[string]bool{"jpeg": true, "png": true, "gif": true, "webp": true}
+
+// Decode decodes a JPEG, PNG, GIF or WebP image regardless of its format, by sniffing its magic bytes.
+// Anything else fails with ErrUnsupportedImageFormat. GIFs with more than one frame are decoded as an Animation, keeping all of them.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err == image.ErrFormat || err == nil && !supportedFormats[format] {
+		return nil, ErrUnsupportedImageFormat
+	}
+	if err != nil || format != "gif" {
+		return img, err
+	}
+
+	all, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(all.Image) < 2 {
+		return img, nil
+	}
+
+	return &Animation{GIF: all}, nil
+}
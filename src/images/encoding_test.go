@@ -0,0 +1,278 @@
+package images
+
+import (
+	"bytes"
+	"github.com/disintegration/imaging"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestEncodeAndDecode(t *testing.T) {
+	for _, format := range []Format{JPEG, PNG} {
+		img := generateRandomImage()
+
+		buf := new(bytes.Buffer)
+		if err := (Options{Format: format}).encode(buf, img); err != nil {
+			t.Fatalf("Unexpected error encoding an image as %s: %s", format, err)
+		}
+
+		decoded, err := Decode(buf)
+		if err != nil {
+			t.Fatalf("Unexpected error decoding an image encoded as %s: %s", format, err)
+		}
+
+		if !imagesAreEqual(img, decoded) {
+			t.Errorf("Expected images encoded as %s to be decoded back", format)
+		}
+	}
+}
+
+func TestEncodeUnsupportedFormat(t *testing.T) {
+	if err := (Options{Format: "bmp"}).encode(new(bytes.Buffer), generateRandomImage()); err == nil {
+		t.Error("Expected encoding with an unsupported format to fail")
+	}
+}
+
+func TestPNGPreservesTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.NRGBA{255, 0, 0, 0})
+
+	buf := new(bytes.Buffer)
+	if err := (Options{Format: PNG}).encode(buf, img); err != nil {
+		t.Fatalf("Unexpected error encoding an image as PNG: %s", err)
+	}
+
+	decoded, err := Decode(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding a PNG: %s", err)
+	}
+
+	if _, _, _, a := decoded.At(1, 1).RGBA(); a != 0 {
+		t.Errorf("Expected transparent pixels to remain transparent. Instead, alpha was %d", a)
+	}
+}
+
+func TestFormatContentType(t *testing.T) {
+	if JPEG.ContentType() != "image/jpeg" || PNG.ContentType() != "image/png" {
+		t.Error("Expected formats to map to their MIME types")
+	}
+}
+
+func TestFitDownscalesLargeImages(t *testing.T) {
+	opts := Options{MaxWidth: 100, MaxHeight: 100}
+	bounds := opts.fit(generateRandomImageWithSize(400, 200)).Bounds()
+
+	if bounds.Dx() != 100 || bounds.Dy() != 50 {
+		t.Errorf("Expected a 400x200 image to be downscaled to 100x50. Instead, it was %dx%d", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestFitKeepsSmallImagesUntouched(t *testing.T) {
+	img := generateRandomImageWithSize(40, 20)
+
+	if (Options{MaxWidth: 100, MaxHeight: 100}).fit(img) != image.Image(img) {
+		t.Error("Expected images within the maximum dimensions to pass through untouched")
+	}
+
+	if (Options{}).fit(img) != image.Image(img) {
+		t.Error("Expected images to pass through untouched when there are no maximum dimensions")
+	}
+}
+
+func TestFitWithASingleBound(t *testing.T) {
+	bounds := (Options{MaxWidth: 100}).fit(generateRandomImageWithSize(400, 200)).Bounds()
+
+	if bounds.Dx() != 100 || bounds.Dy() != 50 {
+		t.Errorf("Expected a 400x200 image to be downscaled to 100x50. Instead, it was %dx%d", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestJPEGQuality(t *testing.T) {
+	img := getFixtureImage("sharknado.jpg")
+
+	low, high := new(bytes.Buffer), new(bytes.Buffer)
+	if err := (Options{Format: JPEG, Quality: 50}).encode(low, img); err != nil {
+		t.Fatalf("Unexpected error encoding an image: %s", err)
+	}
+	if err := (Options{Format: JPEG, Quality: 95}).encode(high, img); err != nil {
+		t.Fatalf("Unexpected error encoding an image: %s", err)
+	}
+
+	if low.Len() >= high.Len() {
+		t.Errorf("Expected a quality of 95 to take more bytes than 50. Instead, they took %d and %d", high.Len(), low.Len())
+	}
+}
+
+func TestOptionsValidation(t *testing.T) {
+	valid := []Options{
+		{Format: JPEG},
+		{Format: JPEG, Quality: 1},
+		{Format: JPEG, Quality: 100},
+		{Format: PNG, MaxWidth: 1200, MaxHeight: 630},
+	}
+	for _, opts := range valid {
+		if err := opts.validate(); err != nil {
+			t.Errorf("Expected %+v to be valid. Instead, got %s", opts, err)
+		}
+	}
+
+	invalid := []Options{
+		{},
+		{Format: "gif"},
+		{Format: JPEG, Quality: -1},
+		{Format: JPEG, Quality: 101},
+		{Format: JPEG, MaxWidth: -1},
+	}
+	for _, opts := range invalid {
+		if err := opts.validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", opts)
+		}
+	}
+}
+
+func TestAnimationsAreEncodedAsGIFs(t *testing.T) {
+	opts := Options{Format: JPEG}
+	anim := generateAnimation(16, 8, 3)
+
+	if opts.FormatOf(anim) != GIF || opts.FormatOf(generateRandomImage()) != JPEG {
+		t.Error("Expected animations, and only them, to be stored as GIFs")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := opts.encode(buf, anim); err != nil {
+		t.Fatalf("Unexpected error encoding an animation: %s", err)
+	}
+
+	decoded, err := gif.DecodeAll(buf)
+	if err != nil {
+		t.Fatalf("Expected the animation to be encoded as a GIF. Instead, decoding failed with %s", err)
+	}
+	if len(decoded.Image) != 3 {
+		t.Errorf("Expected all 3 frames to be encoded. Instead, got %d", len(decoded.Image))
+	}
+}
+
+func TestDecodeStillGIF(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, generateAnimation(16, 8, 1).GIF); err != nil {
+		t.Fatalf("Unexpected error encoding a GIF: %s", err)
+	}
+
+	img, err := Decode(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding a GIF: %s", err)
+	}
+	if _, ok := img.(*Animation); ok {
+		t.Error("Expected a single frame GIF to be decoded as a still image")
+	}
+}
+
+func TestThumbnailKeepsEveryFrame(t *testing.T) {
+	img := Thumbnail(generateAnimation(40, 20, 3), 10, 10)
+
+	anim, ok := img.(*Animation)
+	if !ok {
+		t.Fatalf("Expected the thumbnail of an animation to be animated. Instead, got a %T", img)
+	}
+
+	if len(anim.GIF.Image) != 3 {
+		t.Errorf("Expected all 3 frames to be kept. Instead, got %d", len(anim.GIF.Image))
+	}
+
+	if anim.Bounds().Dx() != 10 || anim.Bounds().Dy() != 5 {
+		t.Errorf("Expected the animation to be downscaled to 10x5. Instead, it was %v", anim.Bounds())
+	}
+
+	for i, frame := range anim.GIF.Image {
+		if frame.Bounds().Dx() != 10 || frame.Bounds().Dy() != 5 {
+			t.Errorf("Expected frame %d to be downscaled along with the animation. Instead, it was %v", i, frame.Bounds())
+		}
+	}
+}
+
+func TestDecodeWebP(t *testing.T) {
+	for _, name := range []string{"video.webp", "gopher.webp"} {
+		img := getFixtureImage(name)
+		if img.Bounds().Empty() {
+			t.Errorf("Expected %s to be decoded. Instead, it was empty", name)
+		}
+
+		// WebPs are stored transcoded into the configured format
+		buf := new(bytes.Buffer)
+		if err := (Options{Format: JPEG}).encode(buf, img); err != nil {
+			t.Fatalf("Unexpected error encoding %s as a JPEG: %s", name, err)
+		}
+
+		decoded, err := Decode(buf)
+		if err != nil {
+			t.Fatalf("Unexpected error decoding the transcoded %s: %s", name, err)
+		}
+
+		if !imagesAreEqual(img, decoded) {
+			t.Errorf("Expected the transcoded %s to keep its dimensions", name)
+		}
+	}
+}
+
+func TestDecodeUnsupportedFormats(t *testing.T) {
+	bmp := new(bytes.Buffer)
+	if err := imaging.Encode(bmp, generateRandomImage(), imaging.BMP); err != nil {
+		t.Fatalf("Unexpected error encoding a BMP: %s", err)
+	}
+
+	inputs := map[string][]byte{
+		"SVG":  []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`),
+		"HTML": []byte("<!DOCTYPE html><html><body>502 Bad Gateway</body></html>"),
+		"BMP":  bmp.Bytes(),
+	}
+
+	for name, data := range inputs {
+		if _, err := Decode(bytes.NewReader(data)); err != ErrUnsupportedImageFormat {
+			t.Errorf("Expected decoding %s to fail with ErrUnsupportedImageFormat. Instead, got %v", name, err)
+		}
+	}
+}
+
+func TestDecodeTruncatedJPEG(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := (Options{Format: JPEG}).encode(buf, generateRandomImage()); err != nil {
+		t.Fatalf("Unexpected error encoding a JPEG: %s", err)
+	}
+
+	_, err := Decode(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+	if err == nil || err == ErrUnsupportedImageFormat {
+		t.Errorf("Expected decoding a truncated JPEG to fail as a corrupt JPEG. Instead, got %v", err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, expected := range map[string]Format{"jpeg": JPEG, "jpg": JPEG, "png": PNG, "gif": GIF} {
+		if format, err := ParseFormat(name); err != nil || format != expected {
+			t.Errorf("Expected %q to be parsed as %s. Instead, got %q, %v", name, expected, format, err)
+		}
+	}
+
+	for _, name := range []string{"", "webp", "bmp", "PNG"} {
+		if _, err := ParseFormat(name); err == nil {
+			t.Errorf("Expected %q not to be a supported format", name)
+		}
+	}
+}
+
+func TestEncodeInEveryFormat(t *testing.T) {
+	img := generateRandomImage()
+
+	for _, format := range []Format{JPEG, PNG, GIF} {
+		buf := new(bytes.Buffer)
+		if err := Encode(buf, img, format); err != nil {
+			t.Fatalf("Unexpected error encoding an image as %s: %s", format, err)
+		}
+
+		_, name, err := image.DecodeConfig(buf)
+		if err != nil || name != string(format) {
+			t.Errorf("Expected the image to be encoded as %s. Instead, got %q, %v", format, name, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package images
+
+import (
+	"bytes"
+)
+
+// JPEG markers, as described in https://www.w3.org/Graphics/JPEG/itu-t81.pdf
+const (
+	jpegMarkerPrefix = 0xff
+	jpegSOI          = 0xd8 // Start of image
+	jpegSOS          = 0xda // Start of scan, after which the compressed data follows
+	jpegAPP1         = 0xe1 // Where both EXIF and XMP metadata live
+	jpegRST0         = 0xd0 // Restart markers, like the padding 0x01 one, have no length
+	jpegRST7         = 0xd7
+	jpegTEM          = 0x01
+)
+
+// StripEXIF removes the APP1 segments of a JPEG, which hold its EXIF and XMP metadata (GPS coordinates,
+// camera serial numbers...) and which we don't want to serve publicly. Anything that is not a well formed
+// JPEG is returned untouched, so that decoding it fails or succeeds as usual.
+func StripEXIF(data []byte) []byte {
+	if len(data) < 2 || data[0] != jpegMarkerPrefix || data[1] != jpegSOI {
+		return data
+	}
+
+	stripped := bytes.NewBuffer(make([]byte, 0, len(data)))
+	stripped.Write(data[:2])
+
+	for i := 2; i < len(data); {
+		if data[i] != jpegMarkerPrefix || i+1 >= len(data) {
+			return data
+		}
+
+		// Markers may be preceded by any number of 0xff fill bytes
+		marker := data[i+1]
+		if marker == jpegMarkerPrefix {
+			i++
+			continue
+		}
+
+		if marker == jpegTEM || marker >= jpegRST0 && marker <= jpegRST7 {
+			stripped.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+
+		if marker == jpegSOS {
+			stripped.Write(data[i:])
+			return stripped.Bytes()
+		}
+
+		if i+4 > len(data) {
+			return data
+		}
+		end := i + 2 + int(data[i+2])<<8 + int(data[i+3])
+		if end > len(data) {
+			return data
+		}
+
+		if marker != jpegAPP1 {
+			stripped.Write(data[i:end])
+		}
+		i = end
+	}
+
+	return stripped.Bytes()
+}
@@ -0,0 +1,98 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Builds a JPEG carrying an EXIF segment whose only tag points to some GPS coordinates
+func generateJPEGWithGPS(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	if err := (Options{Format: JPEG}).encode(buf, generateRandomImage()); err != nil {
+		t.Fatalf("Unexpected error encoding a JPEG: %s", err)
+	}
+
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(tiff, binary.BigEndian, uint32(8))
+	// IFD0, with a GPSInfo tag pointing to the GPS IFD right after it
+	binary.Write(tiff, binary.BigEndian, []uint16{1, 0x8825, 4})
+	binary.Write(tiff, binary.BigEndian, []uint32{1, 26, 0})
+	// GPS IFD, with a GPSLatitudeRef tag
+	binary.Write(tiff, binary.BigEndian, []uint16{1, 0x0001, 2})
+	binary.Write(tiff, binary.BigEndian, []uint32{2})
+	tiff.WriteString("N\x00\x00\x00")
+	binary.Write(tiff, binary.BigEndian, uint32(0))
+
+	segment := new(bytes.Buffer)
+	segment.Write([]byte{0xff, 0xe1})
+	binary.Write(segment, binary.BigEndian, uint16(2+6+tiff.Len()))
+	segment.WriteString("Exif\x00\x00")
+	segment.Write(tiff.Bytes())
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment.Bytes()...), data[2:]...)
+}
+
+func TestStripEXIF(t *testing.T) {
+	data := generateJPEGWithGPS(t)
+	if !bytes.Contains(data, []byte("Exif\x00\x00")) {
+		t.Fatal("Expected the generated JPEG to carry an EXIF segment")
+	}
+
+	stripped := StripEXIF(data)
+	if bytes.Contains(stripped, []byte("Exif\x00\x00")) {
+		t.Error("Expected the EXIF segment to be stripped")
+	}
+
+	img, err := Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding the stripped JPEG: %s", err)
+	}
+	if !imagesAreEqual(img, generateRandomImage()) {
+		t.Error("Expected the stripped JPEG to keep the image")
+	}
+}
+
+func TestStripEXIFLeavesOtherImagesUntouched(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := (Options{Format: PNG}).encode(buf, generateRandomImage()); err != nil {
+		t.Fatalf("Unexpected error encoding a PNG: %s", err)
+	}
+
+	for _, data := range [][]byte{buf.Bytes(), []byte("not an image"), generateJPEGWithGPS(t)[:20]} {
+		if !bytes.Equal(StripEXIF(data), data) {
+			t.Errorf("Expected %q to be left untouched", data)
+		}
+	}
+}
+
+func TestStoredImagesHaveNoEXIF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	img, err := Decode(bytes.NewReader(StripEXIF(generateJPEGWithGPS(t))))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding the JPEG: %s", err)
+	}
+
+	store := newTestFileStore(t, dir, Options{Format: JPEG})
+	if _, err = store.Put(context.Background(), "some-image", img); err != nil {
+		t.Fatalf("Unexpected error on image .Put: %s", err)
+	}
+
+	stored, err := ioutil.ReadFile(store.path("some-image"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading the stored image: %s", err)
+	}
+	if bytes.Contains(stored, []byte("Exif")) {
+		t.Error("Expected the stored JPEG to carry no EXIF segment")
+	}
+}
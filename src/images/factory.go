@@ -0,0 +1,123 @@
+package images
+
+import (
+	"errors"
+	"github.com/devlucky/fakelink/src/logs"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	FACTORY:
+	Stores are picked by name, e.g. from an environment variable, and built from a single StoreConfig.
+	New backends only have to register how they are built
+*/
+
+// ErrUnknownStoreType is returned when building a Store of a type that was never registered.
+var ErrUnknownStoreType = errors.New("Unknown image store type")
+
+// StoreConfig describes the Store NewStore builds. Besides the Type, only the fields of that type are used.
+type StoreConfig struct {
+	// One of the registered types: memory, s3, gcs, file or redis, unless others are registered
+	Type    string
+	Options Options
+	Logger  *logs.Logger
+
+	// S3 is used by s3 stores, which authenticate with the default AWS credential chain when it has no access key
+	S3 S3Config
+
+	// Public image URLs are built on top of it by gcs, file and redis stores
+	PublicURL string
+	// The bucket of gcs stores, and the prefix of their objects' names, for buckets shared with other applications
+	GCSBucket    string
+	GCSKeyPrefix string
+	// The directory file stores keep their images in
+	BaseDir string
+
+	// How redis stores reach Redis, and how long they keep images for, zero being forever
+	RedisHost     string
+	RedisPort     string
+	RedisPassword string
+	RedisTTL      time.Duration
+}
+
+// A StoreBuilder builds a Store out of its config.
+type StoreBuilder func(config StoreConfig) (Store, error)
+
+var (
+	buildersMutex sync.RWMutex
+	builders      = map[string]StoreBuilder{
+		"memory": func(config StoreConfig) (Store, error) {
+			return NewInMemoryStore(), nil
+		},
+		"s3": func(config StoreConfig) (Store, error) {
+			newS3Store := NewS3Store
+			if config.S3.AccessKey == "" {
+				newS3Store = NewS3StoreWithDefaultCredentials
+			}
+
+			store, err := newS3Store(config.S3, config.Options, config.Logger)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+		"gcs": func(config StoreConfig) (Store, error) {
+			store, err := NewGCSStore(config.GCSBucket, config.GCSKeyPrefix, config.PublicURL, config.Options)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+		"file": func(config StoreConfig) (Store, error) {
+			store, err := NewFileStore(config.BaseDir, config.PublicURL, config.Options)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+		"redis": func(config StoreConfig) (Store, error) {
+			store, err := NewRedisStore(config.RedisHost, config.RedisPort, config.RedisPassword, config.PublicURL, config.RedisTTL, config.Options)
+			if err != nil {
+				return nil, err
+			}
+			return store, nil
+		},
+	}
+)
+
+// RegisterStore makes a Store type available to NewStore, replacing any previous one with the same name.
+func RegisterStore(storeType string, build StoreBuilder) {
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+
+	builders[storeType] = build
+}
+
+// NewStore builds a Store of the config's Type. Unknown types fail with ErrUnknownStoreType.
+func NewStore(config StoreConfig) (Store, error) {
+	buildersMutex.RLock()
+	build, ok := builders[config.Type]
+	buildersMutex.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownStoreType
+	}
+
+	return build(config)
+}
+
+// StoreTypes returns the types NewStore can build, sorted.
+func StoreTypes() []string {
+	buildersMutex.RLock()
+	defer buildersMutex.RUnlock()
+
+	var types []string
+	for storeType := range builders {
+		types = append(types, storeType)
+	}
+	sort.Strings(types)
+
+	return types
+}
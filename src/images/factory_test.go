@@ -0,0 +1,62 @@
+package images
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNewStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]Store{
+		"memory": &InMemoryStore{},
+		"file":   &FileStore{},
+		"redis":  &RedisStore{},
+	}
+
+	for storeType, expected := range cases {
+		store, err := NewStore(StoreConfig{Type: storeType, Options: Options{Format: JPEG}, BaseDir: dir, PublicURL: "http://127.0.0.1"})
+		if err != nil {
+			t.Errorf("Unexpected error building a %s store: %s", storeType, err)
+			continue
+		}
+
+		if reflect.TypeOf(store) != reflect.TypeOf(expected) {
+			t.Errorf("Expected a %s store to be a %T. Instead, it was a %T", storeType, expected, store)
+		}
+	}
+}
+
+func TestNewStoreWithUnknownType(t *testing.T) {
+	for _, storeType := range []string{"", "dropbox"} {
+		if store, err := NewStore(StoreConfig{Type: storeType}); store != nil || err != ErrUnknownStoreType {
+			t.Errorf("Expected %q to be an unknown store type. Instead, got %v", storeType, err)
+		}
+	}
+}
+
+func TestRegisterStore(t *testing.T) {
+	custom := NewInMemoryStore()
+	RegisterStore("custom", func(config StoreConfig) (Store, error) {
+		return custom, nil
+	})
+
+	store, err := NewStore(StoreConfig{Type: "custom"})
+	if err != nil || store != custom {
+		t.Errorf("Expected the registered store to be built. Instead, got %v", err)
+	}
+
+	found := false
+	for _, storeType := range StoreTypes() {
+		found = found || storeType == "custom"
+	}
+	if !found {
+		t.Errorf("Expected the registered type to be listed. Instead, got %v", StoreTypes())
+	}
+}
@@ -0,0 +1,242 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotAnImage is returned when a remote resource is not served as an image.
+	ErrNotAnImage = errors.New("The remote resource is not an image")
+	// ErrImageTooLarge is returned when a remote image exceeds the maximum allowed size.
+	ErrImageTooLarge = errors.New("The remote image is too large")
+	// ErrFetchTimeout is returned when a remote image takes longer than the timeout to download.
+	ErrFetchTimeout = errors.New("The remote image took too long to download")
+	// ErrForbiddenAddress is returned when a remote image, or a redirect on the way to it, is not on a public
+	// address, or is on a port other than 80 and 443.
+	ErrForbiddenAddress = errors.New("The remote image is not on a public address")
+	// ErrUnreachableImage is returned when a remote image does not answer with a 2xx status.
+	ErrUnreachableImage = errors.New("The remote image is unreachable")
+)
+
+// Defaults for the size and download time of remote images, used when Fetch is given zero values
+const (
+	DefaultFetchMaxBytes = 10 << 20
+	DefaultFetchTimeout  = 10 * time.Second
+)
+
+// Fetch downloads and decodes a remote image, as long as it is served with an image content type,
+// does not exceed maxBytes and downloads within the timeout. The download is abandoned as soon as
+// the context is done, e.g. when the client that asked for it goes away. As with uploads, the
+// image's metadata is stripped before decoding it. Unless unrestricted, the image and every redirect
+// on the way to it must be on public addresses, on ports 80 or 443, so that links can't be used to
+// reach internal services, such as cloud metadata endpoints.
+func Fetch(ctx context.Context, rawURL string, maxBytes int64, timeout time.Duration, unrestricted bool) (image.Image, error) {
+	guard := publicAddress
+	if unrestricted {
+		guard = nil
+	}
+
+	return fetch(ctx, rawURL, maxBytes, timeout, guard)
+}
+
+// Verify checks that a remote image is there without downloading it, through a HEAD request that must be answered
+// with a 2xx status and an image content type within the timeout. Servers that don't support HEAD are sent a GET,
+// whose body is left unread. Remote images are restricted to public addresses the way Fetch does.
+func Verify(ctx context.Context, rawURL string, timeout time.Duration, unrestricted bool) error {
+	guard := publicAddress
+	if unrestricted {
+		guard = nil
+	}
+	if timeout <= 0 {
+		timeout = DefaultFetchTimeout
+	}
+
+	client := fetchClient(timeout, guard)
+	resp, err := send(ctx, client, "HEAD", rawURL)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp, err = send(ctx, client, "GET", rawURL)
+	}
+	if err != nil {
+		return fetchError(ctx, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ErrUnreachableImage
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return ErrNotAnImage
+	}
+
+	return nil
+}
+
+// Sends a request whose response body is of no interest
+func send(ctx context.Context, client *http.Client, method, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp, nil
+}
+
+// Checks an address a remote image is about to be fetched from, before connecting to it
+type addressGuard func(ip net.IP, port string) error
+
+func fetch(ctx context.Context, rawURL string, maxBytes int64, timeout time.Duration, guard addressGuard) (image.Image, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultFetchMaxBytes
+	}
+	if timeout <= 0 {
+		timeout = DefaultFetchTimeout
+	}
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := fetchClient(timeout, guard).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fetchError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching the remote image failed with status %d", resp.StatusCode)
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return nil, ErrNotAnImage
+	}
+
+	if resp.ContentLength > maxBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	// Servers may lie about (or omit) the length, so we never read more than one byte past the limit
+	body := &io.LimitedReader{R: resp.Body, N: maxBytes + 1}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fetchError(ctx, err)
+	}
+
+	if body.N == 0 {
+		return nil, ErrImageTooLarge
+	}
+
+	return Decode(bytes.NewReader(StripEXIF(data)))
+}
+
+// Guarded clients resolve hosts themselves, checking every address they resolve to, and connect to the checked
+// address, so that the host can't resolve to another one in between. As redirects are followed through the same
+// client, they are checked too. Proxies are not used, as they would be the ones connecting to the image
+func fetchClient(timeout time.Duration, guard addressGuard) *http.Client {
+	if guard == nil {
+		return &http.Client{Timeout: timeout}
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				host, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+
+				addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+				if err != nil {
+					return nil, err
+				}
+				if len(addrs) == 0 {
+					return nil, fmt.Errorf("%s has no address", host)
+				}
+
+				for _, ip := range addrs {
+					if err = guard(ip.IP, port); err != nil {
+						return nil, err
+					}
+				}
+
+				return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+			},
+			TLSHandshakeTimeout: timeout,
+			DisableKeepAlives:   true,
+		},
+	}
+}
+
+// Networks remote images can't be fetched from: the private, loopback, link-local, shared, multicast and reserved
+// ones, which are either not reachable from the Internet or lead back into our own infrastructure
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24",
+	"192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+
+	return networks
+}
+
+// Only lets through public addresses, on the standard HTTP and HTTPS ports
+func publicAddress(ip net.IP, port string) error {
+	if port != "80" && port != "443" {
+		return ErrForbiddenAddress
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return ErrForbiddenAddress
+		}
+	}
+
+	return nil
+}
+
+// Tells a download that ran out of time apart from one whose context was cancelled or that failed otherwise
+func fetchError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if urlErr, ok := err.(*url.Error); ok && urlErr.Err == ErrForbiddenAddress {
+		return ErrForbiddenAddress
+	}
+
+	if netErr, ok := err.(interface {
+		Timeout() bool
+	}); ok && netErr.Timeout() {
+		return ErrFetchTimeout
+	}
+
+	return err
+}
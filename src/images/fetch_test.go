@@ -0,0 +1,246 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func serveImage(t *testing.T, contentType string) *httptest.Server {
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, generateRandomImage(), nil); err != nil {
+		t.Fatalf("Unexpected error encoding an image: %s", err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	}))
+}
+
+func TestFetch(t *testing.T) {
+	server := serveImage(t, "image/jpeg")
+	defer server.Close()
+
+	img, err := Fetch(context.Background(), server.URL, 1<<20, time.Second, true)
+	if err != nil {
+		t.Fatalf("Unexpected error fetching an image: %s", err)
+	}
+
+	if !imagesAreEqual(img, generateRandomImage()) {
+		t.Error("Expected the fetched image to be decoded")
+	}
+}
+
+func TestFetchNonImage(t *testing.T) {
+	server := serveImage(t, "text/html")
+	defer server.Close()
+
+	if _, err := Fetch(context.Background(), server.URL, 1<<20, time.Second, true); err != ErrNotAnImage {
+		t.Errorf("Expected fetching a non-image to fail with ErrNotAnImage. Instead, got %v", err)
+	}
+}
+
+func TestFetchTooLarge(t *testing.T) {
+	server := serveImage(t, "image/jpeg")
+	defer server.Close()
+
+	if _, err := Fetch(context.Background(), server.URL, 10, time.Second, true); err != ErrImageTooLarge {
+		t.Errorf("Expected fetching an oversized image to fail with ErrImageTooLarge. Instead, got %v", err)
+	}
+}
+
+func TestFetchMissing(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := Fetch(context.Background(), server.URL, 1<<20, time.Second, true); err == nil {
+		t.Error("Expected fetching a missing image to fail")
+	}
+}
+
+func TestFetchTooLargeWithoutContentLength(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		for i := 0; i < 4; i++ {
+			w.Write(make([]byte, 1024))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	if _, err := Fetch(context.Background(), server.URL, 2048, time.Second, true); err != ErrImageTooLarge {
+		t.Errorf("Expected a chunked oversized image to fail with ErrImageTooLarge. Instead, got %v", err)
+	}
+}
+
+// Serves the headers right away, then stalls the body until the test is over
+func serveSlowImage() (*httptest.Server, func()) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+
+	return server, func() {
+		close(release)
+		server.Close()
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	server, closeServer := serveSlowImage()
+	defer closeServer()
+
+	if _, err := Fetch(context.Background(), server.URL, 1<<20, 50*time.Millisecond, true); err != ErrFetchTimeout {
+		t.Errorf("Expected a slow download to fail with ErrFetchTimeout. Instead, got %v", err)
+	}
+}
+
+func TestFetchCancelled(t *testing.T) {
+	server, closeServer := serveSlowImage()
+	defer closeServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if _, err := Fetch(ctx, server.URL, 1<<20, time.Minute, true); err != context.Canceled {
+		t.Errorf("Expected a cancelled download to fail with the context's error. Instead, got %v", err)
+	}
+}
+
+func TestFetchMetadataEndpoint(t *testing.T) {
+	_, err := Fetch(context.Background(), "http://169.254.169.254/latest/meta-data/", 1<<20, time.Second, false)
+	if err != ErrForbiddenAddress {
+		t.Errorf("Expected fetching the cloud metadata endpoint to fail with ErrForbiddenAddress. Instead, got %v", err)
+	}
+}
+
+func TestFetchForbiddenAddresses(t *testing.T) {
+	for _, raw := range []string{"http://localhost/image.jpg", "http://10.0.0.1/image.jpg", "http://[::1]/image.jpg", "http://93.184.216.34:8080/image.jpg"} {
+		if _, err := Fetch(context.Background(), raw, 1<<20, time.Second, false); err != ErrForbiddenAddress {
+			t.Errorf("Expected fetching %s to fail with ErrForbiddenAddress. Instead, got %v", raw, err)
+		}
+	}
+}
+
+func TestFetchRedirectToLocalhost(t *testing.T) {
+	fetched := false
+	imageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Header().Set("Content-Type", "image/jpeg")
+	}))
+	defer imageServer.Close()
+
+	imageURL, _ := url.Parse(imageServer.URL)
+	_, imagePort, _ := net.SplitHostPort(imageURL.Host)
+	redirectingServer := httptest.NewServer(http.RedirectHandler("http://localhost:"+imagePort+"/image.jpg", http.StatusFound))
+	defer redirectingServer.Close()
+
+	// The redirecting server stands for a public one, which it would have to be for the redirect to be followed
+	redirectingURL, _ := url.Parse(redirectingServer.URL)
+	_, redirectingPort, _ := net.SplitHostPort(redirectingURL.Host)
+	guard := func(ip net.IP, port string) error {
+		if ip.IsLoopback() && port == redirectingPort {
+			return nil
+		}
+		return publicAddress(ip, port)
+	}
+
+	if _, err := fetch(context.Background(), redirectingServer.URL, 1<<20, time.Second, guard); err != ErrForbiddenAddress {
+		t.Errorf("Expected a redirect to localhost to fail with ErrForbiddenAddress. Instead, got %v", err)
+	}
+
+	if fetched {
+		t.Error("Expected the redirect to localhost not to be followed")
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	cases := []struct {
+		ip, port string
+		public   bool
+	}{
+		{"93.184.216.34", "80", true},
+		{"93.184.216.34", "443", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", "443", true},
+		{"93.184.216.34", "8080", false},
+		{"127.0.0.1", "80", false},
+		{"10.1.2.3", "80", false},
+		{"172.16.0.1", "80", false},
+		{"192.168.1.1", "80", false},
+		{"169.254.169.254", "80", false},
+		{"100.64.0.1", "80", false},
+		{"0.0.0.0", "80", false},
+		{"::1", "80", false},
+		{"::ffff:127.0.0.1", "80", false},
+		{"fd00::1", "80", false},
+		{"fe80::1", "80", false},
+	}
+
+	for _, c := range cases {
+		err := publicAddress(net.ParseIP(c.ip), c.port)
+		if (err == nil) != c.public {
+			t.Errorf("Expected %s on port %s to be public: %t. Instead, got %v", c.ip, c.port, c.public, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server := serveImage(t, "image/jpeg")
+	defer server.Close()
+
+	if err := Verify(context.Background(), server.URL, time.Second, true); err != nil {
+		t.Errorf("Expected a reachable image to be verified. Instead, got %s", err)
+	}
+}
+
+func TestVerifyMissingImage(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if err := Verify(context.Background(), server.URL, time.Second, true); err != ErrUnreachableImage {
+		t.Errorf("Expected a missing image to fail with ErrUnreachableImage. Instead, got %v", err)
+	}
+}
+
+func TestVerifyNonImage(t *testing.T) {
+	server := serveImage(t, "text/plain")
+	defer server.Close()
+
+	if err := Verify(context.Background(), server.URL, time.Second, true); err != ErrNotAnImage {
+		t.Errorf("Expected a text/plain resource to fail with ErrNotAnImage. Instead, got %v", err)
+	}
+}
+
+func TestVerifyWithoutHEADSupport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+	}))
+	defer server.Close()
+
+	if err := Verify(context.Background(), server.URL, time.Second, true); err != nil {
+		t.Errorf("Expected servers without HEAD support to be sent a GET. Instead, got %s", err)
+	}
+}
+
+func TestVerifyMetadataEndpoint(t *testing.T) {
+	if err := Verify(context.Background(), "http://169.254.169.254/latest/meta-data/", time.Second, false); err != ErrForbiddenAddress {
+		t.Errorf("Expected verifying the cloud metadata endpoint to fail with ErrForbiddenAddress. Instead, got %v", err)
+	}
+}
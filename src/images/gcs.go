@@ -0,0 +1,161 @@
+package images
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"image"
+	"strings"
+)
+
+/*
+	Implementation of a Store based on Google Cloud Storage
+*/
+
+// GCSStore is a Google Cloud Storage based implementation of the Store interface.
+type GCSStore struct {
+	client     *storage.Client
+	bucket     string
+	keyPrefix  string
+	urlPattern string
+	opts       Options
+}
+
+// NewGCSStore creates a new GCSStore for the given bucket, authenticated with the application default credentials,
+// such as the service account key pointed by GOOGLE_APPLICATION_CREDENTIALS or the GCE metadata server.
+// Objects are named after the image keys with keyPrefix prepended, so that the bucket can be shared with other
+// applications. Images are encoded with the given options.
+func NewGCSStore(bucket, keyPrefix, publicURL string, opts Options) (*GCSStore, error) {
+	return newGCSStore(bucket, keyPrefix, publicURL, opts)
+}
+
+func newGCSStore(bucket, keyPrefix, publicURL string, opts Options, clientOpts ...option.ClientOption) (*GCSStore, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := storage.NewClient(context.Background(), clientOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCSStore{
+		client:     client,
+		bucket:     bucket,
+		keyPrefix:  keyPrefix,
+		urlPattern: publicURL + "/" + bucket + "/" + keyPrefix + "%s",
+		opts:       opts,
+	}, nil
+}
+
+// Put encodes an image and uploads it to the bucket.
+func (store *GCSStore) Put(ctx context.Context, key string, img image.Image) (url string, err error) {
+	buf := new(bytes.Buffer)
+	err = store.opts.encode(buf, img)
+	if err != nil {
+		return
+	}
+
+	w := store.object(key).NewWriter(ctx)
+	w.ContentType = store.opts.FormatOf(img).ContentType()
+	if _, err = buf.WriteTo(w); err != nil {
+		w.Close()
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+
+	url = store.GetURL(key)
+	return
+}
+
+// Get downloads an image from the bucket.
+func (store *GCSStore) Get(ctx context.Context, key string) (img image.Image, err error) {
+	r, err := store.object(key).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return Decode(r)
+}
+
+// GetURL returns the public URL of an image in the bucket.
+func (store *GCSStore) GetURL(key string) string {
+	return fmt.Sprintf(store.urlPattern, key)
+}
+
+// StoredSize returns the dimensions an image is stored with, once downscaled to the store's maximum ones.
+func (store *GCSStore) StoredSize(img image.Image) (width, height int) {
+	return store.opts.storedSize(img)
+}
+
+// FormatOf returns the format an image is stored in: GIF for animations, the configured one otherwise.
+func (store *GCSStore) FormatOf(img image.Image) Format {
+	return store.opts.FormatOf(img)
+}
+
+// Delete removes an image from the bucket. Deleting a missing image is not an error.
+func (store *GCSStore) Delete(ctx context.Context, key string) error {
+	err := store.object(key).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+
+	return err
+}
+
+// Keys lists the keys of every image in the bucket, leaving out the objects outside of the store's key prefix.
+func (store *GCSStore) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+
+	objects := store.client.Bucket(store.bucket).Objects(ctx, &storage.Query{Prefix: store.keyPrefix})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, strings.TrimPrefix(attrs.Name, store.keyPrefix))
+	}
+}
+
+// Clear removes every image from the bucket, leaving alone the objects outside of the store's key prefix.
+func (store *GCSStore) Clear(ctx context.Context) error {
+	keys, err := store.Keys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err = store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ping checks that the bucket is reachable with the store's credentials.
+func (store *GCSStore) Ping() error {
+	_, err := store.client.Bucket(store.bucket).Attrs(context.Background())
+	if err == storage.ErrBucketNotExist {
+		return fmt.Errorf("The GCS bucket %s does not exist", store.bucket)
+	}
+
+	return err
+}
+
+func (store *GCSStore) object(key string) *storage.ObjectHandle {
+	return store.client.Bucket(store.bucket).Object(store.keyPrefix + key)
+}
@@ -0,0 +1,228 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/api/option"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Minimal fake of the GCS endpoints the client uses, paginating object listings two by two
+type fakeGCS struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+type fakeGCSObject struct {
+	Name   string `json:"name"`
+	Bucket string `json:"bucket"`
+}
+
+func (gcs *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gcs.mutex.Lock()
+	defer gcs.mutex.Unlock()
+
+	const objectsPath = "/storage/v1/b/bucket/o"
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/upload"+objectsPath:
+		name, data, err := readMultipartUpload(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		gcs.objects[name] = data
+		json.NewEncoder(w).Encode(&fakeGCSObject{Name: name, Bucket: "bucket"})
+
+	case r.Method == "GET" && r.URL.Path == objectsPath:
+		names := []string{}
+		for name := range gcs.objects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		list := struct {
+			Items         []fakeGCSObject `json:"items"`
+			NextPageToken string          `json:"nextPageToken,omitempty"`
+		}{}
+		for _, name := range names {
+			if name <= r.URL.Query().Get("pageToken") || !strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				continue
+			}
+			if len(list.Items) == 2 {
+				list.NextPageToken = list.Items[1].Name
+				break
+			}
+			list.Items = append(list.Items, fakeGCSObject{Name: name, Bucket: "bucket"})
+		}
+		json.NewEncoder(w).Encode(list)
+
+	case r.Method == "GET" && r.URL.Path == "/storage/v1/b/bucket":
+		w.Write([]byte(`{"name": "bucket"}`))
+
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, objectsPath+"/"):
+		name := strings.TrimPrefix(r.URL.Path, objectsPath+"/")
+		if _, ok := gcs.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(gcs.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	// Objects are downloaded from the XML API, at /bucket/name
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/bucket/"):
+		data, ok := gcs.objects[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Uploads small enough to fit a single chunk hold the object's metadata, then its data
+func readMultipartUpload(r *http.Request) (name string, data []byte, err error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return
+	}
+	parts := multipart.NewReader(r.Body, params["boundary"])
+
+	part, err := parts.NextPart()
+	if err != nil {
+		return
+	}
+	object := &fakeGCSObject{}
+	if err = json.NewDecoder(part).Decode(object); err != nil {
+		return
+	}
+
+	part, err = parts.NextPart()
+	if err != nil {
+		return
+	}
+	data, err = ioutil.ReadAll(part)
+	return object.Name, data, err
+}
+
+// Sends every request of the client to the fake, whichever Google host it was meant for
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (transport *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = transport.target.Scheme
+	req.URL.Host = transport.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newFakeGCSStore(t *testing.T) (*GCSStore, *fakeGCS, func()) {
+	return newFakeGCSStoreWithPrefix(t, "")
+}
+
+func newFakeGCSStoreWithPrefix(t *testing.T, keyPrefix string) (*GCSStore, *fakeGCS, func()) {
+	gcs := &fakeGCS{objects: make(map[string][]byte)}
+	server := httptest.NewServer(gcs)
+	target, _ := url.Parse(server.URL)
+
+	client := &http.Client{Transport: &redirectTransport{target: target}}
+	store, err := newGCSStore("bucket", keyPrefix, "https://storage.googleapis.com", Options{Format: JPEG}, option.WithHTTPClient(client))
+	if err != nil {
+		t.Fatalf("Unexpected error creating the GCS store: %s", err)
+	}
+
+	return store, gcs, server.Close
+}
+
+func TestGCSStore(t *testing.T) {
+	store, _, closeServer := newFakeGCSStore(t)
+	defer closeServer()
+
+	behavesLikeAStore(t, store)
+}
+
+func TestGCSStoreClearFollowsPagination(t *testing.T) {
+	store, gcs, closeServer := newFakeGCSStore(t)
+	defer closeServer()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := store.Put(context.Background(), key, generateRandomImage()); err != nil {
+			t.Fatalf("Unexpected error on image .Put: %s", err)
+		}
+	}
+
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatalf("Unexpected error on .Clear: %s", err)
+	}
+
+	if len(gcs.objects) != 0 {
+		t.Errorf("Expected .Clear to delete every object. Instead, %d were left", len(gcs.objects))
+	}
+}
+
+func TestGCSStoreKeyPrefix(t *testing.T) {
+	store, gcs, closeServer := newFakeGCSStoreWithPrefix(t, "fakelink/")
+	defer closeServer()
+	gcs.objects["other-app/some-file"] = []byte("not an image")
+
+	behavesLikeAStore(t, store)
+
+	url, err := store.Put(context.Background(), "some-image", generateRandomImage())
+	if err != nil {
+		t.Fatalf("Unexpected error on image .Put: %s", err)
+	}
+	if _, ok := gcs.objects["fakelink/some-image"]; !ok {
+		t.Error("Expected the object's name to start with the prefix")
+	}
+	if url != "https://storage.googleapis.com/bucket/fakelink/some-image" {
+		t.Errorf("Expected the image URL to include the prefix. Instead, it was %s", url)
+	}
+
+	if err = store.Clear(context.Background()); err != nil {
+		t.Fatalf("Unexpected error on .Clear: %s", err)
+	}
+	if _, ok := gcs.objects["other-app/some-file"]; !ok || len(gcs.objects) != 1 {
+		t.Errorf("Expected .Clear to only delete the objects under the prefix. Instead, %d were left", len(gcs.objects))
+	}
+}
+
+func TestGCSStorePingMissingBucket(t *testing.T) {
+	store, _, closeServer := newFakeGCSStore(t)
+	defer closeServer()
+
+	store.bucket = "missing"
+	if err := store.Ping(); err == nil {
+		t.Error("Expected .Ping to fail when the bucket does not exist")
+	}
+}
+
+func TestGCSStoreURLPattern(t *testing.T) {
+	store, _, closeServer := newFakeGCSStore(t)
+	defer closeServer()
+
+	url, err := store.Put(context.Background(), "some-image", generateRandomImage())
+	if err != nil {
+		t.Fatalf("Unexpected error on image .Put: %s", err)
+	}
+
+	if url != "https://storage.googleapis.com/bucket/some-image" {
+		t.Errorf("Expected the image URL to follow the bucket pattern. Instead, it was %s", url)
+	}
+}
+
+func TestNewGCSStoreWithInvalidOptions(t *testing.T) {
+	if _, err := NewGCSStore("bucket", "", "https://storage.googleapis.com", Options{Format: "bmp"}); err == nil {
+		t.Error("Expected invalid image options to fail the creation of a GCS store")
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
-	"image/jpeg"
+	"image/gif"
 	"io/ioutil"
 	"math/rand"
 )
@@ -24,7 +24,7 @@ func getFixtureImage(name string) image.Image {
 		panic(err)
 	}
 
-	img, err := jpeg.Decode(bytes.NewBuffer(data))
+	img, err := Decode(bytes.NewBuffer(data))
 	if err != nil {
 		panic(err)
 	}
@@ -46,3 +46,20 @@ func generateRandomImageWithSize(width, height int) *image.RGBA {
 
 	return img
 }
+
+// An animation whose frames are each filled with a different palette color
+func generateAnimation(width, height, frames int) *Animation {
+	palette := color.Palette{color.Black, color.White, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	anim := &gif.GIF{Config: image.Config{Width: width, Height: height, ColorModel: palette}}
+
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i % len(palette))
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	return &Animation{GIF: anim}
+}
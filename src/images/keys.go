@@ -0,0 +1,78 @@
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/satori/go.uuid"
+	"hash"
+	"image"
+	"strings"
+)
+
+// KeyStrategy derives the key an image is stored under.
+type KeyStrategy func(img image.Image) string
+
+// Key strategies, by the name IMAGE_KEYS picks them with
+var keyStrategies = map[string]KeyStrategy{
+	"random":  RandomKey,
+	"content": ContentKey,
+}
+
+// KeyStrategyNamed returns the key strategy with the given name, either "random" or "content".
+func KeyStrategyNamed(name string) (KeyStrategy, error) {
+	strategy, ok := keyStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown image key strategy %q, which must be random or content", name)
+	}
+
+	return strategy, nil
+}
+
+// RandomKey gives every image a key of its own, a random UUID, even when the very same image was stored before.
+func RandomKey(img image.Image) string {
+	return uuid.NewV4().String()
+}
+
+const contentKeyPrefix = "content-"
+
+// ContentKey derives the key from the dimensions and pixels of the image, so that identical images are stored once
+// and shared by every link showing them.
+func ContentKey(img image.Image) string {
+	sum := sha256.New()
+	if anim, ok := img.(*Animation); ok {
+		for i, frame := range anim.GIF.Image {
+			writePixels(sum, frame)
+			if i < len(anim.GIF.Delay) {
+				binary.Write(sum, binary.BigEndian, int64(anim.GIF.Delay[i]))
+			}
+		}
+	} else {
+		writePixels(sum, img)
+	}
+
+	return contentKeyPrefix + hex.EncodeToString(sum.Sum(nil))
+}
+
+// IsContentKey reports whether a key was derived by ContentKey, in which case the image may be shared.
+func IsContentKey(key string) bool {
+	return strings.HasPrefix(key, contentKeyPrefix)
+}
+
+func writePixels(h hash.Hash, img image.Image) {
+	bounds := img.Bounds()
+	binary.Write(h, binary.BigEndian, []int64{int64(bounds.Min.X), int64(bounds.Min.Y), int64(bounds.Max.X), int64(bounds.Max.Y)})
+
+	pixel := make([]byte, 8)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			binary.BigEndian.PutUint16(pixel[0:], uint16(r))
+			binary.BigEndian.PutUint16(pixel[2:], uint16(g))
+			binary.BigEndian.PutUint16(pixel[4:], uint16(b))
+			binary.BigEndian.PutUint16(pixel[6:], uint16(a))
+			h.Write(pixel)
+		}
+	}
+}
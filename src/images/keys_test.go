@@ -0,0 +1,59 @@
+package images
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func TestContentKeyOfIdenticalImages(t *testing.T) {
+	buf := &bytes.Buffer{}
+	png.Encode(buf, generateRandomImageWithSize(40, 20))
+
+	first, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Unexpected error decoding the image", err)
+	}
+	second, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Unexpected error decoding the image", err)
+	}
+
+	key := ContentKey(first)
+	if key != ContentKey(second) {
+		t.Errorf("Expected identical image bytes to produce the same key. Instead, got %s and %s", key, ContentKey(second))
+	}
+	if !IsContentKey(key) {
+		t.Errorf("Expected %s to be recognized as a content key", key)
+	}
+}
+
+func TestContentKeyOfDifferentImages(t *testing.T) {
+	if ContentKey(generateRandomImageWithSize(40, 20)) == ContentKey(generateRandomImageWithSize(40, 20)) {
+		t.Error("Expected different images to produce different keys")
+	}
+}
+
+func TestRandomKeyOfIdenticalImages(t *testing.T) {
+	img := generateRandomImageWithSize(40, 20)
+
+	key := RandomKey(img)
+	if key == RandomKey(img) {
+		t.Error("Expected every image to get a key of its own")
+	}
+	if IsContentKey(key) {
+		t.Errorf("Expected %s not to be recognized as a content key", key)
+	}
+}
+
+func TestKeyStrategyNamed(t *testing.T) {
+	for _, name := range []string{"random", "content"} {
+		if _, err := KeyStrategyNamed(name); err != nil {
+			t.Errorf("Expected %s to be a key strategy. Instead, got %s", name, err)
+		}
+	}
+
+	if _, err := KeyStrategyNamed("slug"); err == nil {
+		t.Error("Expected unknown key strategies to fail")
+	}
+}
@@ -0,0 +1,86 @@
+package images
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+const (
+	// PlaceholderWidth and PlaceholderHeight are the dimensions of the generated placeholders,
+	// matching the 1.91:1 ratio link previews are usually rendered with
+	PlaceholderWidth  = 1200
+	PlaceholderHeight = 630
+
+	// The text is drawn with a tiny bitmap font over a canvas this many times smaller, which is then scaled up
+	placeholderScale   = 5
+	placeholderPadding = 8
+)
+
+// Backgrounds placeholders pick from, so that different texts tend to get different colors
+var placeholderBackgrounds = []color.RGBA{
+	{0x34, 0x49, 0x5e, 0xff},
+	{0x16, 0xa0, 0x85, 0xff},
+	{0x27, 0x80, 0xb9, 0xff},
+	{0x8e, 0x44, 0xad, 0xff},
+	{0xc0, 0x39, 0x2b, 0xff},
+	{0xd3, 0x54, 0x00, 0xff},
+}
+
+// Placeholder renders an image with the given text centered over a solid background, for links that come without one.
+// The same text always renders the same image, so it can be stored once under PlaceholderKey.
+func Placeholder(text string) image.Image {
+	text = strings.TrimSpace(text)
+	width, height := PlaceholderWidth/placeholderScale, PlaceholderHeight/placeholderScale
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	sum := sha1.Sum([]byte(text))
+	background := placeholderBackgrounds[int(sum[0])%len(placeholderBackgrounds)]
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{background}, image.ZP, draw.Src)
+
+	drawer := &font.Drawer{Dst: canvas, Src: image.White, Face: basicfont.Face7x13}
+	text = fitText(drawer, text, width-2*placeholderPadding)
+
+	metrics := drawer.Face.Metrics()
+	drawer.Dot = fixed.Point26_6{
+		X: (fixed.I(width) - drawer.MeasureString(text)) / 2,
+		Y: (fixed.I(height) + metrics.Ascent - metrics.Descent) / 2,
+	}
+	drawer.DrawString(text)
+
+	return imaging.Resize(canvas, PlaceholderWidth, PlaceholderHeight, imaging.NearestNeighbor)
+}
+
+const placeholderKeyPrefix = "placeholder-"
+
+// PlaceholderKey returns the key the placeholder for the given text is stored under, the same for identical texts.
+func PlaceholderKey(text string) string {
+	sum := sha1.Sum([]byte(strings.TrimSpace(text)))
+	return placeholderKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// IsPlaceholderKey reports whether a key was derived by PlaceholderKey, in which case the image may be shared.
+func IsPlaceholderKey(key string) bool {
+	return strings.HasPrefix(key, placeholderKeyPrefix)
+}
+
+// Shortens the text with an ellipsis until it fits in the given width
+func fitText(drawer *font.Drawer, text string, maxWidth int) string {
+	if drawer.MeasureString(text) <= fixed.I(maxWidth) {
+		return text
+	}
+
+	runes := []rune(text)
+	for len(runes) > 0 && drawer.MeasureString(string(runes)+"...") > fixed.I(maxWidth) {
+		runes = runes[:len(runes)-1]
+	}
+
+	return strings.TrimSpace(string(runes)) + "..."
+}
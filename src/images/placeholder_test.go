@@ -0,0 +1,59 @@
+package images
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlaceholder(t *testing.T) {
+	img := Placeholder("DevLucky")
+
+	if bounds := img.Bounds(); bounds.Dx() != PlaceholderWidth || bounds.Dy() != PlaceholderHeight {
+		t.Errorf("Expected the placeholder to be %dx%d. Instead, it was %v", PlaceholderWidth, PlaceholderHeight, bounds)
+	}
+
+	// The corners hold the background, while the center holds some of the text
+	background := img.At(0, 0)
+	text := false
+	for x := PlaceholderWidth / 3; x < 2*PlaceholderWidth/3; x++ {
+		if img.At(x, PlaceholderHeight/2) != background {
+			text = true
+			break
+		}
+	}
+	if !text {
+		t.Error("Expected the placeholder's text to be drawn at its center")
+	}
+}
+
+func TestPlaceholderIsDeterministic(t *testing.T) {
+	a, b := Placeholder("DevLucky"), Placeholder("DevLucky")
+
+	for _, point := range [][2]int{{0, 0}, {PlaceholderWidth / 2, PlaceholderHeight / 2}, {PlaceholderWidth - 1, PlaceholderHeight - 1}} {
+		if a.At(point[0], point[1]) != b.At(point[0], point[1]) {
+			t.Fatalf("Expected identical texts to render identical placeholders. Instead, they differ at %v", point)
+		}
+	}
+}
+
+func TestPlaceholderShortensLongTexts(t *testing.T) {
+	img := Placeholder(strings.Repeat("Really long site name ", 20))
+
+	if img.At(0, PlaceholderHeight/2) != img.At(0, 0) || img.At(PlaceholderWidth-1, PlaceholderHeight/2) != img.At(0, 0) {
+		t.Error("Expected long texts to be shortened to fit within the placeholder's padding")
+	}
+}
+
+func TestPlaceholderKey(t *testing.T) {
+	if PlaceholderKey("DevLucky") != PlaceholderKey(" DevLucky ") {
+		t.Error("Expected placeholders for identical texts to share a key")
+	}
+
+	if PlaceholderKey("DevLucky") == PlaceholderKey("IMDb") {
+		t.Error("Expected placeholders for different texts to have different keys")
+	}
+
+	if !IsPlaceholderKey(PlaceholderKey("DevLucky")) || IsPlaceholderKey("some-image") {
+		t.Error("Expected only keys derived by PlaceholderKey to be told apart as placeholders")
+	}
+}
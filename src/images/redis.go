@@ -0,0 +1,164 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gopkg.in/redis.v5"
+	"image"
+	"strings"
+	"time"
+)
+
+/*
+	Implementation of a Store based on Redis, for short-lived images
+*/
+
+const redisNamespace = "images:"
+
+// RedisStore is a Redis based implementation of the Store interface, whose images expire after a TTL.
+// Its URLs point to the API's own GET /images/:key endpoint.
+type RedisStore struct {
+	client     *redis.Client
+	ttl        time.Duration
+	urlPattern string
+	opts       Options
+}
+
+// NewRedisStore creates a new RedisStore whose images expire after the given TTL. A zero TTL keeps them forever.
+func NewRedisStore(host, port, password, publicURL string, ttl time.Duration, opts Options) (*RedisStore, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return &RedisStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%s", host, port),
+			Password: password,
+			DB:       2,
+		}),
+		ttl:        ttl,
+		urlPattern: publicURL + "/images/%s",
+		opts:       opts,
+	}, nil
+}
+
+// Put encodes an image and stores its bytes until the TTL expires.
+func (store *RedisStore) Put(ctx context.Context, key string, img image.Image) (url string, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = store.opts.encode(buf, img)
+	if err != nil {
+		return
+	}
+
+	err = store.client.Set(redisNamespace+key, buf.Bytes(), store.ttl).Err()
+	if err != nil {
+		return
+	}
+
+	url = store.GetURL(key)
+	return
+}
+
+// Get retrieves an image from Redis, as long as it has not expired.
+func (store *RedisStore) Get(ctx context.Context, key string) (img image.Image, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	data, err := store.client.Get(redisNamespace + key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(bytes.NewReader(data))
+}
+
+// GetURL returns the URL the API serves an image through.
+func (store *RedisStore) GetURL(key string) string {
+	return fmt.Sprintf(store.urlPattern, key)
+}
+
+// StoredSize returns the dimensions an image is stored with, once downscaled to the store's maximum ones.
+func (store *RedisStore) StoredSize(img image.Image) (width, height int) {
+	return store.opts.storedSize(img)
+}
+
+// FormatOf returns the format an image is stored in: GIF for animations, the configured one otherwise.
+func (store *RedisStore) FormatOf(img image.Image) Format {
+	return store.opts.FormatOf(img)
+}
+
+// Delete removes an image before its TTL expires. Deleting a missing image is not an error.
+func (store *RedisStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return store.client.Del(redisNamespace + key).Err()
+}
+
+// Ping checks that Redis is reachable.
+func (store *RedisStore) Ping() error {
+	return store.client.Ping().Err()
+}
+
+// Keys lists the keys of every image in the store's namespace.
+func (store *RedisStore) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	var cursor uint64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		found, next, err := store.client.Scan(cursor, redisNamespace+"*", 1000).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range found {
+			keys = append(keys, strings.TrimPrefix(key, redisNamespace))
+		}
+
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// Clear removes every image in the store's namespace.
+func (store *RedisStore) Clear(ctx context.Context) error {
+	var cursor uint64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys, next, err := store.client.Scan(cursor, redisNamespace+"*", 1000).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			if err = store.client.Del(keys...).Err(); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,50 @@
+package images
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestRedisStore(tb testing.TB, ttl time.Duration) *RedisStore {
+	store, err := NewRedisStore(
+		os.Getenv("REDIS_HOST"),
+		os.Getenv("REDIS_PORT"),
+		os.Getenv("REDIS_PASS"),
+		"http://127.0.0.1",
+		ttl,
+		Options{Format: JPEG},
+	)
+	if err != nil {
+		tb.Fatalf("Unexpected error creating the Redis store: %s", err)
+	}
+
+	return store
+}
+
+func TestRedisStore(t *testing.T) {
+	behavesLikeAStore(t, newTestRedisStore(t, time.Minute))
+}
+
+func TestRedisStoreExpiration(t *testing.T) {
+	store := newTestRedisStore(t, time.Minute)
+	clearStore(t, store)
+
+	if _, err := store.Put(context.Background(), "some-image", generateRandomImage()); err != nil {
+		t.Fatal("Unexpected error on image .Put", err)
+	}
+
+	ttl, err := store.client.TTL(redisNamespace + "some-image").Result()
+	if err != nil {
+		t.Fatal("Unexpected error reading the image's TTL", err)
+	}
+
+	if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected images to expire after the store's TTL. Instead, it was %s", ttl)
+	}
+}
+
+func BenchmarkRedisStore(b *testing.B) {
+	benchmarkStore(b, newTestRedisStore(b, time.Minute))
+}
@@ -0,0 +1,60 @@
+package images
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"net/http"
+	"time"
+)
+
+// Defaults for retrying the S3 requests that fail temporarily
+const (
+	DefaultS3MaxAttempts = 3
+	DefaultS3Backoff     = 100 * time.Millisecond
+)
+
+// Error codes S3 (and MinIO) answer with when throttling or briefly unable to serve a request
+var retryableS3Codes = map[string]bool{
+	"InternalError":        true,
+	"RequestTimeout":       true,
+	"ServiceUnavailable":   true,
+	"SlowDown":             true,
+	"Throttling":           true,
+	"ThrottlingException":  true,
+	"RequestThrottled":     true,
+	"RequestLimitExceeded": true,
+}
+
+// Runs an S3 request up to MaxAttempts times while it fails with a server error or gets throttled,
+// doubling the wait between attempts. Waiting stops as soon as the context is done
+func (store *S3Store) retry(ctx context.Context, request func() error) error {
+	backoff := store.Backoff
+	for attempt := 1; ; attempt++ {
+		err := request()
+		if err == nil || attempt >= store.MaxAttempts || !retryableS3Error(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func retryableS3Error(err error) bool {
+	if failure, ok := err.(awserr.RequestFailure); ok {
+		status := failure.StatusCode()
+		if status >= 500 || status == http.StatusTooManyRequests {
+			return true
+		}
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		return retryableS3Codes[aerr.Code()]
+	}
+
+	return false
+}
@@ -0,0 +1,158 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Fails the first requests with the given error, then behaves like an empty but working bucket
+type flakyS3 struct {
+	s3API
+	failures int
+	err      error
+	calls    int
+}
+
+func (client *flakyS3) fail() error {
+	client.calls++
+	if client.calls <= client.failures {
+		return client.err
+	}
+	return nil
+}
+
+func (client *flakyS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := client.fail(); err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (client *flakyS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if err := client.fail(); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	Options{Format: JPEG}.encode(buf, generateRandomImage())
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(buf)}, nil
+}
+
+func (client *flakyS3) ListObjectsWithContext(ctx aws.Context, in *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, error) {
+	if err := client.fail(); err != nil {
+		return nil, err
+	}
+	return &s3.ListObjectsOutput{}, nil
+}
+
+func serverError(status int) error {
+	return awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "Please reduce your request rate", nil), status, "some-request")
+}
+
+func newFlakyS3Store(failures int, err error) (*S3Store, *flakyS3) {
+	client := &flakyS3{failures: failures, err: err}
+	store := &S3Store{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		client:      client,
+		urlPattern:  "http://127.0.0.1/%s",
+		opts:        Options{Format: JPEG},
+	}
+
+	return store, client
+}
+
+func TestS3StoreRetriesServerErrors(t *testing.T) {
+	store, client := newFlakyS3Store(2, serverError(http.StatusServiceUnavailable))
+
+	if _, err := store.Put(context.Background(), "some-key", generateRandomImage()); err != nil {
+		t.Fatalf("Expected .Put to succeed once S3 recovered. Instead, got %s", err)
+	}
+
+	if client.calls != 3 {
+		t.Errorf("Expected .Put to be attempted 3 times. Instead, it was %d", client.calls)
+	}
+}
+
+func TestS3StoreRetriesThrottling(t *testing.T) {
+	store, client := newFlakyS3Store(1, awserr.New("SlowDown", "Please reduce your request rate", nil))
+
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatalf("Expected .Clear to succeed once S3 stopped throttling. Instead, got %s", err)
+	}
+
+	if client.calls != 2 {
+		t.Errorf("Expected the listing to be attempted twice. Instead, it was %d", client.calls)
+	}
+}
+
+func TestS3StoreGivesUpAfterMaxAttempts(t *testing.T) {
+	store, client := newFlakyS3Store(5, serverError(http.StatusInternalServerError))
+
+	if _, err := store.Get(context.Background(), "some-key"); err == nil {
+		t.Error("Expected .Get to fail while S3 keeps failing")
+	}
+
+	if client.calls != 3 {
+		t.Errorf("Expected .Get to be attempted 3 times. Instead, it was %d", client.calls)
+	}
+}
+
+func TestS3StoreDoesNotRetryMissingKeys(t *testing.T) {
+	notFound := awserr.NewRequestFailure(awserr.New("NoSuchKey", "The specified key does not exist", nil), http.StatusNotFound, "some-request")
+	store, client := newFlakyS3Store(1, notFound)
+
+	if _, err := store.Get(context.Background(), "missing"); err != ErrNotFound {
+		t.Errorf("Expected .Get on a missing key to fail with ErrNotFound. Instead, got %v", err)
+	}
+
+	if client.calls != 1 {
+		t.Errorf("Expected a missing key not to be retried. Instead, .Get was attempted %d times", client.calls)
+	}
+}
+
+func TestS3StoreRetryStopsWhenTheContextIsDone(t *testing.T) {
+	store, _ := newFlakyS3Store(0, nil)
+	store.Backoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := store.retry(ctx, func() error {
+		calls++
+		return serverError(http.StatusServiceUnavailable)
+	})
+
+	if err != context.Canceled {
+		t.Errorf("Expected the retry to stop with the context's error. Instead, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected no further attempt once the context is done. Instead, there were %d", calls)
+	}
+}
+
+func TestRetryableS3Error(t *testing.T) {
+	cases := map[error]bool{
+		serverError(http.StatusServiceUnavailable):                     true,
+		serverError(http.StatusTooManyRequests):                        true,
+		awserr.New("SlowDown", "Please reduce your request rate", nil): true,
+		awserr.New("AccessDenied", "Access Denied", nil):               false,
+		errors.New("not an S3 error"):                                  false,
+	}
+
+	for err, expected := range cases {
+		if retryableS3Error(err) != expected {
+			t.Errorf("Expected %v to be retryable: %v", err, expected)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+	An in-memory S3, so that the S3Store can be tested without any network
+*/
+
+type memoryS3 struct {
+	mutex   sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+func newMemoryS3(buckets ...string) *memoryS3 {
+	client := &memoryS3{buckets: make(map[string]map[string][]byte)}
+	for _, bucket := range buckets {
+		client.buckets[bucket] = make(map[string][]byte)
+	}
+
+	return client
+}
+
+// Presigning needs a real client, which the fake can't provide
+func (client *memoryS3) GetObjectRequest(in *s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput) {
+	panic("The in-memory S3 can't presign requests")
+}
+
+func (client *memoryS3) bucket(name *string) (map[string][]byte, error) {
+	objects, ok := client.buckets[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New("NoSuchBucket", "The specified bucket does not exist", nil)
+	}
+
+	return objects, nil
+}
+
+func (client *memoryS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	objects, err := client.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	objects[aws.StringValue(in.Key)] = data
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (client *memoryS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	objects, err := client.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := objects[aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New("NoSuchKey", "The specified key does not exist", nil)
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+// Lists the keys under the prefix in lexicographical order, in pages of up to 1000 keys, as S3 does
+func (client *memoryS3) ListObjectsWithContext(ctx aws.Context, in *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	objects, err := client.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range objects {
+		if strings.HasPrefix(key, aws.StringValue(in.Prefix)) && key > aws.StringValue(in.Marker) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsOutput{IsTruncated: aws.Bool(len(keys) > s3MaxKeys)}
+	if len(keys) > s3MaxKeys {
+		keys = keys[:s3MaxKeys]
+	}
+	for _, key := range keys {
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(key)})
+	}
+
+	return out, nil
+}
+
+func (client *memoryS3) DeleteObjectWithContext(ctx aws.Context, in *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	objects, err := client.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	delete(objects, aws.StringValue(in.Key))
+
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (client *memoryS3) DeleteObjectsWithContext(ctx aws.Context, in *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(in.Delete.Objects) > s3MaxKeys {
+		return nil, awserr.New("MalformedXML", "At most 1000 keys can be deleted at once", nil)
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	objects, err := client.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range in.Delete.Objects {
+		delete(objects, aws.StringValue(obj.Key))
+	}
+
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (client *memoryS3) HeadBucket(in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if _, err := client.bucket(in.Bucket); err != nil {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (client *memoryS3) CreateBucket(in *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if _, ok := client.buckets[aws.StringValue(in.Bucket)]; ok {
+		return nil, awserr.New("BucketAlreadyOwnedByYou", "The bucket already exists", nil)
+	}
+	client.buckets[aws.StringValue(in.Bucket)] = make(map[string][]byte)
+
+	return &s3.CreateBucketOutput{}, nil
+}
+
+// An S3Store backed by the in-memory S3, whose bucket is created as NewS3Store would
+func newMemoryS3Store(t *testing.T, keyPrefix string) (*S3Store, *memoryS3) {
+	client := newMemoryS3()
+	store := &S3Store{
+		MaxAttempts: 1,
+		client:      client,
+		bucket:      DefaultS3Bucket,
+		keyPrefix:   keyPrefix,
+		urlPattern:  "http://127.0.0.1/" + DefaultS3Bucket + "/" + keyPrefix + "%s",
+		opts:        Options{Format: JPEG},
+	}
+
+	if err := store.createBucket(); err != nil {
+		t.Fatalf("Unexpected error creating the bucket: %s", err)
+	}
+
+	return store, client
+}
+
+func TestS3StoreAgainstMemoryS3(t *testing.T) {
+	store, _ := newMemoryS3Store(t, "")
+	behavesLikeAStore(t, store)
+}
+
+func TestS3StoreAgainstMemoryS3ClearsEveryPage(t *testing.T) {
+	store, client := newMemoryS3Store(t, "fakelink/")
+	client.buckets[DefaultS3Bucket]["other-app/image"] = []byte("not ours")
+
+	img := generateRandomImageWithSize(2, 2)
+	for i := 0; i < 2*s3MaxKeys+1; i++ {
+		if _, err := store.Put(context.Background(), fmt.Sprintf("image-%04d", i), img); err != nil {
+			t.Fatalf("Unexpected error on image .Put: %s", err)
+		}
+	}
+
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatalf("Unexpected error on .Clear: %s", err)
+	}
+
+	objects := client.buckets[DefaultS3Bucket]
+	if len(objects) != 1 || objects["other-app/image"] == nil {
+		t.Errorf("Expected only the objects outside of the prefix to be left. Instead, %d objects were", len(objects))
+	}
+}
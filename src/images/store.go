@@ -2,25 +2,106 @@ package images
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/devlucky/fakelink/src/logs"
 	"image"
-	"image/jpeg"
-	"log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-// Store provides the repository interface for saving and retrieving images.
+// Store provides the repository interface for saving and retrieving images. The methods that reach the
+// backing storage give up on it as soon as their context is done. Keys and Clear only ever reach the store's own
+// images, those under its key prefix when it shares a bucket, so that nothing else is listed or deleted.
 type Store interface {
-	Put(key string, img image.Image) (url string, err error)
-	Get(key string) (img image.Image)
-	clear()
+	Put(ctx context.Context, key string, img image.Image) (url string, err error)
+	Get(ctx context.Context, key string) (img image.Image, err error)
+	GetURL(key string) (url string)
+	Delete(ctx context.Context, key string) error
+	Keys(ctx context.Context) ([]string, error)
+	Clear(ctx context.Context) error
+	Ping() error
 }
 
-// InMemoryStore is an in-memory implementation of the Store interface. Used for testing purposes.
+// ErrNotFound is returned by a Store when there is no image stored under the requested key.
+var ErrNotFound = errors.New("Image not found")
+
+// Sizer is implemented by the stores that downscale the images they are given, reporting the dimensions an image
+// ends up stored with.
+type Sizer interface {
+	StoredSize(img image.Image) (width, height int)
+}
+
+// StoredSize returns the dimensions the store keeps an image with: the image's own, unless the store downscales it.
+func StoredSize(store Store, img image.Image) (width, height int) {
+	if sizer, ok := store.(Sizer); ok {
+		return sizer.StoredSize(img)
+	}
+
+	bounds := img.Bounds()
+	return bounds.Dx(), bounds.Dy()
+}
+
+// Formatter is implemented by the stores that encode the images they are given, reporting the format an image is
+// stored in.
+type Formatter interface {
+	FormatOf(img image.Image) Format
+}
+
+// StoredFormat returns the format the store keeps an image in. Stores that keep decoded images are assumed to serve
+// them the way GET /images does: animations as GIFs, everything else as JPEGs.
+func StoredFormat(store Store, img image.Image) Format {
+	if formatter, ok := store.(Formatter); ok {
+		return formatter.FormatOf(img)
+	}
+
+	if _, ok := img.(*Animation); ok {
+		return GIF
+	}
+	return JPEG
+}
+
+// Signer is implemented by the stores whose URLs are only valid for a while once signed, as those of private buckets
+// are. GetURL then returns the unsigned URL, which links keep, and SignURL one valid for SignedFor, which is zero
+// when the store does not sign its URLs after all.
+type Signer interface {
+	SignURL(key string) string
+	SignedFor() time.Duration
+}
+
+// SignedURL returns the URL an image can be shown with right now: the signed one for stores that sign their URLs.
+func SignedURL(store Store, key string) string {
+	if signer, ok := store.(Signer); ok {
+		return signer.SignURL(key)
+	}
+
+	return store.GetURL(key)
+}
+
+// SignedFor returns how long the URLs given out by SignedURL stay valid, zero meaning forever.
+func SignedFor(store Store) time.Duration {
+	if signer, ok := store.(Signer); ok {
+		return signer.SignedFor()
+	}
+
+	return 0
+}
+
+// InMemoryStore is an in-memory implementation of the Store interface, safe for concurrent use. Used for testing purposes.
 type InMemoryStore struct {
+	mutex  sync.RWMutex
 	images map[string]image.Image
 }
 
@@ -32,127 +113,610 @@ func NewInMemoryStore() *InMemoryStore {
 }
 
 // Put adds a new image to the memory repository and return a fake URL.
-func (store *InMemoryStore) Put(key string, img image.Image) (url string, err error) {
+func (store *InMemoryStore) Put(ctx context.Context, key string, img image.Image) (url string, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	store.mutex.Lock()
 	store.images[key] = img
-	url = fmt.Sprintf("http://127.0.0.1/%s", key)
+	store.mutex.Unlock()
+
+	url = store.GetURL(key)
 	return
 }
 
 // Get retrieves an image from the repository.
-func (store *InMemoryStore) Get(key string) image.Image {
-	return store.images[key]
+func (store *InMemoryStore) Get(ctx context.Context, key string) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.mutex.RLock()
+	img, ok := store.images[key]
+	store.mutex.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return img, nil
+}
+
+// GetURL returns the fake URL of an image, pointing to the API's GET /images/:key endpoint.
+func (store *InMemoryStore) GetURL(key string) string {
+	return fmt.Sprintf("http://127.0.0.1/images/%s", key)
+}
+
+// Delete removes an image from the repository. Deleting a missing image is not an error.
+func (store *InMemoryStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	delete(store.images, key)
+	store.mutex.Unlock()
+	return nil
 }
 
-func (store *InMemoryStore) clear() {
+// Keys lists the keys of every image in the repository, in order.
+func (store *InMemoryStore) Keys(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	store.mutex.RLock()
+	keys := make([]string, 0, len(store.images))
+	for key := range store.images {
+		keys = append(keys, key)
+	}
+	store.mutex.RUnlock()
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Clear removes every image from the repository.
+func (store *InMemoryStore) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
 	store.images = make(map[string]image.Image)
+	store.mutex.Unlock()
+	return nil
+}
+
+// Ping always succeeds, as there is nothing to reach.
+func (store *InMemoryStore) Ping() error {
+	return nil
 }
 
 /*
 	Implementation of a Store based on AWS S3's API and SDK
 */
 
-const bucketName = "link-images"
+// DefaultS3Bucket is the bucket images are kept in when NewS3Store is given none.
+const DefaultS3Bucket = "link-images"
 
-// S3Store is an S3 based implementation of the Store interface.
+// The subset of the S3 API the store relies on, so that it can be faked in tests
+type s3API interface {
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+	ListObjectsWithContext(aws.Context, *s3.ListObjectsInput, ...request.Option) (*s3.ListObjectsOutput, error)
+	DeleteObjectWithContext(aws.Context, *s3.DeleteObjectInput, ...request.Option) (*s3.DeleteObjectOutput, error)
+	DeleteObjectsWithContext(aws.Context, *s3.DeleteObjectsInput, ...request.Option) (*s3.DeleteObjectsOutput, error)
+	GetObjectRequest(*s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput)
+	HeadBucket(*s3.HeadBucketInput) (*s3.HeadBucketOutput, error)
+	CreateBucket(*s3.CreateBucketInput) (*s3.CreateBucketOutput, error)
+}
+
+// S3Store is an S3 based implementation of the Store interface. Requests failing with a server error or
+// getting throttled are attempted up to MaxAttempts times, waiting Backoff and then twice as long each time.
 type S3Store struct {
-	client     *s3.S3
-	urlPattern string
+	MaxAttempts int
+	Backoff     time.Duration
+	client      s3API
+	bucket      string
+	keyPrefix   string
+	urlPattern  string
+	presign     bool
+	presignTTL  time.Duration
+	opts        Options
+	logger      *logs.Logger
+}
+
+const (
+	// DefaultS3Region is the region S3 is addressed in when S3Config has none.
+	DefaultS3Region = "us-east-1"
+	// DefaultS3PresignTTL is how long presigned URLs are valid for when S3Config does not say, the longest S3 allows.
+	DefaultS3PresignTTL = 7 * 24 * time.Hour
+)
+
+// S3Config describes how an S3Store reaches its bucket. Its zero values suit a local Minio, while AWS itself
+// usually needs its Region, SSL and VirtualHostedStyle, with an empty Host.
+type S3Config struct {
+	// When empty, the AWS endpoint for the region is used
+	Host         string
+	Port         string
+	AccessKey    string
+	AccessSecret string
+	// DefaultS3Bucket when empty
+	Bucket string
+	// Prepended to every key, so that the bucket can be shared with other applications
+	KeyPrefix string
+	// Public image URLs are made of it, the bucket (unless VirtualHostedStyle) and the key
+	PublicURL string
+	// DefaultS3Region when empty
+	Region string
+	SSL    bool
+	// Addresses the bucket as a subdomain of the host instead of as the first segment of the path
+	VirtualHostedStyle bool
+	// For private buckets, image URLs are presigned GET requests to the endpoint, valid for PresignTTL
+	// (DefaultS3PresignTTL when zero), instead of public URLs
+	Presign    bool
+	PresignTTL time.Duration
 }
 
-// NewS3Store creates a new S3Store based on the aws credentials.
-func NewS3Store(host, port, accessKey, accessSecret, publicURL string) *S3Store {
+// NewS3Store creates a new S3Store for the bucket described by the config, authenticated with its access key, which
+// encodes images with the given options and reports what happens while setting up the bucket to the logger. It fails
+// when the options are invalid or the bucket can neither be found nor created, which S3 may only do temporarily.
+func NewS3Store(config S3Config, opts Options, logger *logs.Logger) (*S3Store, error) {
+	return newS3Store(config, credentials.NewStaticCredentials(config.AccessKey, config.AccessSecret, ""), opts, logger)
+}
+
+// NewS3StoreWithDefaultCredentials is like NewS3Store, but ignores the config's access key and authenticates through
+// the default AWS credential chain instead: the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables,
+// the shared credentials file, and the ECS task or EC2 instance role. No long-lived keys need to be handed out then.
+func NewS3StoreWithDefaultCredentials(config S3Config, opts Options, logger *logs.Logger) (*S3Store, error) {
+	return newS3Store(config, nil, opts, logger)
+}
+
+// Without credentials, the session falls back to the default chain
+func newS3Store(config S3Config, creds *credentials.Credentials, opts Options, logger *logs.Logger) (*S3Store, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.Bucket == "" {
+		config.Bucket = DefaultS3Bucket
+	}
+	if config.Region == "" {
+		config.Region = DefaultS3Region
+	}
+	if config.PresignTTL == 0 {
+		config.PresignTTL = DefaultS3PresignTTL
+	}
+
 	s3Config := &aws.Config{
-		Credentials:      credentials.NewStaticCredentials(accessKey, accessSecret, ""),
-		Endpoint:         aws.String(fmt.Sprintf("http://%s:%s", host, port)),
-		Region:           aws.String("us-east-1"),
-		DisableSSL:       aws.Bool(true),
-		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      creds,
+		Region:           aws.String(config.Region),
+		DisableSSL:       aws.Bool(!config.SSL),
+		S3ForcePathStyle: aws.Bool(!config.VirtualHostedStyle),
+		// Retries are left to the store, so that they are not multiplied by the SDK's own
+		MaxRetries: aws.Int(0),
+	}
+	if endpoint := config.endpoint(); endpoint != "" {
+		s3Config.Endpoint = aws.String(endpoint)
+	}
+
+	sess, err := session.NewSession(s3Config)
+	if err != nil {
+		return nil, err
 	}
+
 	store := &S3Store{
-		client:     s3.New(session.New(s3Config)),
-		urlPattern: publicURL + "/" + bucketName + "/%s",
+		MaxAttempts: DefaultS3MaxAttempts,
+		Backoff:     DefaultS3Backoff,
+		client:      s3.New(sess),
+		bucket:      config.Bucket,
+		keyPrefix:   config.KeyPrefix,
+		urlPattern:  config.urlPattern(),
+		presign:     config.Presign,
+		presignTTL:  config.PresignTTL,
+		opts:        opts,
+		logger:      logger,
+	}
+
+	if err := store.createBucket(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Public URLs only hold the bucket in their path when it is not already part of the host
+func (config S3Config) urlPattern() string {
+	if config.VirtualHostedStyle {
+		return config.PublicURL + "/" + config.KeyPrefix + "%s"
+	}
+
+	return config.PublicURL + "/" + config.Bucket + "/" + config.KeyPrefix + "%s"
+}
+
+// Builds the endpoint out of the host and port, if any, with the scheme matching the SSL setting
+func (config S3Config) endpoint() string {
+	if config.Host == "" {
+		return ""
+	}
+
+	scheme := "http"
+	if config.SSL {
+		scheme = "https"
 	}
 
-	store.createBucket()
-	return store
+	if config.Port == "" {
+		return scheme + "://" + config.Host
+	}
+	return fmt.Sprintf("%s://%s:%s", scheme, config.Host, config.Port)
 }
 
-// Put uploads an image to AWS.
-func (store *S3Store) Put(key string, img image.Image) (url string, err error) {
+// Put encodes an image and uploads it to AWS.
+func (store *S3Store) Put(ctx context.Context, key string, img image.Image) (url string, err error) {
 	buf := new(bytes.Buffer)
-	err = jpeg.Encode(buf, img, nil)
+	err = store.opts.encode(buf, img)
 	if err != nil {
 		return
 	}
 
-	_, err = store.client.PutObject(&s3.PutObjectInput{
-		Body:   bytes.NewReader(buf.Bytes()),
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(key),
+	err = store.retry(ctx, func() error {
+		_, err := store.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Body:        bytes.NewReader(buf.Bytes()),
+			Bucket:      aws.String(store.bucket),
+			Key:         aws.String(store.keyPrefix + key),
+			ContentType: aws.String(store.opts.FormatOf(img).ContentType()),
+		})
+		return err
 	})
 	if err != nil {
 		return
 	}
 
-	url = fmt.Sprintf(store.urlPattern, key)
+	url = store.GetURL(key)
 	return
 }
 
 // Get retrieves an image from S3.
-func (store *S3Store) Get(key string) (img image.Image) {
-	out, err := store.client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(key),
+func (store *S3Store) Get(ctx context.Context, key string) (img image.Image, err error) {
+	var out *s3.GetObjectOutput
+	err = store.retry(ctx, func() (err error) {
+		out, err = store.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(store.bucket),
+			Key:    aws.String(store.keyPrefix + key),
+		})
+		return
 	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchKey" {
+		return nil, ErrNotFound
+	}
 	if err != nil {
-		log.Print("Unexpected error retrieving image from S3", err)
-		return nil
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	return Decode(out.Body)
+}
+
+// GetURL returns the public URL of an image in S3, which private buckets only serve once signed by SignURL.
+func (store *S3Store) GetURL(key string) string {
+	return fmt.Sprintf(store.urlPattern, key)
+}
+
+// SignURL returns, when presigning, a GET request for an image signed for SignedFor. Otherwise, or should signing
+// fail, the public URL is returned.
+func (store *S3Store) SignURL(key string) string {
+	if !store.presign {
+		return store.GetURL(key)
 	}
 
-	img, err = jpeg.Decode(out.Body)
+	req, _ := store.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(store.keyPrefix + key),
+	})
+
+	url, err := req.Presign(store.presignTTL)
 	if err != nil {
-		log.Print("Unexpected error decoding image retrieved from S3", err)
-		return nil
+		store.logger.Error("Presigning an image URL failed", err, logs.Fields{"key": key})
+		return store.GetURL(key)
 	}
 
-	return img
+	return url
+}
+
+// SignedFor returns how long presigned URLs are valid for, zero when not presigning.
+func (store *S3Store) SignedFor() time.Duration {
+	if !store.presign {
+		return 0
+	}
+
+	return store.presignTTL
+}
+
+// StoredSize returns the dimensions an image is stored with, once downscaled to the store's maximum ones.
+func (store *S3Store) StoredSize(img image.Image) (width, height int) {
+	return store.opts.storedSize(img)
 }
 
-func (store *S3Store) clear() {
-	out, err := store.client.ListObjects(&s3.ListObjectsInput{
-		Bucket: aws.String(bucketName),
+// FormatOf returns the format an image is stored in: GIF for animations, the configured one otherwise.
+func (store *S3Store) FormatOf(img image.Image) Format {
+	return store.opts.FormatOf(img)
+}
+
+// Delete removes an image from the bucket. Deleting a missing image is not an error.
+func (store *S3Store) Delete(ctx context.Context, key string) error {
+	return store.retry(ctx, func() error {
+		_, err := store.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(store.bucket),
+			Key:    aws.String(store.keyPrefix + key),
+		})
+		return err
 	})
+}
+
+// Keys lists the keys of every image in the bucket, leaving out the objects outside of the store's key prefix.
+func (store *S3Store) Keys(ctx context.Context) ([]string, error) {
+	objects, err := store.listObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = strings.TrimPrefix(aws.StringValue(obj.Key), store.keyPrefix)
+	}
+
+	return keys, nil
+}
+
+// Clear removes every image from the bucket, leaving alone the objects outside of the store's key prefix.
+func (store *S3Store) Clear(ctx context.Context) error {
+	objects, err := store.listObjects(ctx)
 	if err != nil {
-		log.Fatalf("Unexpected error listing all objects: %s", err)
+		return err
 	}
 
-	objects := make([]*s3.ObjectIdentifier, len(out.Contents))
+	for start := 0; start < len(objects); start += s3MaxKeys {
+		end := start + s3MaxKeys
+		if end > len(objects) {
+			end = len(objects)
+		}
 
-	for _, obj := range out.Contents {
-		objects = append(objects, &s3.ObjectIdentifier{Key: obj.Key})
+		batch := objects[start:end]
+		err = store.retry(ctx, func() error {
+			_, err := store.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(store.bucket),
+				Delete: &s3.Delete{Objects: batch},
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err = store.client.DeleteObjects(&s3.DeleteObjectsInput{
-		Bucket: aws.String(bucketName),
-		Delete: &s3.Delete{Objects: objects},
+	return nil
+}
+
+// Ping checks that the bucket is reachable.
+func (store *S3Store) Ping() error {
+	_, err := store.client.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(store.bucket),
 	})
-	if err != nil {
-		log.Fatalf("Unexpected error deleting all objects: %s", err)
+	return err
+}
+
+// S3 lists and deletes at most this many keys per request
+const s3MaxKeys = 1000
+
+// Lists every object under the key prefix, following the markers until the listing is no longer truncated
+func (store *S3Store) listObjects(ctx context.Context) ([]*s3.ObjectIdentifier, error) {
+	var objects []*s3.ObjectIdentifier
+	var marker *string
+
+	for {
+		var out *s3.ListObjectsOutput
+		err := store.retry(ctx, func() (err error) {
+			out, err = store.client.ListObjectsWithContext(ctx, &s3.ListObjectsInput{
+				Bucket: aws.String(store.bucket),
+				Prefix: aws.String(store.keyPrefix),
+				Marker: marker,
+			})
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range out.Contents {
+			objects = append(objects, &s3.ObjectIdentifier{Key: obj.Key})
+		}
+
+		if !aws.BoolValue(out.IsTruncated) || len(out.Contents) == 0 {
+			return objects, nil
+		}
+
+		// NextMarker is only returned when listing with a delimiter, otherwise the last key is the marker
+		marker = out.NextMarker
+		if marker == nil {
+			marker = out.Contents[len(out.Contents)-1].Key
+		}
 	}
 }
 
-func (store *S3Store) createBucket() {
+func (store *S3Store) createBucket() error {
 	_, err := store.client.HeadBucket(&s3.HeadBucketInput{
-		Bucket: aws.String(bucketName),
+		Bucket: aws.String(store.bucket),
 	})
+	if err == nil {
+		return nil
+	}
 
 	// If the bucket does not exist, we create it
+	_, err = store.client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(store.bucket),
+	})
 	if err != nil {
-		_, err = store.client.CreateBucket(&s3.CreateBucketInput{
-			Bucket: aws.String(bucketName),
-		})
+		return fmt.Errorf("Creating the S3 bucket %s failed: %s", store.bucket, err)
+	}
+
+	store.logger.Info("Created the S3 bucket", logs.Fields{"bucket": store.bucket})
+	return nil
+}
+
+/*
+	Implementation of a Store based on the local filesystem
+*/
+
+// FileStore is a filesystem based implementation of the Store interface.
+type FileStore struct {
+	baseDir    string
+	urlPattern string
+	opts       Options
+}
+
+// NewFileStore creates a new FileStore that keeps its images under baseDir, creating it if needed.
+// Images are encoded with the given options.
+func NewFileStore(baseDir, publicURL string, opts Options) (*FileStore, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	err := os.MkdirAll(baseDir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{
+		baseDir:    baseDir,
+		urlPattern: publicURL + "/%s",
+		opts:       opts,
+	}, nil
+}
+
+// Put encodes an image and writes it to the store's directory.
+func (store *FileStore) Put(ctx context.Context, key string, img image.Image) (url string, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = store.opts.encode(buf, img)
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(store.path(key), buf.Bytes(), 0644)
+	if err != nil {
+		return
+	}
+
+	url = store.GetURL(key)
+	return
+}
+
+// Get retrieves an image from the store's directory.
+func (store *FileStore) Get(ctx context.Context, key string) (img image.Image, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	file, err := os.Open(store.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Decode(file)
+}
+
+// GetURL returns the public URL of an image stored on disk.
+func (store *FileStore) GetURL(key string) string {
+	return fmt.Sprintf(store.urlPattern, key)
+}
+
+// StoredSize returns the dimensions an image is stored with, once downscaled to the store's maximum ones.
+func (store *FileStore) StoredSize(img image.Image) (width, height int) {
+	return store.opts.storedSize(img)
+}
+
+// FormatOf returns the format an image is stored in: GIF for animations, the configured one otherwise.
+func (store *FileStore) FormatOf(img image.Image) Format {
+	return store.opts.FormatOf(img)
+}
+
+// Delete removes an image from the store's directory. Deleting a missing image is not an error.
+func (store *FileStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := os.Remove(store.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Keys lists the keys of every image in the store's directory.
+func (store *FileStore) Keys(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(store.baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() {
+			keys = append(keys, file.Name())
+		}
+	}
+
+	return keys, nil
+}
+
+// Clear removes every image from the store's directory.
+func (store *FileStore) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(store.baseDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err = os.RemoveAll(filepath.Join(store.baseDir, file.Name()))
 		if err != nil {
-			log.Fatal("Unexpected error creating an S3 bucket", err)
+			return err
 		}
 	}
+
+	return nil
+}
+
+// Ping checks that the store's directory is still there.
+func (store *FileStore) Ping() error {
+	info, err := os.Stat(store.baseDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", store.baseDir)
+	}
+
+	return nil
+}
+
+func (store *FileStore) path(key string) string {
+	return filepath.Join(store.baseDir, filepath.Base(key))
 }
@@ -1,10 +1,29 @@
 package images
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/devlucky/fakelink/src/logs"
 	"github.com/satori/go.uuid"
+	"image"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 /*
@@ -12,24 +31,51 @@ import (
 */
 
 func behavesLikeAStore(t *testing.T, store Store) {
-	store.clear()
+	clearStore(t, store)
 	testGetMissing(t, store)
 
-	store.clear()
+	clearStore(t, store)
 	testPutAndGet(t, store)
+
+	clearStore(t, store)
+	testDelete(t, store)
+
+	clearStore(t, store)
+	testKeys(t, store)
+
+	clearStore(t, store)
+	testClear(t, store)
+
+	clearStore(t, store)
+	testCancelledGet(t, store)
+
+	clearStore(t, store)
+	testAnimation(t, store)
+
+	testPing(t, store)
+}
+
+func clearStore(t *testing.T, store Store) {
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatal("Unexpected error on .Clear", err)
+	}
 }
 
 func testGetMissing(t *testing.T, store Store) {
-	img := store.Get("missing")
+	img, err := store.Get(context.Background(), "missing")
 	if img != nil {
 		t.Error("Expected missing image to not be retrievable")
 	}
+
+	if err != ErrNotFound {
+		t.Errorf("Expected .Get on a missing image to fail with ErrNotFound. Instead, got %v", err)
+	}
 }
 
 func testPutAndGet(t *testing.T, store Store) {
 	img := generateRandomImage()
 
-	imgURLStr, err := store.Put("some-image", img)
+	imgURLStr, err := store.Put(context.Background(), "some-image", img)
 	if err != nil {
 		t.Fatal("Unexpected error on image .Put", err)
 	}
@@ -38,7 +84,15 @@ func testPutAndGet(t *testing.T, store Store) {
 		t.Errorf("Expected %s to be a proper URL", imgURLStr)
 	}
 
-	retrievedImg := store.Get("some-image")
+	if store.GetURL("some-image") != imgURLStr {
+		t.Errorf("Expected .GetURL to return the same URL .Put did. Instead, it returned %s", store.GetURL("some-image"))
+	}
+
+	retrievedImg, err := store.Get(context.Background(), "some-image")
+	if err != nil {
+		t.Fatal("Unexpected error on image .Get", err)
+	}
+
 	if retrievedImg == nil {
 		t.Error("Expected .Get image to retrieve the image we just saved")
 	}
@@ -48,26 +102,649 @@ func testPutAndGet(t *testing.T, store Store) {
 	}
 }
 
+func testDelete(t *testing.T, store Store) {
+	for _, key := range []string{"some-image", "other-image"} {
+		if _, err := store.Put(context.Background(), key, generateRandomImage()); err != nil {
+			t.Fatal("Unexpected error on image .Put", err)
+		}
+	}
+
+	if err := store.Delete(context.Background(), "some-image"); err != nil {
+		t.Fatal("Unexpected error on image .Delete", err)
+	}
+
+	if _, err := store.Get(context.Background(), "some-image"); err != ErrNotFound {
+		t.Error("Expected .Delete to remove the image")
+	}
+
+	if _, err := store.Get(context.Background(), "other-image"); err != nil {
+		t.Errorf("Expected .Delete to keep other images. Instead, got %v", err)
+	}
+
+	if err := store.Delete(context.Background(), "missing"); err != nil {
+		t.Errorf("Expected .Delete on a missing image not to fail. Instead, got %v", err)
+	}
+}
+
+func testKeys(t *testing.T, store Store) {
+	for _, key := range []string{"some-image", "another-image"} {
+		if _, err := store.Put(context.Background(), key, generateRandomImage()); err != nil {
+			t.Fatal("Unexpected error on image .Put", err)
+		}
+	}
+
+	keys, err := store.Keys(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error on .Keys", err)
+	}
+
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"another-image", "some-image"}) {
+		t.Errorf("Expected .Keys to list the stored images. Instead, got %v", keys)
+	}
+}
+
+func testClear(t *testing.T, store Store) {
+	if _, err := store.Put(context.Background(), "some-image", generateRandomImage()); err != nil {
+		t.Fatal("Unexpected error on image .Put", err)
+	}
+
+	clearStore(t, store)
+
+	if _, err := store.Get(context.Background(), "some-image"); err != ErrNotFound {
+		t.Error("Expected .Clear to remove all the stored images")
+	}
+}
+
 /*
 	All implementations comply with the expected behavior
 */
 
+func testCancelledGet(t *testing.T, store Store) {
+	if _, err := store.Put(context.Background(), "some-image", generateRandomImage()); err != nil {
+		t.Fatalf("Unexpected error on image .Put: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	img, err := store.Get(ctx, "some-image")
+	if err == nil || img != nil {
+		t.Error("Expected .Get to give up when its context is cancelled")
+	}
+}
+
+func testAnimation(t *testing.T, store Store) {
+	if _, err := store.Put(context.Background(), "some-animation", generateAnimation(16, 8, 3)); err != nil {
+		t.Fatalf("Unexpected error on animation .Put: %s", err)
+	}
+
+	img, err := store.Get(context.Background(), "some-animation")
+	if err != nil {
+		t.Fatalf("Unexpected error on animation .Get: %s", err)
+	}
+
+	anim, ok := img.(*Animation)
+	if !ok {
+		t.Fatalf("Expected an animation to be retrieved as such. Instead, got a %T", img)
+	}
+
+	if len(anim.GIF.Image) != 3 {
+		t.Fatalf("Expected all 3 frames to survive the round trip. Instead, got %d", len(anim.GIF.Image))
+	}
+
+	for i, frame := range anim.GIF.Image {
+		if frame.Pix[0] != uint8(i) {
+			t.Errorf("Expected frame %d to be kept as it was", i)
+		}
+	}
+}
+
+func testPing(t *testing.T, store Store) {
+	if err := store.Ping(); err != nil {
+		t.Errorf("Expected .Ping to succeed on a reachable store. Instead, got %s", err)
+	}
+}
+
 func TestInMemoryStore(t *testing.T) {
 	store := NewInMemoryStore()
 	behavesLikeAStore(t, store)
 }
 
+// Meant to be run with -race, which reports the unsynchronized accesses this would otherwise make
+func TestInMemoryStoreConcurrentAccess(t *testing.T) {
+	store := NewInMemoryStore()
+	img := generateRandomImage()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			key := fmt.Sprintf("image-%d", i%5)
+			store.Put(context.Background(), key, img)
+			store.Get(context.Background(), key)
+			if i%10 == 0 {
+				store.Delete(context.Background(), key)
+				store.Clear(context.Background())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	behavesLikeAStore(t, newTestFileStore(t, dir, Options{Format: JPEG}))
+	behavesLikeAStore(t, newTestFileStore(t, dir, Options{Format: PNG}))
+}
+
+func newTestFileStore(tb testing.TB, dir string, opts Options) *FileStore {
+	store, err := NewFileStore(dir, "http://127.0.0.1/images", opts)
+	if err != nil {
+		tb.Fatalf("Unexpected error creating the file store: %s", err)
+	}
+
+	return store
+}
+
+func TestFileStoreDownscalesOnPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newTestFileStore(t, dir, Options{Format: JPEG, MaxWidth: 10, MaxHeight: 10})
+	if _, err = store.Put(context.Background(), "some-image", generateRandomImageWithSize(40, 20)); err != nil {
+		t.Fatal("Unexpected error on image .Put", err)
+	}
+
+	img, err := store.Get(context.Background(), "some-image")
+	if err != nil {
+		t.Fatal("Unexpected error on image .Get", err)
+	}
+
+	if bounds := img.Bounds(); bounds.Dx() != 10 || bounds.Dy() != 5 {
+		t.Errorf("Expected the stored image to be downscaled to 10x5. Instead, it was %dx%d", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestStoredSizeMatchesWhatIsStored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newTestFileStore(t, dir, Options{Format: PNG, MaxWidth: 100, MaxHeight: 100})
+	for _, size := range []image.Point{{1200, 630}, {333, 1000}, {40, 20}} {
+		original := generateRandomImageWithSize(size.X, size.Y)
+		if _, err = store.Put(context.Background(), "some-image", original); err != nil {
+			t.Fatal("Unexpected error on image .Put", err)
+		}
+
+		img, err := store.Get(context.Background(), "some-image")
+		if err != nil {
+			t.Fatal("Unexpected error on image .Get", err)
+		}
+
+		width, height := StoredSize(store, original)
+		if bounds := img.Bounds(); bounds.Dx() != width || bounds.Dy() != height {
+			t.Errorf("Expected a %dx%d image to be reported with the %dx%d it was stored with. Instead, it was reported as %dx%d", size.X, size.Y, bounds.Dx(), bounds.Dy(), width, height)
+		}
+	}
+}
+
+func TestStoredSizeOfStoresThatDoNotResize(t *testing.T) {
+	width, height := StoredSize(NewInMemoryStore(), generateRandomImageWithSize(1200, 630))
+	if width != 1200 || height != 630 {
+		t.Errorf("Expected the memory store to keep images with their own dimensions. Instead, got %dx%d", width, height)
+	}
+}
+
+func TestFileStoreCreatesItsDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newTestFileStore(t, dir+"/nested/images", Options{Format: JPEG})
+	if _, err := os.Stat(dir + "/nested/images"); err != nil {
+		t.Errorf("Expected NewFileStore to create its base directory. Instead, got %s", err)
+	}
+}
+
+func TestNewFileStoreWithInvalidOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		t.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = NewFileStore(dir, "http://127.0.0.1/images", Options{Format: "bmp"}); err == nil {
+		t.Error("Expected invalid image options to fail the creation of a file store")
+	}
+}
+
+// The local Minio the S3 store is tested against
+func minioConfig() S3Config {
+	return S3Config{
+		Host:         os.Getenv("MINIO_HOST"),
+		Port:         os.Getenv("MINIO_PORT"),
+		AccessKey:    os.Getenv("MINIO_ACCESS_KEY"),
+		AccessSecret: os.Getenv("MINIO_SECRET_KEY"),
+		Bucket:       os.Getenv("MINIO_BUCKET"),
+		KeyPrefix:    os.Getenv("MINIO_KEY_PREFIX"),
+		PublicURL:    os.Getenv("MINIO_PUBLIC_URL"),
+	}
+}
+
 func TestS3Store(t *testing.T) {
-	store := NewS3Store(
-		os.Getenv("MINIO_HOST"),
-		os.Getenv("MINIO_PORT"),
-		os.Getenv("MINIO_ACCESS_KEY"),
-		os.Getenv("MINIO_SECRET_KEY"),
-		os.Getenv("MINIO_PUBLIC_URL"),
-	)
+	store, err := NewS3Store(minioConfig(), Options{Format: JPEG}, logs.New(os.Stderr))
+	if err != nil {
+		t.Fatalf("Unexpected error creating the S3 store: %s", err)
+	}
 	behavesLikeAStore(t, store)
 }
 
+/*
+	S3 specifics, tested against a fake client
+*/
+
+type fakeS3 struct {
+	s3API
+	listed        []*s3.Object
+	deleted       []*s3.ObjectIdentifier
+	deleteBatches int
+}
+
+// Lists the fake's objects under the requested prefix in pages of up to 1000 keys, as S3 does
+func (client *fakeS3) ListObjectsWithContext(ctx aws.Context, in *s3.ListObjectsInput, opts ...request.Option) (*s3.ListObjectsOutput, error) {
+	var listed []*s3.Object
+	for _, obj := range client.listed {
+		if strings.HasPrefix(*obj.Key, aws.StringValue(in.Prefix)) {
+			listed = append(listed, obj)
+		}
+	}
+
+	start := 0
+	if in.Marker != nil {
+		for i, obj := range listed {
+			if *obj.Key == *in.Marker {
+				start = i + 1
+			}
+		}
+	}
+
+	end := start + s3MaxKeys
+	if end > len(listed) {
+		end = len(listed)
+	}
+
+	return &s3.ListObjectsOutput{
+		Contents:    listed[start:end],
+		IsTruncated: aws.Bool(end < len(listed)),
+	}, nil
+}
+
+func (client *fakeS3) DeleteObjectsWithContext(ctx aws.Context, in *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	client.deleted = append(client.deleted, in.Delete.Objects...)
+	client.deleteBatches++
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func TestS3StoreClearDeletesExactlyTheListedKeys(t *testing.T) {
+	keys := []string{"one", "two", "three"}
+	client := &fakeS3{}
+	for _, key := range keys {
+		client.listed = append(client.listed, &s3.Object{Key: aws.String(key)})
+	}
+
+	store := &S3Store{client: client}
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatalf("Unexpected error on .Clear: %s", err)
+	}
+
+	if len(client.deleted) != len(keys) {
+		t.Fatalf("Expected %d objects to be deleted. Instead, %d were requested", len(keys), len(client.deleted))
+	}
+
+	for i, obj := range client.deleted {
+		if obj == nil || aws.StringValue(obj.Key) != keys[i] {
+			t.Errorf("Expected deleted object %d to be %s. Instead, it was %v", i, keys[i], obj)
+		}
+	}
+}
+
+func TestS3StoreClearPaginates(t *testing.T) {
+	client := &fakeS3{}
+	for i := 0; i < 2500; i++ {
+		client.listed = append(client.listed, &s3.Object{Key: aws.String(fmt.Sprintf("image-%04d", i))})
+	}
+
+	store := &S3Store{client: client}
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatalf("Unexpected error on .Clear: %s", err)
+	}
+
+	if len(client.deleted) != 2500 {
+		t.Errorf("Expected all 2500 objects to be deleted. Instead, %d were", len(client.deleted))
+	}
+
+	if client.deleteBatches != 3 {
+		t.Errorf("Expected deletions to be issued in 3 batches. Instead, they took %d", client.deleteBatches)
+	}
+}
+
+func TestS3StoreClearOnEmptyBucket(t *testing.T) {
+	store := &S3Store{client: &fakeS3{}}
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatalf("Unexpected error on .Clear: %s", err)
+	}
+}
+
+type unreachableS3 struct {
+	s3API
+}
+
+func (client *unreachableS3) HeadBucket(in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (client *unreachableS3) CreateBucket(in *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	return nil, errors.New("service unavailable")
+}
+
+func TestS3StorePing(t *testing.T) {
+	store := &S3Store{client: &unreachableS3{}}
+	if err := store.Ping(); err == nil {
+		t.Error("Expected .Ping to fail when the bucket cannot be reached")
+	}
+}
+
+func TestS3StoreCreateBucketFailure(t *testing.T) {
+	store := &S3Store{client: &unreachableS3{}}
+	if err := store.createBucket(); err == nil {
+		t.Error("Expected failing to create the bucket to be returned as an error")
+	}
+}
+
+type missingBucketS3 struct {
+	unreachableS3
+	created bool
+}
+
+func (client *missingBucketS3) CreateBucket(in *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	client.created = true
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func TestS3StoreCreatesAMissingBucket(t *testing.T) {
+	client := &missingBucketS3{}
+	store := &S3Store{client: client}
+	if err := store.createBucket(); err != nil {
+		t.Fatalf("Unexpected error creating the bucket: %s", err)
+	}
+
+	if !client.created {
+		t.Error("Expected the missing bucket to be created")
+	}
+}
+
+func TestS3StoreBucket(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the server's URL: %s", err)
+	}
+
+	for bucket, expected := range map[string]string{"": DefaultS3Bucket, "tenant-images": "tenant-images"} {
+		paths = nil
+		store, err := NewS3Store(S3Config{Host: host, Port: port, AccessKey: "key", AccessSecret: "secret", Bucket: bucket, PublicURL: "http://127.0.0.1"}, Options{Format: JPEG}, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error creating the S3 store: %s", err)
+		}
+
+		if _, err = store.Put(context.Background(), "some-image", generateRandomImage()); err != nil {
+			t.Fatalf("Unexpected error on image .Put: %s", err)
+		}
+
+		if len(paths) != 2 || paths[0] != "HEAD /"+expected || paths[1] != "PUT /"+expected+"/some-image" {
+			t.Errorf("Expected the store to use the %s bucket. Instead, it requested %v", expected, paths)
+		}
+
+		if imageURL := store.GetURL("some-image"); imageURL != "http://127.0.0.1/"+expected+"/some-image" {
+			t.Errorf("Expected the image URL to point to the %s bucket. Instead, it was %s", expected, imageURL)
+		}
+	}
+}
+
+func TestS3StoreClearOnlyDeletesObjectsUnderItsPrefix(t *testing.T) {
+	client := &fakeS3{}
+	for _, key := range []string{"fakelink/one", "other-app/two", "fakelink/three", "fakelink-old/four"} {
+		client.listed = append(client.listed, &s3.Object{Key: aws.String(key)})
+	}
+
+	store := &S3Store{client: client, keyPrefix: "fakelink/"}
+	if err := store.Clear(context.Background()); err != nil {
+		t.Fatalf("Unexpected error on .Clear: %s", err)
+	}
+
+	if len(client.deleted) != 2 || aws.StringValue(client.deleted[0].Key) != "fakelink/one" || aws.StringValue(client.deleted[1].Key) != "fakelink/three" {
+		t.Errorf("Expected only the objects under the prefix to be deleted. Instead, %v were", client.deleted)
+	}
+}
+
+func TestS3StoreKeyPrefix(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the server's URL: %s", err)
+	}
+
+	store, err := NewS3Store(S3Config{Host: host, Port: port, AccessKey: "key", AccessSecret: "secret", Bucket: "shared", KeyPrefix: "fakelink/", PublicURL: "http://127.0.0.1"}, Options{Format: JPEG}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error creating the S3 store: %s", err)
+	}
+
+	if _, err = store.Put(context.Background(), "some-image", generateRandomImage()); err != nil {
+		t.Fatalf("Unexpected error on image .Put: %s", err)
+	}
+	store.Delete(context.Background(), "some-image")
+
+	if len(paths) != 3 || paths[1] != "PUT /shared/fakelink/some-image" || paths[2] != "DELETE /shared/fakelink/some-image" {
+		t.Errorf("Expected the objects' keys to start with the prefix. Instead, the store requested %v", paths)
+	}
+
+	if imageURL := store.GetURL("some-image"); imageURL != "http://127.0.0.1/shared/fakelink/some-image" {
+		t.Errorf("Expected the image URL to include the prefix. Instead, it was %s", imageURL)
+	}
+}
+
+func TestS3StoreRegion(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the server's URL: %s", err)
+	}
+
+	for region, expected := range map[string]string{"": DefaultS3Region, "us-west-2": "us-west-2"} {
+		authorizations = nil
+		if _, err := NewS3Store(S3Config{Host: host, Port: port, AccessKey: "key", AccessSecret: "secret", Region: region}, Options{Format: JPEG}, nil); err != nil {
+			t.Fatalf("Unexpected error creating the S3 store: %s", err)
+		}
+
+		if len(authorizations) != 1 || !strings.Contains(authorizations[0], "/"+expected+"/s3/") {
+			t.Errorf("Expected requests to be signed for %s. Instead, they were signed with %v", expected, authorizations)
+		}
+	}
+}
+
+func TestS3StoreWithDefaultCredentials(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	host, port, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the server's URL: %s", err)
+	}
+
+	// The environment comes first in the default credential chain
+	os.Setenv("AWS_ACCESS_KEY_ID", "role-key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "role-secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	config := S3Config{Host: host, Port: port, AccessKey: "static-key", AccessSecret: "static-secret"}
+	if _, err := NewS3StoreWithDefaultCredentials(config, Options{Format: JPEG}, nil); err != nil {
+		t.Fatalf("Unexpected error creating the S3 store: %s", err)
+	}
+
+	if len(authorizations) != 1 || !strings.Contains(authorizations[0], "Credential=role-key/") {
+		t.Errorf("Expected requests to be signed with the default credentials, ignoring the static ones. Instead, they were signed with %v", authorizations)
+	}
+}
+
+func TestS3StorePresignedURL(t *testing.T) {
+	store := &S3Store{
+		client: s3.New(session.New(&aws.Config{
+			Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+			Endpoint:         aws.String("https://s3.example.com"),
+			Region:           aws.String("us-west-2"),
+			S3ForcePathStyle: aws.Bool(true),
+		})),
+		bucket:     "private",
+		keyPrefix:  "fakelink/",
+		urlPattern: "https://s3.example.com/private/fakelink/%s",
+		presign:    true,
+		presignTTL: time.Hour,
+	}
+
+	if unsigned := store.GetURL("some-image"); unsigned != "https://s3.example.com/private/fakelink/some-image" {
+		t.Errorf("Expected GetURL to return the unsigned URL, for links to keep. Instead, got %s", unsigned)
+	}
+
+	signed, err := url.Parse(store.SignURL("some-image"))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the presigned URL: %s", err)
+	}
+
+	if signed.Host != "s3.example.com" || signed.Path != "/private/fakelink/some-image" {
+		t.Errorf("Expected the presigned URL to point to the image. Instead, it was %s", signed)
+	}
+
+	query := signed.Query()
+	if query.Get("X-Amz-Signature") == "" || !strings.HasPrefix(query.Get("X-Amz-Credential"), "key/") || query.Get("X-Amz-Expires") != "3600" {
+		t.Errorf("Expected the URL to be signed for an hour. Instead, it was %s", signed)
+	}
+
+	if store.SignedFor() != time.Hour {
+		t.Errorf("Expected the URLs to be reported as signed for an hour. Instead, they were for %s", store.SignedFor())
+	}
+
+	store.presign = false
+	if unsigned := store.SignURL("some-image"); unsigned != "https://s3.example.com/private/fakelink/some-image" || store.SignedFor() != 0 {
+		t.Errorf("Expected the public URL, which never expires, when not presigning. Instead, got %s", unsigned)
+	}
+}
+
+func TestS3ConfigEndpoint(t *testing.T) {
+	endpoints := map[string]S3Config{
+		"http://minio:9000":        {Host: "minio", Port: "9000"},
+		"https://minio:9000":       {Host: "minio", Port: "9000", SSL: true},
+		"https://s3.amazonaws.com": {Host: "s3.amazonaws.com", SSL: true},
+		"":                         {Region: "us-west-2", SSL: true},
+	}
+
+	for expected, config := range endpoints {
+		if endpoint := config.endpoint(); endpoint != expected {
+			t.Errorf("Expected the endpoint of %+v to be %q. Instead, it was %q", config, expected, endpoint)
+		}
+	}
+}
+
+func TestS3ConfigURLPattern(t *testing.T) {
+	pathStyle := S3Config{Bucket: "images", KeyPrefix: "fakelink/", PublicURL: "http://localhost:9000"}
+	if pattern := pathStyle.urlPattern(); pattern != "http://localhost:9000/images/fakelink/%s" {
+		t.Errorf("Expected path style URLs to hold the bucket. Instead, the pattern was %s", pattern)
+	}
+
+	virtualHosted := S3Config{Bucket: "images", KeyPrefix: "fakelink/", PublicURL: "https://images.s3.amazonaws.com", VirtualHostedStyle: true}
+	if pattern := virtualHosted.urlPattern(); pattern != "https://images.s3.amazonaws.com/fakelink/%s" {
+		t.Errorf("Expected virtual hosted style URLs to leave the bucket to the host. Instead, the pattern was %s", pattern)
+	}
+}
+
+func TestS3StoreGetIsAbortedByItsContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	store := &S3Store{
+		MaxAttempts: DefaultS3MaxAttempts,
+		Backoff:     DefaultS3Backoff,
+		client: s3.New(session.New(&aws.Config{
+			Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+			Endpoint:         aws.String(server.URL),
+			Region:           aws.String("us-east-1"),
+			S3ForcePathStyle: aws.Bool(true),
+			MaxRetries:       aws.Int(0),
+		})),
+		opts: Options{Format: JPEG},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		_, err := store.Get(ctx, "slow-image")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected .Get to fail once its context is done")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected .Get to be aborted by its context. Instead, it kept waiting for S3")
+	}
+}
+
 /*
 	BENCHMARKS
 */
@@ -78,8 +755,8 @@ func benchmarkStore(b *testing.B, store Store) {
 
 	for i := 0; i < b.N; i++ {
 		key := uuid.NewV4().String()
-		store.Put(key, img)
-		store.Get(key)
+		store.Put(context.Background(), key, img)
+		store.Get(context.Background(), key)
 	}
 }
 
@@ -88,13 +765,20 @@ func BenchmarkInMemoryStore(b *testing.B) {
 	benchmarkStore(b, store)
 }
 
+func BenchmarkFileStore(b *testing.B) {
+	dir, err := ioutil.TempDir("", "fakelink-images")
+	if err != nil {
+		b.Fatalf("Unexpected error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	benchmarkStore(b, newTestFileStore(b, dir, Options{Format: JPEG}))
+}
+
 func BenchmarkS3Store(b *testing.B) {
-	store := NewS3Store(
-		os.Getenv("MINIO_HOST"),
-		os.Getenv("MINIO_PORT"),
-		os.Getenv("MINIO_ACCESS_KEY"),
-		os.Getenv("MINIO_SECRET_KEY"),
-		os.Getenv("MINIO_PUBLIC_URL"),
-	)
+	store, err := NewS3Store(minioConfig(), Options{Format: JPEG}, logs.New(os.Stderr))
+	if err != nil {
+		b.Fatalf("Unexpected error creating the S3 store: %s", err)
+	}
 	benchmarkStore(b, store)
 }
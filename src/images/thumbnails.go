@@ -6,6 +6,11 @@ import (
 )
 
 // Thumbnail returns a thumbail of the given image resized to the given dimensions.
-func Thumbnail(img image.Image, maxWidth, maxHeight int) *image.NRGBA {
+// Animations keep all their frames, each one being resized.
+func Thumbnail(img image.Image, maxWidth, maxHeight int) image.Image {
+	if anim, ok := img.(*Animation); ok {
+		return anim.fit(maxWidth, maxHeight)
+	}
+
 	return imaging.Fit(img, maxWidth, maxHeight, imaging.Lanczos)
 }
@@ -3,11 +3,27 @@ package links
 import (
 	"github.com/devlucky/fakelink/src/templates"
 	"math/rand"
+	"sync"
+	"time"
 )
 
+// The global math/rand source is unseeded, which would give the same sequence on every restart
+var (
+	random      = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomMutex sync.Mutex
+)
+
+// Returns a random integer in [0, n), safe for concurrent use
+func randomIntn(n int) int {
+	randomMutex.Lock()
+	defer randomMutex.Unlock()
+
+	return random.Intn(n)
+}
+
 // RandomLink returns a random Link with values from a defined set of mocks.
 func RandomLink() *Link {
-	return ExampleLinks[rand.Int()%len(ExampleLinks)]
+	return ExampleLinks[randomIntn(len(ExampleLinks))]
 }
 
 // ExampleLinks contains a list of mocked links to be used as examples.
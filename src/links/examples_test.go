@@ -19,3 +19,14 @@ func TestRandomLink(t *testing.T) {
 		t.Error("Expected RandomLink to provide random links")
 	}
 }
+
+func TestRandomLinkDistribution(t *testing.T) {
+	seen := make(map[*Link]bool)
+	for i := 0; i < 100; i++ {
+		seen[RandomLink()] = true
+	}
+
+	if len(seen) < 2 {
+		t.Errorf("Expected RandomLink to cover more than one example over 100 calls. Instead, it covered %d", len(seen))
+	}
+}
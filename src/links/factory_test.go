@@ -0,0 +1,39 @@
+package links
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewStore(t *testing.T) {
+	cases := map[string]Store{
+		"memory": &InMemoryStore{},
+		"redis":  &RedisStore{},
+	}
+
+	for storeType, expected := range cases {
+		store, err := NewStore(StoreConfig{Type: storeType, RedisHost: "127.0.0.1", RedisPort: "6379"})
+		if err != nil {
+			t.Errorf("Unexpected error building a %s store: %s", storeType, err)
+			continue
+		}
+
+		if reflect.TypeOf(store) != reflect.TypeOf(expected) {
+			t.Errorf("Expected a %s store to be a %T. Instead, it was a %T", storeType, expected, store)
+		}
+	}
+}
+
+func TestNewStoreWithUnknownType(t *testing.T) {
+	for _, storeType := range []string{"", "mongo"} {
+		if store, err := NewStore(StoreConfig{Type: storeType}); store != nil || err != ErrUnknownStoreType {
+			t.Errorf("Expected %q to be an unknown store type. Instead, got %v", storeType, err)
+		}
+	}
+}
+
+func TestStoreTypes(t *testing.T) {
+	if types := StoreTypes(); !reflect.DeepEqual(types, []string{"memory", "postgres", "redis"}) {
+		t.Errorf("Expected the built-in store types. Instead, got %v", types)
+	}
+}
@@ -0,0 +1,59 @@
+package links
+
+import (
+	"net/url"
+	"strings"
+)
+
+// HostMatches reports whether a host matches a pattern, either a host name such as example.com, matching only
+// itself, or a wildcard such as *.example.com, matching its subdomains at any depth but not example.com itself.
+// Both are compared case insensitively, trailing dots aside.
+func HostMatches(host, pattern string) bool {
+	host = normalizeHost(host)
+	pattern = normalizeHost(pattern)
+	if host == "" || pattern == "" {
+		return false
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return host == pattern
+}
+
+// HostAllowed reports whether links may point to a URL's host: it must match none of the blocked patterns and,
+// unless there are none, one of the allowed ones. Paths on our own domain, such as /about, are always allowed.
+func HostAllowed(rawURL string, allowed, blocked []string) bool {
+	if strings.HasPrefix(rawURL, "/") && !strings.HasPrefix(rawURL, "//") {
+		return true
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := parsed.Hostname()
+
+	for _, pattern := range blocked {
+		if HostMatches(host, pattern) {
+			return false
+		}
+	}
+
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, pattern := range allowed {
+		if HostMatches(host, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
@@ -0,0 +1,80 @@
+package links
+
+import (
+	"testing"
+)
+
+func TestHostMatches(t *testing.T) {
+	cases := []struct {
+		host, pattern string
+		matches       bool
+	}{
+		{"example.com", "example.com", true},
+		{"Example.COM", "example.com", true},
+		{"example.com.", "example.com", true},
+		{"www.example.com", "example.com", false},
+		{"www.example.com", "*.example.com", true},
+		{"a.b.example.com", "*.example.com", true},
+		{"example.com", "*.example.com", false},
+		{"badexample.com", "*.example.com", false},
+		{"example.com.evil.org", "example.com", false},
+		{"", "example.com", false},
+		{"example.com", "", false},
+	}
+
+	for _, c := range cases {
+		if HostMatches(c.host, c.pattern) != c.matches {
+			t.Errorf("Expected HostMatches(%q, %q) to be %t", c.host, c.pattern, c.matches)
+		}
+	}
+}
+
+func TestHostAllowedWithBlockedHosts(t *testing.T) {
+	blocked := []string{"evil.org", "*.phishing.net"}
+
+	for _, url := range []string{"http://evil.org/login", "https://bank.phishing.net", "https://EVIL.org:8443/"} {
+		if HostAllowed(url, nil, blocked) {
+			t.Errorf("Expected %s to be blocked", url)
+		}
+	}
+
+	for _, url := range []string{"http://example.com", "https://notevil.org", "https://phishing.net.example.com"} {
+		if !HostAllowed(url, nil, blocked) {
+			t.Errorf("Expected %s not to be blocked", url)
+		}
+	}
+}
+
+func TestHostAllowedWithAllowedHosts(t *testing.T) {
+	allowed := []string{"example.com", "*.example.com"}
+
+	for _, url := range []string{"http://example.com", "https://shop.example.com/sale"} {
+		if !HostAllowed(url, allowed, nil) {
+			t.Errorf("Expected %s to be allowed", url)
+		}
+	}
+
+	for _, url := range []string{"http://example.org", "https://example.com.evil.org", "//example.org/path"} {
+		if HostAllowed(url, allowed, nil) {
+			t.Errorf("Expected %s not to be allowed", url)
+		}
+	}
+}
+
+func TestHostAllowedWithPathsOnOurDomain(t *testing.T) {
+	if !HostAllowed("/about", []string{"example.com"}, nil) {
+		t.Error("Expected paths on our own domain to be allowed")
+	}
+}
+
+func TestHostAllowedPrefersBlockedHosts(t *testing.T) {
+	if HostAllowed("https://evil.example.com", []string{"*.example.com"}, []string{"evil.example.com"}) {
+		t.Error("Expected blocked hosts to win over allowed ones")
+	}
+}
+
+func TestHostAllowedWithoutPatterns(t *testing.T) {
+	if !HostAllowed("http://anything.example.org", nil, nil) {
+		t.Error("Expected every host to be allowed when no patterns are configured")
+	}
+}
@@ -1,25 +1,163 @@
 package links
 
 import (
-	"errors"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"github.com/devlucky/fakelink/src/templates"
-	"github.com/extemporalgenome/slug"
 	"github.com/satori/go.uuid"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // A Link represents a certain template version and values. They are user-generated
 type Link struct {
-	Private bool             `json:"private"`
-	Values  templates.Values `json:"values"`
+	Private      bool             `json:"private"`
+	Values       templates.Values `json:"values"`
+	TemplateName string           `json:"template_name,omitempty"`
+	ExpiresAt    *time.Time       `json:"expires_at,omitempty"`
 }
 
-// NewLink creates a new Link from its template values.
+// ExpiredRetention is how long stores keep links after they expire, so that they can be reported as gone
+// rather than as missing. Stores may drop them afterwards
+const ExpiredRetention = 24 * time.Hour
+
+// Expired reports whether the link had an expiration date which has already passed.
+func (link *Link) Expired() bool {
+	return link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt)
+}
+
+// How long a store should keep the link around, zero meaning forever
+func (link *Link) retention() time.Duration {
+	if link.ExpiresAt == nil {
+		return 0
+	}
+
+	retention := link.ExpiresAt.Sub(time.Now()) + ExpiredRetention
+	if retention < time.Second {
+		retention = time.Second
+	}
+
+	return retention
+}
+
+// Whether a store should still have the link, its retention not having ended
+func (link *Link) retained() bool {
+	return link.ExpiresAt == nil || time.Now().Before(link.ExpiresAt.Add(ExpiredRetention))
+}
+
+// FieldError is the reason one of a link's values is invalid. Field is the value's JSON key, such as "url"
+// or "images[1].width".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid value of a link.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (err *ValidationError) Error() string {
+	messages := make([]string, len(err.Fields))
+	for i, field := range err.Fields {
+		messages[i] = field.Field + ": " + field.Message
+	}
+
+	return "Invalid link values: " + strings.Join(messages, "; ")
+}
+
+// Collects the problems found while validating a link, keeping only the first one of each field
+type validation struct {
+	fields []FieldError
+}
+
+func (v *validation) fail(field, format string, args ...interface{}) {
+	for _, existing := range v.fields {
+		if existing.Field == field {
+			return
+		}
+	}
+
+	v.fields = append(v.fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validation) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Fields: v.fields}
+}
+
+// DefaultType is the og:type of links that don't specify one
+const DefaultType = "website"
+
+// DefaultMaxDescriptionLength is the number of characters descriptions are truncated to when none is specified.
+const DefaultMaxDescriptionLength = 300
+
+// NewLink creates a new Link from its template values, of the DefaultType unless they say otherwise. Links without
+// a site name are named after the host of their URL, and relative image URLs are resolved against it. Descriptions
+// are truncated to DefaultMaxDescriptionLength characters. When some of the values are invalid, a *ValidationError
+// listing every one of them is returned.
 func NewLink(values templates.Values, private bool) (*Link, error) {
+	return NewLinkWithMaxDescription(values, private, DefaultMaxDescriptionLength)
+}
+
+// NewLinkWithMaxDescription creates a new Link the way NewLink does, truncating its description to the given number
+// of characters instead, or to DefaultMaxDescriptionLength when it is not positive.
+func NewLinkWithMaxDescription(values templates.Values, private bool, maxDescriptionLength int) (*Link, error) {
+	v := &validation{}
+
 	if values.Title == "" {
-		return nil, errors.New("A link's title is mandatory")
+		v.fail("title", "required")
+	}
+
+	if values.Type == "" {
+		values.Type = DefaultType
+	}
+	if values.SiteName == "" {
+		values.SiteName = siteNameFromURL(values)
+	}
+	if maxDescriptionLength <= 0 {
+		maxDescriptionLength = DefaultMaxDescriptionLength
+	}
+	values.Description = templates.Truncate(values.Description, maxDescriptionLength)
+	values.Image = resolveImageURL(v, "image", values.URL, values.Image)
+	if len(values.Images) > 0 {
+		resolved := make([]templates.Image, len(values.Images))
+		for i, image := range values.Images {
+			image.URL = resolveImageURL(v, fmt.Sprintf("images[%d].url", i), values.URL, image.URL)
+			resolved[i] = image
+		}
+		values.Images = resolved
+	}
+
+	validateText(v, values)
+	validateType(v, values.Type)
+	validateTwitterCard(v, values.TwitterCard)
+	validateDeterminer(v, values.Determiner)
+	validateLinkURL(v, "url", values.URL)
+	validateLinkURL(v, "canonical_url", values.CanonicalURL)
+	validateURL(v, "target_url", values.TargetURL)
+	validateURL(v, "image", values.Image)
+
+	for i, image := range values.Images {
+		validateImage(v, fmt.Sprintf("images[%d]", i), image)
+	}
+
+	validateVideo(v, values.Video)
+	validateAudio(v, values.Audio)
+	validateArticle(v, values.Article)
+	validateLocales(v, values)
+
+	if err := v.err(); err != nil {
+		return nil, err
 	}
 
 	link := &Link{
@@ -29,9 +167,341 @@ func NewLink(values templates.Values, private bool) (*Link, error) {
 	return link, nil
 }
 
-func generateSlug(link *Link) string {
-	s := fmt.Sprintf("%.80s-%s.6", slug.Slug(link.Values.Title), uuid.NewV4().String())
+// Values end up in the attributes of the rendered meta tags. The template escapes them, but control characters
+// such as newlines have no business in there and are rejected, so that they can't break out of a tag either
+func validateText(v *validation, values templates.Values) {
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"title", values.Title},
+		{"description", values.Description},
+		{"site_name", values.SiteName},
+		{"type", values.Type},
+		{"url", values.URL},
+		{"canonical_url", values.CanonicalURL},
+		{"target_url", values.TargetURL},
+		{"image", values.Image},
+		{"twitter_card", values.TwitterCard},
+	}
+
+	for _, field := range fields {
+		if containsControl(field.value) {
+			v.fail(field.name, "can't contain control characters")
+		}
+	}
+}
+
+func containsControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) != -1
+}
+
+// The og:type values of the Open Graph vocabulary, see http://ogp.me/#types. Types may come with their own
+// properties (music:duration, book:isbn, profile:username...), which links don't carry: they are rendered
+// with the generic tags only, along with og:video and og:audio when present
+var openGraphTypes = []string{
+	"website",
+	"article",
+	"book",
+	"profile",
+	"video",
+	"video.movie",
+	"video.episode",
+	"video.tv_show",
+	"video.other",
+	"music",
+	"music.song",
+	"music.album",
+	"music.playlist",
+	"music.radio_station",
+}
+
+// IsOpenGraphType reports whether a type is one of the og:type values links may have.
+func IsOpenGraphType(ogType string) bool {
+	for _, known := range openGraphTypes {
+		if known == ogType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Typos are common enough that unknown types close to a known one suggest it
+func validateType(v *validation, ogType string) {
+	if IsOpenGraphType(ogType) {
+		return
+	}
+
+	if suggestion := closestType(ogType); suggestion != "" {
+		v.fail("type", "%q is not an Open Graph type, did you mean %q?", ogType, suggestion)
+		return
+	}
+
+	v.fail("type", "%q is not an Open Graph type, which are %s", ogType, strings.Join(openGraphTypes, ", "))
+}
+
+// Types this many edits away from a known one, or fewer, are taken as typos of it
+const maxTypeTypoDistance = 2
+
+func closestType(ogType string) string {
+	closest, closestDistance := "", maxTypeTypoDistance+1
+	for _, known := range openGraphTypes {
+		if distance := editDistance(strings.ToLower(ogType), known); distance < closestDistance {
+			closest, closestDistance = known, distance
+		}
+	}
+
+	return closest
+}
+
+// The Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			substitution := previous[j-1]
+			if a[i-1] != b[j-1] {
+				substitution++
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, substitution))
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// See https://developer.twitter.com/en/docs/twitter-for-websites/cards/overview/markup
+var twitterCards = map[string]bool{"summary": true, "summary_large_image": true, "app": true, "player": true}
+
+// The Twitter card is optional, but when overridden it must be one of the types Twitter knows about
+func validateTwitterCard(v *validation, card string) {
+	if card != "" && !twitterCards[card] {
+		v.fail("twitter_card", "must be summary, summary_large_image, app or player, but it was %q", card)
+	}
+}
+
+var determiners = map[string]bool{"a": true, "an": true, "the": true, "auto": true}
+
+// The og:determiner, the word before the title in a sentence, is optional, but when set it must be one Open Graph knows
+func validateDeterminer(v *validation, determiner string) {
+	if determiner != "" && !determiners[determiner] {
+		v.fail("determiner", "must be a, an, the or auto, but it was %q", determiner)
+	}
+}
+
+// The host of the link's URL, or of its target URL, without a www. prefix. Paths on our own domain have none
+func siteNameFromURL(values templates.Values) string {
+	for _, raw := range []string{values.URL, values.TargetURL} {
+		parsed, err := url.Parse(raw)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+
+		return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	}
+
+	return ""
+}
+
+// The link's own URLs may also be paths on our domain, which the rendered page resolves against the public base URL.
+// The target URL visitors are redirected to can't, as it must point elsewhere
+func validateLinkURL(v *validation, field, raw string) {
+	if strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//") {
+		if _, err := url.Parse(raw); err != nil {
+			v.fail(field, "is not a valid URL: %s", err)
+		}
+		return
+	}
+
+	validateURL(v, field, raw)
+}
+
+// Images relative to the page, such as /images/poster.jpg or //cdn.example.com/poster.jpg, are made absolute with
+// the link's URL, which has to be an absolute one. Absolute and invalid URLs are left for validateURL to check
+func resolveImageURL(v *validation, field, base, raw string) string {
+	ref, err := url.Parse(raw)
+	if err != nil || raw == "" || ref.IsAbs() {
+		return raw
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil || !baseURL.IsAbs() || baseURL.Host == "" {
+		v.fail(field, "is relative, which requires the link's url to be an absolute URL to resolve it against, but it was %q", raw)
+		return raw
+	}
+
+	return baseURL.ResolveReference(ref).String()
+}
+
+// URLs are optional, but when present they must be absolute http(s) URLs, so that no javascript: or data: URI
+// ends up in the rendered page or in a redirect
+func validateURL(v *validation, field, raw string) {
+	if raw == "" {
+		return
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		v.fail(field, "is not a valid URL: %s", err)
+		return
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		v.fail(field, "must use the http or https scheme, but it was %q", raw)
+		return
+	}
+
+	if parsed.Host == "" {
+		v.fail(field, "must have a host, but it was %q", raw)
+	}
+}
+
+func validateImage(v *validation, field string, image templates.Image) {
+	if image.URL == "" {
+		v.fail(field+".url", "required")
+	}
+
+	if image.Width < 0 {
+		v.fail(field+".width", "can't be negative")
+	}
+	if image.Height < 0 {
+		v.fail(field+".height", "can't be negative")
+	}
+
+	if containsControl(image.URL) {
+		v.fail(field+".url", "can't contain control characters")
+	}
+	if containsControl(image.Type) {
+		v.fail(field+".type", "can't contain control characters")
+	}
+
+	validateURL(v, field+".url", image.URL)
+}
+
+func validateVideo(v *validation, video *templates.Video) {
+	if video == nil {
+		return
+	}
+
+	if video.URL == "" {
+		v.fail("video.url", "required")
+	}
 
+	if video.Width < 0 {
+		v.fail("video.width", "can't be negative")
+	}
+	if video.Height < 0 {
+		v.fail("video.height", "can't be negative")
+	}
+
+	if containsControl(video.URL) {
+		v.fail("video.url", "can't contain control characters")
+	}
+	if containsControl(video.Type) {
+		v.fail("video.type", "can't contain control characters")
+	}
+
+	validateURL(v, "video.url", video.URL)
+}
+
+func validateAudio(v *validation, audio *templates.Audio) {
+	if audio == nil {
+		return
+	}
+
+	if audio.URL == "" {
+		v.fail("audio.url", "required")
+	}
+
+	if containsControl(audio.URL) {
+		v.fail("audio.url", "can't contain control characters")
+	}
+	if containsControl(audio.Type) {
+		v.fail("audio.type", "can't contain control characters")
+	}
+
+	validateURL(v, "audio.url", audio.URL)
+}
+
+func validateArticle(v *validation, article *templates.Article) {
+	if article == nil {
+		return
+	}
+
+	if article.PublishedTime != "" {
+		if _, err := time.Parse(time.RFC3339, article.PublishedTime); err != nil {
+			v.fail("article.published_time", "must be an RFC 3339 date, such as 2017-03-14T09:00:00Z, but it was %q", article.PublishedTime)
+		}
+	}
+
+	for i, author := range article.Authors {
+		field := fmt.Sprintf("article.authors[%d]", i)
+		if containsControl(author) {
+			v.fail(field, "can't contain control characters")
+		}
+		validateURL(v, field, author)
+	}
+
+	if containsControl(article.Section) {
+		v.fail("article.section", "can't contain control characters")
+	}
+
+	for i, tag := range article.Tags {
+		if containsControl(tag) {
+			v.fail(fmt.Sprintf("article.tags[%d]", i), "can't contain control characters")
+		}
+	}
+}
+
+// Locales follow the Open Graph language_TERRITORY format, e.g. en_US or pt_BR
+var localeFormat = regexp.MustCompile(`^[a-z]{2,3}_[A-Z]{2}$`)
+
+func validateLocales(v *validation, values templates.Values) {
+	if values.Locale != "" && !localeFormat.MatchString(values.Locale) {
+		v.fail("locale", "must have the language_TERRITORY format, but it was %q", values.Locale)
+	}
+
+	for i, locale := range values.AlternateLocales {
+		if !localeFormat.MatchString(locale) {
+			v.fail(fmt.Sprintf("alternate_locales[%d]", i), "must have the language_TERRITORY format, but it was %q", locale)
+		}
+	}
+}
+
+// Slug returns the identifier of the link. Public links get one derived from their canonical URL so that the
+// same page always gets the same slug, or from all of their values when they have no URL. Private links get a
+// random one every time, as their slug is their only protection and anyone knowing the URL could work it out.
+func (link *Link) Slug() string {
+	if link.Private {
+		return link.flagged(strings.Replace(uuid.NewV4().String(), "-", "", -1))
+	}
+
+	canonical := []byte(link.Values.EffectiveCanonicalURL())
+	if len(canonical) == 0 {
+		canonical, _ = json.Marshal(link.Values)
+	}
+
+	hash := sha256.Sum256(canonical)
+	return link.flagged(base62(binary.BigEndian.Uint64(hash[:8])))
+}
+
+// Appends the flags that apply to the link to a slug
+func (link *Link) flagged(s string) string {
 	// Set all possible flags
 	if link.Private {
 		s = setFlags(s, privateFlag)
@@ -40,6 +510,21 @@ func generateSlug(link *Link) string {
 	return s
 }
 
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func base62(n uint64) string {
+	if n == 0 {
+		return base62Alphabet[:1]
+	}
+
+	var encoded []byte
+	for ; n > 0; n /= 62 {
+		encoded = append([]byte{base62Alphabet[n%62]}, encoded...)
+	}
+
+	return string(encoded)
+}
+
 /*
 	FLAGS:
 	Links can be flagged (e.g. as private, as recent, as part of a campaign...)
@@ -53,6 +538,10 @@ const (
 
 func hasFlag(slug string, flag int) bool {
 	parts := strings.Split(slug, "-")
+	if len(parts) < 2 {
+		return false
+	}
+
 	flagCollection, err := strconv.Atoi(parts[len(parts)-1])
 	if err != nil {
 		flagCollection = 0
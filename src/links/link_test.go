@@ -2,18 +2,21 @@ package links
 
 import (
 	"github.com/devlucky/fakelink/src/templates"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func TestValidNewLink(t *testing.T) {
-	values := templates.Values{Title: "some-title"}
+	values := templates.Values{Title: "some-title", Type: "article"}
 	link, err := NewLink(values, true)
 	if err != nil {
 		t.Error("Expected NewLink not to fail")
 	}
 
-	if link.Values != values {
+	if !reflect.DeepEqual(link.Values, values) {
 		t.Error("Expected NewLink to create link with the supplied values")
 	}
 
@@ -28,31 +31,317 @@ func TestInvalidNewLink(t *testing.T) {
 	}
 }
 
+func TestNewLinkRejectsControlCharacters(t *testing.T) {
+	injections := []string{"some\ntitle", "some\r\ntitle", "some\x00title", "some\ttitle"}
+	fields := map[string]func(*templates.Values, string){
+		"title":       func(v *templates.Values, s string) { v.Title = s },
+		"description": func(v *templates.Values, s string) { v.Description = s },
+		"site name":   func(v *templates.Values, s string) { v.SiteName = s },
+		"type":        func(v *templates.Values, s string) { v.Type = s },
+	}
+
+	for name, set := range fields {
+		for _, injection := range injections {
+			values := templates.Values{Title: "some-title"}
+			set(&values, injection)
+
+			if _, err := NewLink(values, false); err == nil {
+				t.Errorf("Expected NewLink to reject a %s containing %q", name, injection)
+			}
+		}
+	}
+}
+
+func TestNewLinkAcceptsMarkupCharacters(t *testing.T) {
+	// These are escaped when rendering, so they are legit in titles such as "Tom & Jerry <3"
+	values := templates.Values{Title: `"Tom" & <Jerry>`, Description: `It's "quoted" <b>`}
+
+	if _, err := NewLink(values, false); err != nil {
+		t.Errorf("Expected NewLink to accept quotes and angle brackets. Instead, got %s", err)
+	}
+}
+
+func TestNewLinkValidatesURLs(t *testing.T) {
+	cases := []struct {
+		url   string
+		valid bool
+	}{
+		{"", true},
+		{"http://example.com", true},
+		{"https://example.com/some/path?query=1", true},
+		{"HTTPS://example.com", true},
+		{"/relative/path", false},
+		{"relative/path", false},
+		{"example.com/missing-scheme", false},
+		{"//example.com/protocol-relative", false},
+		{"javascript:alert(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"ftp://example.com/file", false},
+		{"http://", false},
+		{"http://%zz", false},
+	}
+
+	for _, c := range cases {
+		// The link's own URLs may be paths on our domain, resolved when rendered
+		if c.url == "/relative/path" {
+			if _, err := NewLink(templates.Values{Title: "some-title", URL: c.url, CanonicalURL: c.url}, false); err != nil {
+				t.Errorf("Expected a root-relative path to be a valid URL. Instead, got %s", err)
+			}
+			if _, err := NewLink(templates.Values{Title: "some-title", TargetURL: c.url}, false); err == nil {
+				t.Error("Expected a root-relative path to be rejected as target URL")
+			}
+			if _, err := NewLink(templates.Values{Title: "some-title", Image: c.url}, false); err == nil {
+				t.Error("Expected a root-relative path to be rejected as image")
+			}
+			continue
+		}
+
+		_, urlErr := NewLink(templates.Values{Title: "some-title", URL: c.url}, false)
+		_, canonicalErr := NewLink(templates.Values{Title: "some-title", CanonicalURL: c.url}, false)
+		_, targetErr := NewLink(templates.Values{Title: "some-title", TargetURL: c.url}, false)
+		_, imageErr := NewLink(templates.Values{Title: "some-title", Image: c.url}, false)
+
+		for field, err := range map[string]error{"URL": urlErr, "canonical URL": canonicalErr, "target URL": targetErr, "image": imageErr} {
+			if c.valid && err != nil {
+				t.Errorf("Expected %q to be a valid %s. Instead, got %s", c.url, field, err)
+			}
+			if !c.valid && err == nil {
+				t.Errorf("Expected %q to be rejected as %s", c.url, field)
+			}
+		}
+	}
+}
+
+func TestNewLinkValidatesTwitterCard(t *testing.T) {
+	if _, err := NewLink(templates.Values{Title: "some-title", TwitterCard: "player"}, false); err != nil {
+		t.Errorf("Expected NewLink to accept a known Twitter card. Instead, got %s", err)
+	}
+
+	if _, err := NewLink(templates.Values{Title: "some-title", TwitterCard: "huge"}, false); err == nil {
+		t.Error("Expected NewLink to reject an unknown Twitter card")
+	}
+}
+
+func TestNewLinkValidatesImages(t *testing.T) {
+	valid := []templates.Image{{URL: "http://example.com/a.jpg"}, {URL: "https://example.com/b.png", Width: 800, Height: 600}}
+	if _, err := NewLink(templates.Values{Title: "some-title", Images: valid}, false); err != nil {
+		t.Errorf("Expected NewLink to accept valid images. Instead, got %s", err)
+	}
+
+	invalid := []templates.Image{
+		{},
+		{URL: "javascript:alert(1)"},
+		{URL: "http://example.com/a.jpg", Width: -1},
+		{URL: "http://example.com/\n.jpg"},
+	}
+	for _, image := range invalid {
+		values := templates.Values{Title: "some-title", Images: append(valid, image)}
+		if _, err := NewLink(values, false); err == nil {
+			t.Errorf("Expected NewLink to reject the image %+v", image)
+		}
+	}
+}
+
+func TestNewLinkResolvesRelativeImages(t *testing.T) {
+	cases := []struct {
+		image, resolved string
+	}{
+		{"/images/poster.jpg", "https://www.imdb.com/images/poster.jpg"},
+		{"poster.jpg", "https://www.imdb.com/title/tt0111161/poster.jpg"},
+		{"../poster.jpg", "https://www.imdb.com/title/poster.jpg"},
+		{"//cdn.example.com/poster.jpg", "https://cdn.example.com/poster.jpg"},
+		{"http://example.com/poster.jpg", "http://example.com/poster.jpg"},
+	}
+
+	for _, c := range cases {
+		values := templates.Values{
+			Title:  "some-title",
+			URL:    "https://www.imdb.com/title/tt0111161/",
+			Image:  c.image,
+			Images: []templates.Image{{URL: c.image, Width: 10}},
+		}
+
+		link, err := NewLink(values, false)
+		if err != nil {
+			t.Fatalf("Expected NewLink to accept the image %s. Instead, got %s", c.image, err)
+		}
+
+		if link.Values.Image != c.resolved || link.Values.Images[0].URL != c.resolved {
+			t.Errorf("Expected the image %s to be resolved to %s. Instead, got %s and %s", c.image, c.resolved, link.Values.Image, link.Values.Images[0].URL)
+		}
+		if values.Images[0].URL != c.image {
+			t.Error("Expected NewLink to leave the given values alone")
+		}
+	}
+}
+
+func TestNewLinkRejectsRelativeImagesWithoutAnAbsoluteURL(t *testing.T) {
+	for _, url := range []string{"", "/about"} {
+		for _, image := range []string{"/images/poster.jpg", "//cdn.example.com/poster.jpg"} {
+			_, err := NewLink(templates.Values{Title: "some-title", URL: url, Image: image}, false)
+
+			validationErr, ok := err.(*ValidationError)
+			if !ok || len(validationErr.Fields) != 1 || validationErr.Fields[0].Field != "image" || !strings.Contains(validationErr.Fields[0].Message, "is relative") {
+				t.Errorf("Expected the relative image %s of a link with the url %q to be rejected. Instead, got %v", image, url, err)
+			}
+		}
+	}
+}
+
+func TestNewLinkValidatesVideoAndAudio(t *testing.T) {
+	valid := templates.Values{
+		Title: "some-title",
+		Video: &templates.Video{URL: "https://example.com/movie.mp4", Type: "video/mp4", Width: 1280, Height: 720},
+		Audio: &templates.Audio{URL: "https://example.com/song.mp3", Type: "audio/mpeg"},
+	}
+	if _, err := NewLink(valid, false); err != nil {
+		t.Errorf("Expected NewLink to accept a valid video and audio. Instead, got %s", err)
+	}
+
+	invalid := []templates.Values{
+		{Title: "some-title", Video: &templates.Video{}},
+		{Title: "some-title", Video: &templates.Video{URL: "javascript:alert(1)"}},
+		{Title: "some-title", Video: &templates.Video{URL: "https://example.com/movie.mp4", Height: -1}},
+		{Title: "some-title", Video: &templates.Video{URL: "https://example.com/movie.mp4", Type: "video/mp4\n"}},
+		{Title: "some-title", Audio: &templates.Audio{URL: "data:audio/mpeg;base64,AAAA"}},
+		{Title: "some-title", Audio: &templates.Audio{}},
+	}
+	for _, values := range invalid {
+		if _, err := NewLink(values, false); err == nil {
+			t.Errorf("Expected NewLink to reject the video %+v and audio %+v", values.Video, values.Audio)
+		}
+	}
+}
+
+func TestNewLinkValidatesArticle(t *testing.T) {
+	valid := templates.Values{
+		Title:   "some-title",
+		Type:    "article",
+		Article: &templates.Article{PublishedTime: "2017-03-14T09:00:00+01:00", Authors: []string{"https://example.com/jane"}, Section: "Technology", Tags: []string{"go"}},
+	}
+	if _, err := NewLink(valid, false); err != nil {
+		t.Errorf("Expected NewLink to accept a valid article. Instead, got %s", err)
+	}
+
+	invalid := map[string]*templates.Article{
+		"article.published_time": {PublishedTime: "14/03/2017"},
+		"article.authors[1]":     {Authors: []string{"https://example.com/jane", "jane"}},
+		"article.section":        {Section: "Tech\nnology"},
+		"article.tags[0]":        {Tags: []string{"go\n"}},
+	}
+	for field, article := range invalid {
+		_, err := NewLink(templates.Values{Title: "some-title", Type: "article", Article: article}, false)
+		if err == nil || !strings.Contains(err.Error(), field+":") {
+			t.Errorf("Expected NewLink to reject the article's %s. Instead, got %v", field, err)
+		}
+	}
+}
+
+func TestNewLinkValidatesLocales(t *testing.T) {
+	valid := templates.Values{Title: "some-title", Locale: "pt_BR", AlternateLocales: []string{"en_US", "ast_ES"}}
+	if _, err := NewLink(valid, false); err != nil {
+		t.Errorf("Expected NewLink to accept well-formed locales. Instead, got %s", err)
+	}
+
+	for _, locale := range []string{"en", "en-US", "EN_us", "en_USA", "en_US\n", "english"} {
+		if _, err := NewLink(templates.Values{Title: "some-title", Locale: locale}, false); err == nil {
+			t.Errorf("Expected NewLink to reject the locale %q", locale)
+		}
+
+		if _, err := NewLink(templates.Values{Title: "some-title", AlternateLocales: []string{locale}}, false); err == nil {
+			t.Errorf("Expected NewLink to reject the alternate locale %q", locale)
+		}
+	}
+}
+
+func TestLinkExpired(t *testing.T) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+
+	if (&Link{}).Expired() {
+		t.Error("Expected links without an expiration date never to expire")
+	}
+
+	if (&Link{ExpiresAt: &future}).Expired() {
+		t.Error("Expected links not to expire before their expiration date")
+	}
+
+	if !(&Link{ExpiresAt: &past}).Expired() {
+		t.Error("Expected links to expire after their expiration date")
+	}
+
+	if retention := (&Link{ExpiresAt: &past}).retention(); retention <= 0 || retention > ExpiredRetention {
+		t.Errorf("Expected expired links to be retained for a while. Instead, the retention was %s", retention)
+	}
+}
+
 func TestSlugGeneration(t *testing.T) {
-	l, err := NewLink(templates.Values{Title: "An Extravagant Title! :)"}, true)
+	l, err := NewLink(templates.Values{Title: "An Extravagant Title! :)", URL: "http://example.com/extravagant"}, false)
 	if err != nil {
 		t.Fatal("Unexpected error creating a new link", err)
 	}
 
-	s1, s2 := generateSlug(l), generateSlug(l)
-	if s1 == s2 {
-		t.Error("Expected two slugs to be different")
+	if l.Slug() != l.Slug() {
+		t.Error("Expected the slug to be deterministic")
 	}
 
-	if !strings.Contains(s1, "an-extravagant-title") {
-		t.Error("Expected the slug to contain part of the title")
+	same, _ := NewLink(templates.Values{Title: "Another title", URL: "http://example.com/extravagant"}, false)
+	if l.Slug() != same.Slug() {
+		t.Error("Expected links with the same URL to have the same slug")
 	}
 
-	if !hasFlag(generateSlug(l), privateFlag) {
-		t.Error("Expected slug for private link to contain private flag")
+	other, _ := NewLink(templates.Values{Title: "An Extravagant Title! :)", URL: "http://example.com/other"}, false)
+	if l.Slug() == other.Slug() {
+		t.Error("Expected links with different URLs to have different slugs")
 	}
 
-	l.Private = false
-	if hasFlag(generateSlug(l), privateFlag) {
+	if hasFlag(l.Slug(), privateFlag) {
 		t.Error("Expected slug for public link not to contain private flag")
 	}
 }
 
+func TestPrivateSlugsAreRandom(t *testing.T) {
+	l, err := NewLink(templates.Values{Title: "An Extravagant Title! :)", URL: "http://example.com/extravagant"}, true)
+	if err != nil {
+		t.Fatal("Unexpected error creating a new link", err)
+	}
+
+	if l.Slug() == l.Slug() {
+		t.Error("Expected private links to get a new slug every time")
+	}
+
+	if !hasFlag(l.Slug(), privateFlag) {
+		t.Error("Expected slug for private link to contain private flag")
+	}
+
+	public := &Link{Values: l.Values}
+	if strings.HasPrefix(l.Slug(), public.Slug()) {
+		t.Error("Expected the slug of a private link not to be derived from its URL")
+	}
+}
+
+func TestSlugWithoutURL(t *testing.T) {
+	l1, _ := NewLink(templates.Values{Title: "some-title"}, false)
+	l2, _ := NewLink(templates.Values{Title: "some-title"}, false)
+	l3, _ := NewLink(templates.Values{Title: "some-other-title"}, false)
+
+	if l1.Slug() != l2.Slug() {
+		t.Error("Expected links with the same values to have the same slug")
+	}
+
+	if l1.Slug() == l3.Slug() {
+		t.Error("Expected links with different values to have different slugs")
+	}
+}
+
+func TestBase62(t *testing.T) {
+	cases := map[uint64]string{0: "0", 61: "z", 62: "10", 3843: "zz"}
+	for n, expected := range cases {
+		if encoded := base62(n); encoded != expected {
+			t.Errorf("Expected %d to be encoded as %s. Instead, it was %s", n, expected, encoded)
+		}
+	}
+}
+
 func TestSlugFlags(t *testing.T) {
 	slug := "some-slug"
 	slug = setFlags(slug, privateFlag)
@@ -60,4 +349,147 @@ func TestSlugFlags(t *testing.T) {
 	if !hasFlag(slug, privateFlag) {
 		t.Error("Expected slug to have private flag")
 	}
+
+	if hasFlag("12345", privateFlag) {
+		t.Error("Expected slugs without a flag suffix not to have any flag")
+	}
+}
+
+func TestNewLinkReportsEveryInvalidField(t *testing.T) {
+	_, err := NewLink(templates.Values{
+		URL:         "javascript:alert(1)",
+		TwitterCard: "huge",
+		Images:      []templates.Image{{URL: "http://example.com/a.jpg"}, {Width: -1}},
+		Locale:      "portuguese\n",
+	}, false)
+
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError. Instead, got %v", err)
+	}
+
+	expected := []string{"title", "twitter_card", "url", "images[1].url", "images[1].width", "locale"}
+	fields := make([]string, len(validationErr.Fields))
+	for i, field := range validationErr.Fields {
+		fields[i] = field.Field
+	}
+
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected the invalid fields to be %v. Instead, got %v", expected, fields)
+	}
+
+	if validationErr.Fields[0].Message != "required" {
+		t.Errorf("Expected a missing title to be reported as required. Instead, got %q", validationErr.Fields[0].Message)
+	}
+}
+
+func TestNewLinkDefaultsType(t *testing.T) {
+	link, err := NewLink(templates.Values{Title: "some-title"}, false)
+	if err != nil {
+		t.Fatalf("Expected NewLink not to fail. Instead, got %s", err)
+	}
+
+	if link.Values.Type != DefaultType {
+		t.Errorf("Expected links without a type to be of the %s type. Instead, got %q", DefaultType, link.Values.Type)
+	}
+}
+
+func TestNewLinkDerivesSiteNameFromURL(t *testing.T) {
+	cases := []struct {
+		values   templates.Values
+		siteName string
+	}{
+		{templates.Values{Title: "some-title", URL: "https://www.IMDb.com/title/tt0111161/"}, "imdb.com"},
+		{templates.Values{Title: "some-title", URL: "http://blog.example.com:8080/post"}, "blog.example.com"},
+		{templates.Values{Title: "some-title", TargetURL: "https://example.com/landing"}, "example.com"},
+		{templates.Values{Title: "some-title", URL: "/about", TargetURL: "https://www.example.com/about"}, "example.com"},
+		{templates.Values{Title: "some-title", URL: "/about"}, ""},
+		{templates.Values{Title: "some-title", URL: "https://imdb.com", SiteName: "IMDb"}, "IMDb"},
+	}
+
+	for _, c := range cases {
+		link, err := NewLink(c.values, false)
+		if err != nil {
+			t.Fatalf("Expected NewLink not to fail. Instead, got %s", err)
+		}
+
+		if link.Values.SiteName != c.siteName {
+			t.Errorf("Expected %+v to get the site name %q. Instead, got %q", c.values, c.siteName, link.Values.SiteName)
+		}
+	}
+}
+
+func TestNewLinkValidatesDeterminer(t *testing.T) {
+	for _, determiner := range []string{"", "a", "an", "the", "auto"} {
+		if _, err := NewLink(templates.Values{Title: "some-title", Determiner: determiner}, false); err != nil {
+			t.Errorf("Expected NewLink to accept the determiner %q. Instead, got %s", determiner, err)
+		}
+	}
+
+	if _, err := NewLink(templates.Values{Title: "some-title", Determiner: "some"}, false); err == nil {
+		t.Error("Expected NewLink to reject an unknown determiner")
+	}
+}
+
+func TestNewLinkWithUnknownType(t *testing.T) {
+	cases := map[string]string{
+		"vido.movie": `type: "vido.movie" is not an Open Graph type, did you mean "video.movie"?`,
+		"Website":    `type: "Website" is not an Open Graph type, did you mean "website"?`,
+		"music.sng":  `type: "music.sng" is not an Open Graph type, did you mean "music.song"?`,
+		"podcast":    `type: "podcast" is not an Open Graph type, which are website, article, book, profile, video`,
+	}
+
+	for ogType, expected := range cases {
+		_, err := NewLink(templates.Values{Title: "some-title", Type: ogType}, false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q to be rejected with %s. Instead, got %v", ogType, expected, err)
+		}
+	}
+}
+
+func TestNewLinkWithOpenGraphTypes(t *testing.T) {
+	for _, ogType := range []string{"website", "article", "book", "profile", "video.movie", "music.song", "music.radio_station"} {
+		if _, err := NewLink(templates.Values{Title: "some-title", Type: ogType}, false); err != nil {
+			t.Errorf("Expected %q to be a valid type. Instead, got %s", ogType, err)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"video", "video", 0},
+		{"vido", "video", 1},
+		{"", "book", 4},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, c := range cases {
+		if distance := editDistance(c.a, c.b); distance != c.distance {
+			t.Errorf("Expected the distance between %q and %q to be %d. Instead, got %d", c.a, c.b, c.distance, distance)
+		}
+	}
+}
+
+func TestNewLinkTruncatesLongDescriptions(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+
+	link, err := NewLink(templates.Values{Title: "some-title", Description: long}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error creating a link: %s", err)
+	}
+	if n := utf8.RuneCountInString(link.Values.Description); n > DefaultMaxDescriptionLength || !strings.HasSuffix(link.Values.Description, "word…") {
+		t.Errorf("Expected the description to be truncated to %d characters. Instead, got %d: %q", DefaultMaxDescriptionLength, n, link.Values.Description)
+	}
+
+	link, err = NewLinkWithMaxDescription(templates.Values{Title: "some-title", Description: long}, false, 1000)
+	if err != nil {
+		t.Fatalf("Unexpected error creating a link: %s", err)
+	}
+	if link.Values.Description != long {
+		t.Error("Expected descriptions under the limit to be kept whole")
+	}
 }
@@ -0,0 +1,97 @@
+package links
+
+import (
+	"crypto/rand"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultSlugLength is the length of the random slugs generated when none is specified.
+	DefaultSlugLength = 7
+	// How many random slugs are tried before giving up on finding a free one
+	maxSlugAttempts = 10
+)
+
+// ErrNoFreeSlug is returned when no unused random slug could be generated.
+var ErrNoFreeSlug = errors.New("Could not generate an unused slug")
+
+// ErrSlugTaken is returned by Store.CreateIfAbsent when a link was already created with the slug.
+var ErrSlugTaken = errors.New("The slug is already taken")
+
+// GenerateSlug returns a random, URL-safe base62 slug of the given length which no link in the store uses yet.
+func GenerateSlug(store Store, length int) (string, error) {
+	if length <= 0 {
+		length = DefaultSlugLength
+	}
+
+	for i := 0; i < maxSlugAttempts; i++ {
+		slug, err := randomBase62(length)
+		if err != nil {
+			return "", err
+		}
+
+		if !SlugInUse(store, slug) {
+			return slug, nil
+		}
+	}
+
+	return "", ErrNoFreeSlug
+}
+
+// SlugInUse reports whether either a public or a private link was created with the slug.
+func SlugInUse(store Store, slug string) bool {
+	return store.Exists(slug) || store.Exists(setFlags(slug, privateFlag))
+}
+
+// The slug a link created with the given one is stored under, and the one it would have had with the other
+// visibility, which takes the slug as well
+func slugVariants(slug string, link *Link) (own, other string) {
+	public, private := slug, setFlags(slug, privateFlag)
+	if link.Private {
+		return private, public
+	}
+
+	return public, private
+}
+
+// Custom slugs are lowercase letters, digits and dashes. As flags are appended to slugs as a numeric last
+// segment, as in abc-1, custom slugs can't end with one themselves
+var customSlugPattern = regexp.MustCompile(`^[a-z0-9-]{3,64}$`)
+
+// IsValidCustomSlug reports whether a slug chosen by a user may identify a link: 3 to 64 lowercase letters, digits
+// or dashes, not ending with a number after a dash.
+func IsValidCustomSlug(slug string) bool {
+	if !customSlugPattern.MatchString(slug) {
+		return false
+	}
+
+	i := strings.LastIndex(slug, "-")
+	if i < 0 {
+		return true
+	}
+	_, err := strconv.Atoi(slug[i+1:])
+	return err != nil
+}
+
+func randomBase62(length int) (string, error) {
+	slug := make([]byte, 0, length)
+	buf := make([]byte, length)
+
+	for len(slug) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+
+		// Bytes beyond the largest multiple of 62 are discarded, so that every character is equally likely
+		for _, b := range buf {
+			if b < 248 && len(slug) < length {
+				slug = append(slug, base62Alphabet[b%62])
+			}
+		}
+	}
+
+	return string(slug), nil
+}
@@ -0,0 +1,82 @@
+package links
+
+import (
+	"github.com/devlucky/fakelink/src/templates"
+	"strings"
+	"testing"
+)
+
+// A store where every slug is already taken
+type fullStore struct {
+	Store
+}
+
+func (store *fullStore) Exists(slug string) bool {
+	return true
+}
+
+func TestGenerateSlug(t *testing.T) {
+	store := NewInMemoryStore()
+
+	for _, length := range []int{4, 7, 12} {
+		slug, err := GenerateSlug(store, length)
+		if err != nil {
+			t.Fatalf("Unexpected error generating a slug: %s", err)
+		}
+
+		if len(slug) != length {
+			t.Errorf("Expected a slug of length %d. Instead, got %s", length, slug)
+		}
+
+		for _, char := range slug {
+			if !strings.ContainsRune(base62Alphabet, char) {
+				t.Errorf("Expected slugs to be base62. Instead, got %s", slug)
+			}
+		}
+	}
+}
+
+func TestGenerateSlugDefaultLength(t *testing.T) {
+	slug, err := GenerateSlug(NewInMemoryStore(), 0)
+	if err != nil {
+		t.Fatalf("Unexpected error generating a slug: %s", err)
+	}
+
+	if len(slug) != DefaultSlugLength {
+		t.Errorf("Expected slugs to default to length %d. Instead, got %s", DefaultSlugLength, slug)
+	}
+}
+
+func TestGenerateSlugGivesUp(t *testing.T) {
+	if _, err := GenerateSlug(&fullStore{}, 7); err != ErrNoFreeSlug {
+		t.Errorf("Expected generating a slug on a full store to fail with ErrNoFreeSlug. Instead, got %v", err)
+	}
+}
+
+func TestSlugInUse(t *testing.T) {
+	store := NewInMemoryStore()
+	link, _ := NewLink(templates.Values{Title: "something"}, true)
+	store.CreateWithSlug("taken", link)
+
+	if !SlugInUse(store, "taken") {
+		t.Error("Expected slugs taken by private links to be in use")
+	}
+
+	if SlugInUse(store, "free") {
+		t.Error("Expected unused slugs not to be in use")
+	}
+}
+
+func TestIsValidCustomSlug(t *testing.T) {
+	for _, slug := range []string{"summer-sale", "abc", "2024-summer-sale", "sale-v2", strings.Repeat("a", 64)} {
+		if !IsValidCustomSlug(slug) {
+			t.Errorf("Expected %q to be a valid custom slug", slug)
+		}
+	}
+
+	for _, slug := range []string{"", "ab", "Summer-Sale", "summer_sale", "summer sale", "summer/sale", "sale-2024", "sale-1", strings.Repeat("a", 65)} {
+		if IsValidCustomSlug(slug) {
+			t.Errorf("Expected %q not to be a valid custom slug", slug)
+		}
+	}
+}
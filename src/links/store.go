@@ -1,79 +1,376 @@
 package links
 
 import (
+	"container/list"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gopkg.in/redis.v5"
 	"log"
-	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // Store allows saving and retrieving user-generated links.
 type Store interface {
 	Find(slug string) *Link
+	Exists(slug string) bool
 	FindRandom() (slug string)
 	Create(link *Link) string
+	CreateWithSlug(slug string, link *Link) string
+	CreateIfAbsent(slug string, link *Link) (string, error)
+	CreateBatch(entries []Entry) []string
+	Update(slug string, link *Link) bool
+	Delete(slug string) bool
+	List(cursor string, limit int) (entries []Entry, next string, err error)
+	All() ([]Entry, error)
+	Ping() error
 	clear()
 }
 
-// InMemoryStore is an in-memory implementation of a template store.
+// DefaultListLimit is how many links a page of Store.List holds when the limit asked for is not positive.
+const DefaultListLimit = 20
+
+// ErrInvalidCursor is returned by Store.List when the cursor is not one it returned.
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// An Entry is a Link along with the slug it is stored under.
+type Entry struct {
+	Slug string `json:"slug"`
+	Link *Link  `json:"link"`
+}
+
+// InMemoryStore is an in-memory implementation of a template store, safe for concurrent use. With a capacity,
+// the least recently created, updated or found links are evicted to make room for new ones.
 type InMemoryStore struct {
+	mutex   sync.RWMutex
 	public  map[string]*Link
 	private map[string]*Link
+
+	capacity int
+	recency  *list.List
+	elements map[string]*list.Element
+	onEvict  func(slug string, link *Link)
+	swept    time.Time
 }
 
-// NewInMemoryStore creates a new in-memory store.
+// How often an InMemoryStore goes through its links to drop those whose retention ended
+const sweepInterval = time.Minute
+
+// NewInMemoryStore creates a new in-memory store, which grows without bounds.
 func NewInMemoryStore() *InMemoryStore {
+	return NewInMemoryStoreWithCapacity(0)
+}
+
+// NewInMemoryStoreWithCapacity creates a new in-memory store keeping up to capacity links, zero meaning unlimited.
+func NewInMemoryStoreWithCapacity(capacity int) *InMemoryStore {
 	return &InMemoryStore{
-		public:  make(map[string]*Link),
-		private: make(map[string]*Link),
+		public:   make(map[string]*Link),
+		private:  make(map[string]*Link),
+		capacity: capacity,
+		recency:  list.New(),
+		elements: make(map[string]*list.Element),
 	}
 }
 
+// OnEvict registers a function called with every link evicted to make room for others, or dropped once its
+// retention ended, after it is gone.
+func (store *InMemoryStore) OnEvict(fn func(slug string, link *Link)) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.onEvict = fn
+}
+
 // Find retrieves a single Link from its slug.
 func (store *InMemoryStore) Find(slug string) *Link {
+	// Finding a link makes it the most recently used, which capped stores have to write down
+	if store.capacity > 0 {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+
+		store.touch(slug)
+		return store.find(slug)
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.find(slug)
+}
+
+// Exists reports whether a Link is stored under the slug, without counting as a use of it.
+func (store *InMemoryStore) Exists(slug string) bool {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.find(slug) != nil
+}
+
+// Links whose retention ended are treated as missing until they are swept
+func (store *InMemoryStore) find(slug string) *Link {
+	if link := store.stored(slug); link != nil && link.retained() {
+		return link
+	}
+	return nil
+}
+
+func (store *InMemoryStore) stored(slug string) *Link {
 	if hasFlag(slug, privateFlag) {
 		return store.private[slug]
 	}
 	return store.public[slug]
 }
 
-// FindRandom retrieves a random Link slug.
+// FindRandom retrieves a random Link slug, leaving out expired links.
 func (store *InMemoryStore) FindRandom() (slug string) {
-	if len(store.public) == 0 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	slugs := make([]string, 0, len(store.public))
+	for s, link := range store.public {
+		if !link.Expired() {
+			slugs = append(slugs, s)
+		}
+	}
+
+	if len(slugs) == 0 {
 		return
 	}
 
-	i := 0
-	n := rand.Int() % len(store.public)
+	return slugs[randomIntn(len(slugs))]
+}
 
-	for s := range store.public {
-		if i == n {
-			slug = s
-			break
-		}
-		i++
+// Create creates a new Link, or replaces the one with the same slug.
+func (store *InMemoryStore) Create(link *Link) string {
+	store.mutex.Lock()
+	slug := store.put(link.Slug(), link)
+	evicted := append(store.sweep(), store.evict()...)
+	store.mutex.Unlock()
+
+	store.notify(evicted)
+	return slug
+}
+
+// CreateWithSlug creates a new Link identified by the given slug, plus the link's flags.
+func (store *InMemoryStore) CreateWithSlug(slug string, link *Link) string {
+	store.mutex.Lock()
+	slug = store.put(link.flagged(slug), link)
+	evicted := append(store.sweep(), store.evict()...)
+	store.mutex.Unlock()
+
+	store.notify(evicted)
+	return slug
+}
+
+// CreateIfAbsent creates a new Link identified by the given slug, plus the link's flags, unless a public or a
+// private link was already created with the slug, in which case it fails with ErrSlugTaken.
+func (store *InMemoryStore) CreateIfAbsent(slug string, link *Link) (string, error) {
+	own, other := slugVariants(slug, link)
+
+	store.mutex.Lock()
+	if store.find(own) != nil || store.find(other) != nil {
+		store.mutex.Unlock()
+		return "", ErrSlugTaken
 	}
+	slug = store.put(own, link)
+	evicted := append(store.sweep(), store.evict()...)
+	store.mutex.Unlock()
 
-	return
+	store.notify(evicted)
+	return slug, nil
 }
 
-// Create creates a new Link.
-func (store *InMemoryStore) Create(link *Link) string {
-	slug := generateSlug(link)
+// CreateBatch creates a Link for each entry, identified by its slug plus the link's flags. The slugs are returned
+// in the same order.
+func (store *InMemoryStore) CreateBatch(entries []Entry) []string {
+	store.mutex.Lock()
+	slugs := make([]string, len(entries))
+	for i, entry := range entries {
+		slugs[i] = store.put(entry.Link.flagged(entry.Slug), entry.Link)
+	}
+	evicted := append(store.sweep(), store.evict()...)
+	store.mutex.Unlock()
 
+	store.notify(evicted)
+	return slugs
+}
+
+func (store *InMemoryStore) put(slug string, link *Link) string {
 	if link.Private {
 		store.private[slug] = link
 	} else {
 		store.public[slug] = link
 	}
 
+	if element, ok := store.elements[slug]; ok {
+		store.recency.MoveToFront(element)
+	} else {
+		store.elements[slug] = store.recency.PushFront(slug)
+	}
+
 	return slug
 }
 
+// Marks a link as the most recently used
+func (store *InMemoryStore) touch(slug string) {
+	if element, ok := store.elements[slug]; ok {
+		store.recency.MoveToFront(element)
+	}
+}
+
+// Drops the least recently used links until the store is within its capacity, returning them
+func (store *InMemoryStore) evict() []Entry {
+	var evicted []Entry
+	for store.capacity > 0 && store.recency.Len() > store.capacity {
+		slug := store.recency.Back().Value.(string)
+		evicted = append(evicted, Entry{Slug: slug, Link: store.stored(slug)})
+		store.remove(slug)
+	}
+
+	return evicted
+}
+
+// Drops the links whose retention ended, at most once every sweepInterval, returning them
+func (store *InMemoryStore) sweep() []Entry {
+	if time.Since(store.swept) < sweepInterval {
+		return nil
+	}
+	store.swept = time.Now()
+
+	var swept []Entry
+	for _, links := range []map[string]*Link{store.public, store.private} {
+		for slug, link := range links {
+			if !link.retained() {
+				swept = append(swept, Entry{Slug: slug, Link: link})
+				store.remove(slug)
+			}
+		}
+	}
+
+	return swept
+}
+
+// Tells about evicted links outside of the lock, so that the callback may use the store
+func (store *InMemoryStore) notify(evicted []Entry) {
+	if len(evicted) == 0 {
+		return
+	}
+
+	store.mutex.RLock()
+	onEvict := store.onEvict
+	store.mutex.RUnlock()
+
+	if onEvict == nil {
+		return
+	}
+	for _, entry := range evicted {
+		onEvict(entry.Slug, entry.Link)
+	}
+}
+
+// Update replaces the Link stored under a slug, reporting whether there was one.
+func (store *InMemoryStore) Update(slug string, link *Link) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.find(slug) == nil {
+		return false
+	}
+
+	store.put(slug, link)
+	return true
+}
+
+// Delete removes a Link, reporting whether there was one with that slug.
+func (store *InMemoryStore) Delete(slug string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.remove(slug)
+}
+
+func (store *InMemoryStore) remove(slug string) bool {
+	links := store.public
+	if hasFlag(slug, privateFlag) {
+		links = store.private
+	}
+
+	if element, ok := store.elements[slug]; ok {
+		store.recency.Remove(element)
+		delete(store.elements, slug)
+	}
+
+	_, ok := links[slug]
+	delete(links, slug)
+	return ok
+}
+
+// List returns up to limit public links, in slug order, starting after the cursor and leaving out expired links.
+// The returned cursor points to the next page, and is empty after the last one.
+func (store *InMemoryStore) List(cursor string, limit int) ([]Entry, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	slugs := make([]string, 0, len(store.public))
+	for slug, link := range store.public {
+		if slug > cursor && !link.Expired() {
+			slugs = append(slugs, slug)
+		}
+	}
+	sort.Strings(slugs)
+
+	next := ""
+	if len(slugs) > limit {
+		slugs = slugs[:limit]
+		next = slugs[limit-1]
+	}
+
+	entries := make([]Entry, len(slugs))
+	for i, slug := range slugs {
+		entries[i] = Entry{Slug: slug, Link: store.public[slug]}
+	}
+
+	return entries, next, nil
+}
+
+// All returns every link, public and private, in no particular order, leaving out expired links. Unlike Find,
+// it does not count as using them.
+func (store *InMemoryStore) All() ([]Entry, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	entries := make([]Entry, 0, len(store.public)+len(store.private))
+	for _, links := range []map[string]*Link{store.public, store.private} {
+		for slug, link := range links {
+			if !link.Expired() {
+				entries = append(entries, Entry{Slug: slug, Link: link})
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// Ping always succeeds, as there is nothing to reach.
+func (store *InMemoryStore) Ping() error {
+	return nil
+}
+
 func (store *InMemoryStore) clear() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
 	store.public = make(map[string]*Link)
 	store.private = make(map[string]*Link)
+	store.recency = list.New()
+	store.elements = make(map[string]*list.Element)
 }
 
 // RedisStore is a redis based implementation of a link store.
@@ -123,22 +420,135 @@ func (store *RedisStore) Find(slug string) *Link {
 	return link
 }
 
-// FindRandom retrieves a random Link slug.
-func (store *RedisStore) FindRandom() (slug string) {
-	slug, err := store.public.RandomKey().Result()
+// Exists reports whether a Link is stored under the slug.
+func (store *RedisStore) Exists(slug string) bool {
+	db := store.public
+	if hasFlag(slug, privateFlag) {
+		db = store.private
+	}
+
+	exists, err := db.Exists(slug).Result()
 	if err != nil {
-		log.Printf("Getting link with slug %s failed with error %s", slug, err)
-		return
+		log.Printf("Checking for a link with slug %s failed with error %s", slug, err)
+		return false
 	}
 
-	return
+	return exists
 }
 
-// Create creates a new Link.
+// FindRandom retrieves a random Link slug. Expired links are skipped, which gives up after randomAttempts of
+// them in a row.
+func (store *RedisStore) FindRandom() (slug string) {
+	for i := 0; i < randomAttempts; i++ {
+		slug, err := store.public.RandomKey().Result()
+		if err != nil {
+			if err != redis.Nil {
+				log.Printf("Getting a random link failed with error %s", err)
+			}
+			return ""
+		}
+
+		if link := store.Find(slug); link != nil && !link.Expired() {
+			return slug
+		}
+	}
+
+	return ""
+}
+
+// How many random keys a RedisStore draws before giving up on finding a link that did not expire
+const randomAttempts = 10
+
+// Create creates a new Link, or replaces the one with the same slug.
 func (store *RedisStore) Create(link *Link) string {
+	return store.put(link.Slug(), link)
+}
+
+// CreateWithSlug creates a new Link identified by the given slug, plus the link's flags.
+func (store *RedisStore) CreateWithSlug(slug string, link *Link) string {
+	return store.put(link.flagged(slug), link)
+}
+
+// CreateIfAbsent creates a new Link identified by the given slug, plus the link's flags, unless a public or a
+// private link was already created with the slug, in which case it fails with ErrSlugTaken. The link is only set
+// if its own key is free, while the other visibility is checked beforehand.
+func (store *RedisStore) CreateIfAbsent(slug string, link *Link) (string, error) {
+	own, other := slugVariants(slug, link)
+
+	db, otherDB := store.public, store.private
+	if link.Private {
+		db, otherDB = store.private, store.public
+	}
+
+	taken, err := otherDB.Exists(other).Result()
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrSlugTaken
+	}
+
+	bytes, err := json.Marshal(link)
+	if err != nil {
+		return "", err
+	}
+
+	created, err := db.SetNX(own, string(bytes), link.retention()).Result()
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return "", ErrSlugTaken
+	}
+
+	return own, nil
+}
+
+// CreateBatch creates a Link for each entry, identified by its slug plus the link's flags, in a single round trip
+// per database. The slugs are returned in the same order, those of the links that could not be stored being empty.
+func (store *RedisStore) CreateBatch(entries []Entry) []string {
+	public, private := store.public.Pipeline(), store.private.Pipeline()
+	defer public.Close()
+	defer private.Close()
+
+	slugs := make([]string, len(entries))
+	cmds := make([]*redis.StatusCmd, len(entries))
+	for i, entry := range entries {
+		bytes, err := json.Marshal(entry.Link)
+		if err != nil {
+			log.Printf("Unexpected error when marshaling a valid link: %s", err)
+			continue
+		}
+
+		pipe := public
+		if entry.Link.Private {
+			pipe = private
+		}
+
+		slugs[i] = entry.Link.flagged(entry.Slug)
+		cmds[i] = pipe.Set(slugs[i], string(bytes), entry.Link.retention())
+	}
+
+	// Failures are reported by each command, right below
+	public.Exec()
+	private.Exec()
+
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		if err := cmd.Err(); err != nil {
+			log.Printf("Unexpected error when storing a link: %s", err)
+			slugs[i] = ""
+		}
+	}
+
+	return slugs
+}
+
+func (store *RedisStore) put(slug string, link *Link) string {
 	var db *redis.Client
 
-	slug := generateSlug(link)
 	if link.Private {
 		db = store.private
 	} else {
@@ -151,7 +561,7 @@ func (store *RedisStore) Create(link *Link) string {
 		return ""
 	}
 
-	err = db.Set(slug, string(bytes), 0).Err()
+	err = db.Set(slug, string(bytes), link.retention()).Err()
 	if err != nil {
 		log.Printf("Unexpected error when storing a link: %s", err)
 		return ""
@@ -160,6 +570,176 @@ func (store *RedisStore) Create(link *Link) string {
 	return slug
 }
 
+// Update replaces the Link stored under a slug, reporting whether there was one.
+func (store *RedisStore) Update(slug string, link *Link) bool {
+	var db *redis.Client
+
+	if hasFlag(slug, privateFlag) {
+		db = store.private
+	} else {
+		db = store.public
+	}
+
+	bytes, err := json.Marshal(link)
+	if err != nil {
+		log.Printf("Unexpected error when marshaling a valid link: %s", err)
+		return false
+	}
+
+	// Only set if the link already exists, so that updates can't create links. SetXX can't be used
+	// because it always sends an expiration, and zero is not a valid one
+	args := []interface{}{"set", slug, string(bytes), "xx"}
+	if retention := link.retention(); retention > 0 {
+		args = append(args, "px", int64(retention/time.Millisecond))
+	}
+	cmd := redis.NewBoolCmd(args...)
+	db.Process(cmd)
+
+	updated, err := cmd.Result()
+	if err == redis.Nil {
+		return false
+	}
+	if err != nil {
+		log.Printf("Updating link with slug %s failed with error %s", slug, err)
+		return false
+	}
+
+	return updated
+}
+
+// Delete removes a Link, reporting whether there was one with that slug.
+func (store *RedisStore) Delete(slug string) bool {
+	var db *redis.Client
+
+	if hasFlag(slug, privateFlag) {
+		db = store.private
+	} else {
+		db = store.public
+	}
+
+	deleted, err := db.Del(slug).Result()
+	if err != nil {
+		log.Printf("Deleting link with slug %s failed with error %s", slug, err)
+		return false
+	}
+
+	return deleted > 0
+}
+
+// List returns a page of public links, starting at the cursor and leaving out expired links. The returned cursor
+// points to the next page, and is empty after the last one. Pages are scanned, so their size is only approximately
+// limit.
+func (store *RedisStore) List(cursor string, limit int) ([]Entry, string, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	var position uint64
+	if cursor != "" {
+		var err error
+		if position, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+
+	slugs, position, err := store.public.Scan(position, "", int64(limit)).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if position != 0 {
+		next = strconv.FormatUint(position, 10)
+	}
+
+	entries, err := getEntries(store.public, slugs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return unexpired(entries), next, nil
+}
+
+// All returns every link, public and private, in no particular order, leaving out expired links.
+func (store *RedisStore) All() ([]Entry, error) {
+	entries := []Entry{}
+
+	for _, db := range []*redis.Client{store.public, store.private} {
+		var position uint64
+		for {
+			slugs, next, err := db.Scan(position, "", 1000).Result()
+			if err != nil {
+				return nil, err
+			}
+
+			found, err := getEntries(db, slugs)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, unexpired(found)...)
+
+			if next == 0 {
+				break
+			}
+			position = next
+		}
+	}
+
+	return entries, nil
+}
+
+// Gets the links stored under the slugs, skipping those that are gone
+func getEntries(db *redis.Client, slugs []string) ([]Entry, error) {
+	if len(slugs) == 0 {
+		return []Entry{}, nil
+	}
+
+	values, err := db.MGet(slugs...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(slugs))
+	for i, value := range values {
+		// Links may expire or be deleted between the scan and the get
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		link := &Link{}
+		if err = json.Unmarshal([]byte(str), link); err != nil {
+			log.Printf("Unexpected error when unmarshaling a previously stored link: %s", err)
+			continue
+		}
+
+		entries = append(entries, Entry{Slug: slugs[i], Link: link})
+	}
+
+	return entries, nil
+}
+
+// Leaves out the expired links, which stores keep for a while so that they can be reported as gone
+func unexpired(entries []Entry) []Entry {
+	live := entries[:0]
+	for _, entry := range entries {
+		if !entry.Link.Expired() {
+			live = append(live, entry)
+		}
+	}
+
+	return live
+}
+
+// Ping checks that Redis is reachable.
+func (store *RedisStore) Ping() error {
+	if err := store.public.Ping().Err(); err != nil {
+		return err
+	}
+
+	return store.private.Ping().Err()
+}
+
 func (store *RedisStore) clear() {
 	store.public.FlushDb()
 	store.private.FlushDb()
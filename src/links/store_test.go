@@ -1,11 +1,14 @@
 package links
 
 import (
+	"fmt"
 	"github.com/devlucky/fakelink/src/helpers"
 	"github.com/devlucky/fakelink/src/templates"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 /*
@@ -21,6 +24,35 @@ func behavesLikeAStore(t *testing.T, store Store) {
 
 	store.clear()
 	testFindRandom(t, store)
+
+	store.clear()
+	testCreateTwice(t, store)
+
+	store.clear()
+	testCreateWithSlug(t, store)
+
+	store.clear()
+	testCreateIfAbsent(t, store)
+
+	store.clear()
+	testCreateBatch(t, store)
+
+	store.clear()
+	testUpdate(t, store)
+
+	store.clear()
+	testDelete(t, store)
+
+	store.clear()
+	testList(t, store)
+
+	store.clear()
+	testAll(t, store)
+
+	store.clear()
+	testExpired(t, store)
+
+	testPing(t, store)
 }
 
 func testFindMissing(t *testing.T, store Store) {
@@ -28,6 +60,10 @@ func testFindMissing(t *testing.T, store Store) {
 	if link != nil {
 		t.Error("Expected .Find on a missing link to be nil")
 	}
+
+	if store.Exists("missing") || store.Exists("missing-1") {
+		t.Error("Expected .Exists on a missing link to be false")
+	}
 }
 
 func testFindRandom(t *testing.T, store Store) {
@@ -58,7 +94,7 @@ func testFindRandom(t *testing.T, store Store) {
 }
 
 func testCreateAndFind(t *testing.T, store Store) {
-	values := templates.Values{Title: "something"}
+	values := templates.Values{Title: "something", Type: "website"}
 	link, err := NewLink(values, true)
 	if err != nil {
 		t.Fatal("Not expecting .NewLink to fail. Instead, got", err)
@@ -66,6 +102,10 @@ func testCreateAndFind(t *testing.T, store Store) {
 
 	slug := store.Create(link)
 
+	if !store.Exists(slug) {
+		t.Error("Expected .Exists to report a link after .Create")
+	}
+
 	link = store.Find(slug)
 	if link == nil {
 		t.Error("Expected .Find to find a link after .Create")
@@ -76,18 +116,253 @@ func testCreateAndFind(t *testing.T, store Store) {
 	}
 }
 
+func testCreateTwice(t *testing.T, store Store) {
+	link, err := NewLink(templates.Values{Title: "something", URL: "http://example.com"}, false)
+	if err != nil {
+		t.Fatal("Not expecting .NewLink to fail. Instead, got", err)
+	}
+
+	s1, s2 := store.Create(link), store.Create(link)
+	if s1 != s2 {
+		t.Error("Expected creating the same link twice to result in the same slug")
+	}
+
+	if store.FindRandom() != s1 {
+		t.Error("Expected creating the same link twice not to duplicate it")
+	}
+}
+
+func testCreateWithSlug(t *testing.T, store Store) {
+	link, err := NewLink(templates.Values{Title: "something"}, true)
+	if err != nil {
+		t.Fatal("Not expecting .NewLink to fail. Instead, got", err)
+	}
+
+	slug := store.CreateWithSlug("abc1234", link)
+	if !hasFlag(slug, privateFlag) {
+		t.Errorf("Expected the created slug to carry the link's flags. Instead, it was %s", slug)
+	}
+
+	if store.Find(slug) == nil {
+		t.Error("Expected .Find to find a link after .CreateWithSlug")
+	}
+}
+
+func testCreateIfAbsent(t *testing.T, store Store) {
+	first := &Link{Values: templates.Values{Title: "first"}}
+	slug, err := store.CreateIfAbsent("summer-sale", first)
+	if err != nil || slug != "summer-sale" {
+		t.Fatalf("Expected .CreateIfAbsent to create the link under a free slug. Instead, got %q, %v", slug, err)
+	}
+
+	for _, private := range []bool{false, true} {
+		second := &Link{Values: templates.Values{Title: "second"}, Private: private}
+		if slug, err := store.CreateIfAbsent("summer-sale", second); err != ErrSlugTaken || slug != "" {
+			t.Errorf("Expected .CreateIfAbsent to fail on a taken slug. Instead, got %q, %v", slug, err)
+		}
+	}
+
+	if found := store.Find("summer-sale"); found == nil || found.Values.Title != "first" {
+		t.Errorf("Expected the first link to be kept. Instead, got %+v", found)
+	}
+	if store.Exists(setFlags("summer-sale", privateFlag)) {
+		t.Error("Expected no private link to be created under a slug a public link took")
+	}
+}
+
+func testCreateBatch(t *testing.T, store Store) {
+	entries := []Entry{
+		{Slug: "batch01", Link: &Link{Values: templates.Values{Title: "first"}}},
+		{Slug: "batch02", Link: &Link{Values: templates.Values{Title: "second"}, Private: true}},
+		{Slug: "batch03", Link: &Link{Values: templates.Values{Title: "third"}}},
+	}
+
+	slugs := store.CreateBatch(entries)
+	if len(slugs) != len(entries) {
+		t.Fatalf("Expected a slug per entry. Instead, got %v", slugs)
+	}
+
+	for i, slug := range slugs {
+		if hasFlag(slug, privateFlag) != entries[i].Link.Private {
+			t.Errorf("Expected the created slug to carry the link's flags. Instead, it was %s", slug)
+		}
+
+		link := store.Find(slug)
+		if link == nil || link.Values.Title != entries[i].Link.Values.Title {
+			t.Errorf("Expected .Find to find the link created under %s, in order. Instead, got %+v", slug, link)
+		}
+	}
+
+	if slugs := store.CreateBatch(nil); len(slugs) != 0 {
+		t.Errorf("Expected an empty batch to create nothing. Instead, got %v", slugs)
+	}
+}
+
+func testUpdate(t *testing.T, store Store) {
+	link, _ := NewLink(templates.Values{Title: "something"}, true)
+	slug := store.Create(link)
+
+	updated, _ := NewLink(templates.Values{Title: "something else"}, true)
+	if !store.Update(slug, updated) {
+		t.Error("Expected .Update to report the link as updated")
+	}
+
+	if found := store.Find(slug); found == nil || found.Values.Title != "something else" {
+		t.Errorf("Expected .Find to return the updated link. Instead, got %+v", found)
+	}
+
+	if store.Update("missing-1", updated) || store.Find("missing-1") != nil {
+		t.Error("Expected .Update not to create missing links")
+	}
+}
+
+func testDelete(t *testing.T, store Store) {
+	for _, private := range []bool{false, true} {
+		link, _ := NewLink(templates.Values{Title: "something"}, private)
+		slug := store.Create(link)
+
+		if !store.Delete(slug) {
+			t.Error("Expected .Delete to report the link as deleted")
+		}
+
+		if store.Find(slug) != nil {
+			t.Error("Expected .Find not to find a deleted link")
+		}
+
+		if store.Delete(slug) {
+			t.Error("Expected .Delete on a missing link to report nothing was deleted")
+		}
+	}
+}
+
+func testList(t *testing.T, store Store) {
+	entries, next, err := store.List("", 10)
+	if err != nil || len(entries) != 0 || next != "" {
+		t.Errorf("Expected an empty store to list nothing. Instead, got %v, %q, %v", entries, next, err)
+	}
+
+	createDistinctLinks(t, store, 25, false)
+	createDistinctLinks(t, store, 5, true)
+
+	seen := make(map[string]bool)
+	cursor := ""
+	for pages := 0; pages < 100; pages++ {
+		entries, next, err := store.List(cursor, 10)
+		if err != nil {
+			t.Fatalf("Unexpected error on .List: %s", err)
+		}
+
+		for _, entry := range entries {
+			if seen[entry.Slug] {
+				t.Errorf("Expected .List to return each link once. Instead, %s was repeated", entry.Slug)
+			}
+			seen[entry.Slug] = true
+
+			if hasFlag(entry.Slug, privateFlag) {
+				t.Errorf("Expected .List not to return private links. Instead, got %s", entry.Slug)
+			}
+
+			if found := store.Find(entry.Slug); found == nil || !reflect.DeepEqual(found, entry.Link) {
+				t.Errorf("Expected .List to return the link stored under %s", entry.Slug)
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if len(seen) != 25 {
+		t.Errorf("Expected .List to go through the 25 public links. Instead, it went through %d", len(seen))
+	}
+
+	for _, limit := range []int{0, -1} {
+		if entries, _, err := store.List("", limit); err != nil || len(entries) == 0 {
+			t.Errorf("Expected .List with a limit of %d to list a default page. Instead, got %v, %v", limit, entries, err)
+		}
+	}
+}
+
+func testAll(t *testing.T, store Store) {
+	entries, err := store.All()
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected an empty store to have no links. Instead, got %v, %v", entries, err)
+	}
+
+	createDistinctLinks(t, store, 3, false)
+	createDistinctLinks(t, store, 2, true)
+
+	entries, err = store.All()
+	if err != nil {
+		t.Fatalf("Unexpected error on .All: %s", err)
+	}
+
+	private := 0
+	for _, entry := range entries {
+		if found := store.Find(entry.Slug); found == nil || !reflect.DeepEqual(found, entry.Link) {
+			t.Errorf("Expected .All to return the link stored under %s", entry.Slug)
+		}
+		if hasFlag(entry.Slug, privateFlag) {
+			private++
+		}
+	}
+
+	if len(entries) != 5 || private != 2 {
+		t.Errorf("Expected .All to return the 3 public links and the 2 private ones. Instead, got %d links, %d private", len(entries), private)
+	}
+}
+
+func testExpired(t *testing.T, store Store) {
+	expiresAt := time.Now().Add(-time.Second)
+	expired := store.Create(&Link{Values: templates.Values{Title: "expired", URL: "http://example.com/expired"}, ExpiresAt: &expiresAt})
+
+	if store.Find(expired) == nil {
+		t.Error("Expected .Find to find expired links, so that they can be reported as gone")
+	}
+
+	if slug := store.FindRandom(); slug != "" {
+		t.Errorf("Expected .FindRandom to leave out expired links. Instead, got %s", slug)
+	}
+
+	if entries, _, err := store.List("", 10); err != nil || len(entries) != 0 {
+		t.Errorf("Expected .List to leave out expired links. Instead, got %v, %v", entries, err)
+	}
+
+	if entries, err := store.All(); err != nil || len(entries) != 0 {
+		t.Errorf("Expected .All to leave out expired links. Instead, got %v, %v", entries, err)
+	}
+}
+
+func testPing(t *testing.T, store Store) {
+	if err := store.Ping(); err != nil {
+		t.Errorf("Expected .Ping to succeed on a reachable store. Instead, got %s", err)
+	}
+}
+
 func createLinks(t *testing.T, store Store, n int, private bool) []string {
 	slugs := make([]string, n)
 
 	for i := 0; i < n; i++ {
-		link := RandomLink()
+		link := *RandomLink()
 		link.Private = private
-		slugs = append(slugs, store.Create(link))
+		slugs = append(slugs, store.Create(&link))
 	}
 
 	return slugs
 }
 
+// Unlike the examples, every link gets its own URL and therefore its own slug
+func createDistinctLinks(t *testing.T, store Store, n int, private bool) {
+	for i := 0; i < n; i++ {
+		link, err := NewLink(templates.Values{Title: "something", URL: fmt.Sprintf("http://example.com/%d", i)}, private)
+		if err != nil {
+			t.Fatal("Not expecting .NewLink to fail. Instead, got", err)
+		}
+		store.Create(link)
+	}
+}
+
 /*
 	All implementations comply with the expected behavior
 */
@@ -97,6 +372,137 @@ func TestInMemoryStore(t *testing.T) {
 	behavesLikeAStore(t, store)
 }
 
+// Meant to be run with -race, which reports the unsynchronized accesses this would otherwise make
+func TestInMemoryStoreConcurrentAccess(t *testing.T) {
+	store := NewInMemoryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			slug := store.CreateWithSlug(fmt.Sprintf("slug-%d", i%5), RandomLink())
+			store.Find(slug)
+			store.FindRandom()
+			store.Update(slug, RandomLink())
+			store.List("", 10)
+			if i%10 == 0 {
+				store.Delete(slug)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestInMemoryStoreListPages(t *testing.T) {
+	store := NewInMemoryStore()
+	createDistinctLinks(t, store, 3, false)
+
+	first, next, _ := store.List("", 2)
+	if len(first) != 2 || next != first[1].Slug {
+		t.Fatalf("Expected a full first page pointing to the next one. Instead, got %v, %q", first, next)
+	}
+
+	second, next, _ := store.List(next, 2)
+	if len(second) != 1 || next != "" || second[0].Slug <= first[1].Slug {
+		t.Errorf("Expected a last page with the remaining link. Instead, got %v, %q", second, next)
+	}
+
+	if all, next, _ := store.List("", 3); len(all) != 3 || next != "" {
+		t.Errorf("Expected an exactly full page to be the last one. Instead, got %v, %q", all, next)
+	}
+}
+
+func TestInMemoryStoreListWithoutLimit(t *testing.T) {
+	store := NewInMemoryStore()
+	createDistinctLinks(t, store, DefaultListLimit+1, false)
+
+	for _, limit := range []int{0, -1} {
+		entries, next, err := store.List("", limit)
+		if err != nil || len(entries) != DefaultListLimit || next == "" {
+			t.Errorf("Expected a limit of %d to list a page of %d links. Instead, got %d links, %q, %v", limit, DefaultListLimit, len(entries), next, err)
+		}
+	}
+}
+
+// Roomy enough for the suite, which then checks the bookkeeping of capped stores does not get in the way
+func TestInMemoryStoreWithCapacity(t *testing.T) {
+	store := NewInMemoryStoreWithCapacity(1000)
+	behavesLikeAStore(t, store)
+}
+
+func TestInMemoryStoreEvictsTheLeastRecentlyUsedLink(t *testing.T) {
+	store := NewInMemoryStoreWithCapacity(3)
+
+	var evicted []string
+	store.OnEvict(func(slug string, link *Link) {
+		if link == nil {
+			t.Errorf("Expected the evicted link %s to be handed along with its slug", slug)
+		}
+		evicted = append(evicted, slug)
+	})
+
+	first := store.CreateWithSlug("first", RandomLink())
+	second := store.CreateWithSlug("second", RandomLink())
+	third := store.CreateWithSlug("third", RandomLink())
+	store.Find(first)
+	fourth := store.CreateWithSlug("fourth", RandomLink())
+
+	if !reflect.DeepEqual(evicted, []string{second}) {
+		t.Fatalf("Expected exactly the least recently used link to be evicted. Instead, got %v", evicted)
+	}
+
+	if store.Find(second) != nil {
+		t.Error("Expected the evicted link not to be found anymore")
+	}
+	for _, slug := range []string{first, third, fourth} {
+		if store.Find(slug) == nil {
+			t.Errorf("Expected %s to be kept", slug)
+		}
+	}
+}
+
+func TestInMemoryStoreSweepsLinksPastTheirRetention(t *testing.T) {
+	store := NewInMemoryStore()
+
+	var swept []string
+	store.OnEvict(func(slug string, link *Link) {
+		swept = append(swept, slug)
+	})
+
+	longAgo := time.Now().Add(-ExpiredRetention - time.Hour)
+	recently := time.Now().Add(-time.Hour)
+	stale := store.CreateWithSlug("stale", &Link{Values: templates.Values{Title: "stale"}, ExpiresAt: &longAgo})
+	gone := store.CreateWithSlug("gone", &Link{Values: templates.Values{Title: "gone"}, ExpiresAt: &recently})
+
+	if store.Find(stale) != nil {
+		t.Error("Expected links past their retention not to be found, even before being swept")
+	}
+
+	store.swept = time.Time{}
+	store.CreateWithSlug("fresh", RandomLink())
+
+	if !reflect.DeepEqual(swept, []string{stale}) {
+		t.Errorf("Expected exactly the links past their retention to be swept. Instead, got %v", swept)
+	}
+	if _, ok := store.public[stale]; ok {
+		t.Error("Expected the swept link to be dropped")
+	}
+	if store.Find(gone) == nil {
+		t.Error("Expected expired links to be kept until their retention ends")
+	}
+}
+
+func TestInMemoryStoreWithoutCapacity(t *testing.T) {
+	store := NewInMemoryStore()
+	store.OnEvict(func(slug string, link *Link) {
+		t.Errorf("Expected no link to be evicted. Instead, %s was", slug)
+	})
+
+	createDistinctLinks(t, store, 100, false)
+}
+
 func TestRedisStore(t *testing.T) {
 	store := NewRedisStore(
 		os.Getenv("REDIS_HOST"),
@@ -105,3 +511,56 @@ func TestRedisStore(t *testing.T) {
 	)
 	behavesLikeAStore(t, store)
 }
+
+func TestRedisStoreListWithInvalidCursor(t *testing.T) {
+	store := NewRedisStore(
+		os.Getenv("REDIS_HOST"),
+		os.Getenv("REDIS_PORT"),
+		os.Getenv("REDIS_PASS"),
+	)
+
+	if _, _, err := store.List("bogus", 10); err != ErrInvalidCursor {
+		t.Errorf("Expected a cursor Redis did not return to be invalid. Instead, got %v", err)
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	store, err := NewPostgresStore(os.Getenv("POSTGRES_URL"))
+	if err != nil {
+		t.Fatalf("Unexpected error creating the Postgres store: %s", err)
+	}
+	behavesLikeAStore(t, store)
+}
+
+func TestNewPostgresStoreWithUnreachableDatabase(t *testing.T) {
+	if _, err := NewPostgresStore("postgres://fakelink@127.0.0.1:1/fakelink?sslmode=disable&connect_timeout=1"); err == nil {
+		t.Error("Expected creating a Postgres store without a database to fail")
+	}
+}
+
+func TestRedisStoreExpiration(t *testing.T) {
+	store := NewRedisStore(
+		os.Getenv("REDIS_HOST"),
+		os.Getenv("REDIS_PORT"),
+		os.Getenv("REDIS_PASS"),
+	)
+	store.clear()
+
+	expiresAt := time.Now().Add(time.Hour)
+	link := &Link{Values: templates.Values{Title: "something"}, ExpiresAt: &expiresAt}
+	slug := store.Create(link)
+
+	ttl, err := store.public.TTL(slug).Result()
+	if err != nil {
+		t.Fatalf("Unexpected error reading the link's TTL: %s", err)
+	}
+
+	if ttl <= ExpiredRetention || ttl > time.Hour+ExpiredRetention {
+		t.Errorf("Expected expiring links to be kept until their retention ends. Instead, the TTL was %s", ttl)
+	}
+
+	store.Update(slug, &Link{Values: templates.Values{Title: "something else"}})
+	if ttl, _ = store.public.TTL(slug).Result(); ttl > 0 {
+		t.Errorf("Expected links updated without an expiration to be kept forever. Instead, the TTL was %s", ttl)
+	}
+}
@@ -0,0 +1,108 @@
+package logs
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Fields are the key/value pairs a log entry carries along with its message.
+type Fields map[string]interface{}
+
+// Logger writes structured log entries, one JSON object per line. A nil Logger discards them.
+type Logger struct {
+	out    io.Writer
+	mutex  *sync.Mutex
+	fields Fields
+	now    func() time.Time
+	exit   func(int)
+}
+
+// New creates a Logger that writes its entries to out.
+func New(out io.Writer) *Logger {
+	return &Logger{
+		out:    out,
+		mutex:  &sync.Mutex{},
+		fields: Fields{},
+		now:    time.Now,
+		exit:   os.Exit,
+	}
+}
+
+// With returns a Logger that adds the given fields to every entry, on top of the ones this Logger adds.
+func (logger *Logger) With(fields Fields) *Logger {
+	if logger == nil {
+		return nil
+	}
+
+	merged := Fields{}
+	for key, value := range logger.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	child := *logger
+	child.fields = merged
+	return &child
+}
+
+// Info logs an informational entry.
+func (logger *Logger) Info(msg string, fields Fields) {
+	logger.write("info", msg, fields)
+}
+
+// Error logs an entry about a failure, the error being kept under the "error" field.
+func (logger *Logger) Error(msg string, err error, fields Fields) {
+	logger.write("error", msg, withError(fields, err))
+}
+
+// Fatal logs an entry about a failure the process cannot recover from, then exits.
+func (logger *Logger) Fatal(msg string, err error, fields Fields) {
+	logger.write("fatal", msg, withError(fields, err))
+	if logger == nil {
+		os.Exit(1)
+	}
+	logger.exit(1)
+}
+
+func withError(fields Fields, err error) Fields {
+	withErr := Fields{}
+	for key, value := range fields {
+		withErr[key] = value
+	}
+	if err != nil {
+		withErr["error"] = err.Error()
+	}
+
+	return withErr
+}
+
+func (logger *Logger) write(level, msg string, fields Fields) {
+	if logger == nil {
+		return
+	}
+
+	entry := Fields{}
+	for key, value := range logger.fields {
+		entry[key] = value
+	}
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["time"] = logger.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(Fields{"time": entry["time"], "level": "error", "msg": "Unexpected error when marshaling a log entry", "error": err.Error()})
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.out.Write(append(line, '\n'))
+}
@@ -0,0 +1,89 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger() (*Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	logger := New(buf)
+	logger.now = func() time.Time { return time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return logger, buf
+}
+
+func entries(t *testing.T, buf *bytes.Buffer) []Fields {
+	var list []Fields
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		entry := Fields{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected every line to be a JSON object. Instead, got %q", line)
+		}
+		list = append(list, entry)
+	}
+
+	return list
+}
+
+func TestLoggerWritesJSONLines(t *testing.T) {
+	logger, buf := newTestLogger()
+
+	logger.Info("Listening", Fields{"port": 8080})
+	logger.Error("Storing failed", errors.New("timeout"), Fields{"key": "some-key"})
+
+	list := entries(t, buf)
+	if len(list) != 2 {
+		t.Fatalf("Expected one line per entry. Instead, got %d", len(list))
+	}
+
+	first := list[0]
+	if first["level"] != "info" || first["msg"] != "Listening" || first["port"] != float64(8080) || first["time"] != "2017-01-02T03:04:05Z" {
+		t.Errorf("Unexpected info entry %v", first)
+	}
+
+	second := list[1]
+	if second["level"] != "error" || second["error"] != "timeout" || second["key"] != "some-key" {
+		t.Errorf("Unexpected error entry %v", second)
+	}
+}
+
+func TestLoggerWith(t *testing.T) {
+	logger, buf := newTestLogger()
+
+	child := logger.With(Fields{"request_id": "some-id"})
+	child.Info("Handled", nil)
+	logger.Info("Unrelated", nil)
+
+	list := entries(t, buf)
+	if list[0]["request_id"] != "some-id" {
+		t.Errorf("Expected the child logger to add its fields. Instead, got %v", list[0])
+	}
+	if _, ok := list[1]["request_id"]; ok {
+		t.Errorf("Expected the parent logger not to be affected by its child. Instead, got %v", list[1])
+	}
+}
+
+func TestLoggerFatalExits(t *testing.T) {
+	logger, buf := newTestLogger()
+	code := -1
+	logger.exit = func(c int) { code = c }
+
+	logger.Fatal("Cannot start", errors.New("no bucket"), nil)
+
+	if code != 1 {
+		t.Errorf("Expected .Fatal to exit with status 1. Instead, got %d", code)
+	}
+	if list := entries(t, buf); list[0]["level"] != "fatal" {
+		t.Errorf("Expected a fatal entry. Instead, got %v", list[0])
+	}
+}
+
+func TestNilLoggerDiscards(t *testing.T) {
+	var logger *Logger
+	logger.With(Fields{"some": "field"}).Info("Nothing", nil)
+	logger.Error("Nothing", errors.New("nothing"), nil)
+}
@@ -0,0 +1,58 @@
+package templates
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// Funcs are the helpers the built-in templates are parsed with, which let a layout shape the values
+// for each platform. Templates registered from outside the package may use them with template.Funcs(Funcs)
+var Funcs = template.FuncMap{
+	"truncate":  truncate,
+	"urlencode": urlencode,
+	"htmlAttr":  htmlAttr,
+}
+
+// Truncate shortens a text longer than length characters to a whole number of words followed by an ellipsis,
+// which fit within length. A single word too long to fit is cut, though never within a character. Texts that
+// fit are returned as they are.
+func Truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	if length <= 0 {
+		return ""
+	}
+
+	// Room is kept for the ellipsis. Unless the cut falls right before a space, the last, partial word is dropped
+	cut := runes[:length-1]
+	if !unicode.IsSpace(runes[length-1]) {
+		for i := len(cut) - 1; i > 0; i-- {
+			if unicode.IsSpace(cut[i]) {
+				cut = cut[:i]
+				break
+			}
+		}
+	}
+
+	return strings.TrimRightFunc(string(cut), unicode.IsSpace) + "…"
+}
+
+// Truncate taking the text last, so that it can be used in pipelines like {{.Description | truncate 200}}
+func truncate(length int, text string) string {
+	return Truncate(text, length)
+}
+
+// Escapes a value to be used as a query parameter, such as when building a sharing URL
+func urlencode(value string) string {
+	return url.QueryEscape(value)
+}
+
+// Collapses the line breaks and runs of whitespace of a value into single spaces, as attributes are meant to be
+// one line. Escaping the value is still left to html/template
+func htmlAttr(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
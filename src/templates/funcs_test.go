@@ -0,0 +1,91 @@
+package templates
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		length   int
+		text     string
+		expected string
+	}{
+		{20, "short enough", "short enough"},
+		{12, "short enough", "short enough"},
+		{11, "short enough", "short…"},
+		{14, "the quick brown fox", "the quick…"},
+		{16, "the quick brown fox", "the quick brown…"},
+		{6, "unbreakable", "unbre…"},
+		{9, "añoranza de un día", "añoranza…"},
+		{0, "anything", ""},
+		{18, "Two imprisoned men bond over a number of years", "Two imprisoned…"},
+		{17, "Two imprisoned, men bond", "Two imprisoned,…"},
+		{12, "Ça été très émouvant à voir", "Ça été très…"},
+		{6, "東京で撮影された映画です", "東京で撮影…"},
+		{8, "Über 😀😀😀😀😀 Emoji", "Über…"},
+	}
+
+	for _, c := range cases {
+		actual := Truncate(c.text, c.length)
+		if actual != c.expected {
+			t.Errorf("Expected Truncate(%q, %d) to be %q. Instead, got %q", c.text, c.length, c.expected, actual)
+		}
+
+		if !utf8.ValidString(actual) {
+			t.Errorf("Expected truncating %q not to split a character", c.text)
+		}
+	}
+}
+
+func TestTruncateInPipelines(t *testing.T) {
+	if actual := truncate(14, "the quick brown fox"); actual != "the quick…" {
+		t.Errorf("Expected truncate to take the length first. Instead, got %q", actual)
+	}
+}
+
+func TestTruncateFitsTheLength(t *testing.T) {
+	text := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 10)
+
+	if length := utf8.RuneCountInString(Truncate(text, 200)); length > 200 {
+		t.Errorf("Expected the truncated text to have at most 200 characters. Instead, it had %d", length)
+	}
+}
+
+func TestURLEncode(t *testing.T) {
+	cases := map[string]string{
+		"some value":       "some+value",
+		"día & noche":      "d%C3%ADa+%26+noche",
+		"a=b?c/d":          "a%3Db%3Fc%2Fd",
+		"already-url-safe": "already-url-safe",
+	}
+
+	for value, expected := range cases {
+		if actual := urlencode(value); actual != expected {
+			t.Errorf("Expected urlencode(%q) to be %q. Instead, got %q", value, expected, actual)
+		}
+	}
+}
+
+func TestHTMLAttr(t *testing.T) {
+	if actual := htmlAttr("  some\n\tmultiline   value "); actual != "some multiline value" {
+		t.Errorf("Expected the whitespace to be collapsed. Instead, got %q", actual)
+	}
+}
+
+func TestExecuteTemplateTruncatesTwitterDescription(t *testing.T) {
+	description := strings.Repeat("word ", 100)
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, &Values{Description: description})
+
+	if !strings.Contains(buf.String(), `<meta property="og:description" content="`+description+`" />`) {
+		t.Error("Expected og:description to be rendered in full")
+	}
+
+	if !strings.Contains(buf.String(), `<meta name="twitter:description" content="`+strings.Repeat("word ", 39)+`word…" />`) {
+		t.Errorf("Expected twitter:description to be truncated to 200 characters. Instead, got %s", buf.String())
+	}
+}
@@ -0,0 +1,108 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"sync"
+)
+
+/*
+	REGISTRY:
+	Besides the default template, links can pick one of several named layouts, which lets us compare how
+	scrapers behave with each of them. Every layout is an html/template, so values are always escaped
+*/
+
+// DefaultName is the name the default template is registered with
+const DefaultName = "default"
+
+// ErrUnknownTemplate is returned when looking up a template that was never registered.
+var ErrUnknownTemplate = errors.New("Unknown template")
+
+// A Registry holds named templates. It is safe for concurrent use
+type Registry struct {
+	mutex     sync.RWMutex
+	templates map[string]*template.Template
+}
+
+// NewRegistry creates a Registry with the built-in layouts, the default template included.
+func NewRegistry() *Registry {
+	registry := &Registry{templates: make(map[string]*template.Template)}
+	registry.Register(DefaultName, Get())
+	registry.Register("opengraph", openGraphTemplate)
+
+	return registry
+}
+
+// Register makes a template available under the given name, replacing any previous one with the same name.
+func (registry *Registry) Register(name string, tmpl *template.Template) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.templates[name] = tmpl
+}
+
+// GetByName returns the template registered under the given name. An empty name stands for the default template.
+func (registry *Registry) GetByName(name string) (*template.Template, error) {
+	if name == "" {
+		name = DefaultName
+	}
+
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	tmpl, ok := registry.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %q", ErrUnknownTemplate, name)
+	}
+
+	return tmpl, nil
+}
+
+// Default returns the default template of the registry.
+func (registry *Registry) Default() *template.Template {
+	tmpl, err := registry.GetByName(DefaultName)
+	if err != nil {
+		panic(fmt.Sprintf("The default template was removed from the registry: %s", err))
+	}
+
+	return tmpl
+}
+
+// DefaultRegistry is the registry the package level functions work with.
+var DefaultRegistry = NewRegistry()
+
+// Register makes a template available in the DefaultRegistry under the given name.
+func Register(name string, tmpl *template.Template) {
+	DefaultRegistry.Register(name, tmpl)
+}
+
+// GetByName returns the template registered in the DefaultRegistry under the given name.
+func GetByName(name string) (*template.Template, error) {
+	return DefaultRegistry.GetByName(name)
+}
+
+var openGraphTemplate = parse("opengraph", openGraphTemplateStr)
+
+// A bare layout with just the Open Graph tags, without Twitter Cards, structured data nor oEmbed discovery
+const openGraphTemplateStr = `
+<!DOCTYPE html>
+<html prefix="og: http://ogp.me/ns#">
+<head>
+    {{if .Title}}<meta property="og:title" content="{{.Title}}" />{{end}}
+    {{if .Determiner}}<meta property="og:determiner" content="{{.Determiner}}" />{{end}}
+    {{if .SiteName}}<meta property="og:site_name" content="{{.SiteName}}" />{{end}}
+    {{if .Description}}<meta property="og:description" content="{{.Description}}" />{{end}}
+    {{if .Type}}<meta property="og:type" content="{{.Type}}" />{{end}}
+    {{with .EffectiveCanonicalURL}}<meta property="og:url" content="{{.}}" />{{end}}
+    {{range .ImageCandidates}}<meta property="og:image" content="{{.URL}}" />{{end}}
+    {{with .ArticleProperties}}
+    {{if .PublishedTime}}<meta property="article:published_time" content="{{.PublishedTime}}" />{{end}}
+    {{range .Authors}}<meta property="article:author" content="{{.}}" />{{end}}
+    {{if .Section}}<meta property="article:section" content="{{.Section}}" />{{end}}
+    {{range .Tags}}<meta property="article:tag" content="{{.}}" />{{end}}
+    {{end}}
+    {{if .RedirectURL}}<meta http-equiv="refresh" content="0; url={{.RedirectURL}}" />{{end}}
+</head>
+</html>
+`
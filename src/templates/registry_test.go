@@ -0,0 +1,74 @@
+package templates
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestGetByNameDefaults(t *testing.T) {
+	for _, name := range []string{"", DefaultName} {
+		if tmpl, err := GetByName(name); err != nil || tmpl == nil {
+			t.Errorf("Expected %q to resolve to the default template. Instead, got %v", name, err)
+		}
+	}
+}
+
+func TestGetByNameUnknown(t *testing.T) {
+	if _, err := GetByName("missing"); err == nil || !strings.Contains(err.Error(), ErrUnknownTemplate.Error()) {
+		t.Errorf("Expected an unknown template error. Instead, got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("test-layout", template.Must(template.New("test-layout").Parse(`<title>{{.Title}}</title>`)))
+
+	tmpl, err := GetByName("test-layout")
+	if err != nil {
+		t.Fatalf("Expected a registered template to be found. Instead, got %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	tmpl.Execute(buf, &Page{Values: Values{Title: "<script>"}})
+
+	if buf.String() != "<title>&lt;script&gt;</title>" {
+		t.Errorf("Expected registered templates to escape values. Instead, got %s", buf.String())
+	}
+}
+
+func TestRegistriesAreIndependent(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("only-here", Get())
+
+	if _, err := registry.GetByName("only-here"); err != nil {
+		t.Errorf("Expected the template to be registered. Instead, got %s", err)
+	}
+
+	if _, err := NewRegistry().GetByName("only-here"); err == nil {
+		t.Error("Expected templates registered in one registry not to leak into others")
+	}
+
+	if _, err := GetByName("only-here"); err == nil {
+		t.Error("Expected templates registered in one registry not to leak into the DefaultRegistry")
+	}
+
+	if registry.Default() == nil {
+		t.Error("Expected every registry to have a default template")
+	}
+}
+
+func TestOpenGraphLayout(t *testing.T) {
+	tmpl, err := GetByName("opengraph")
+	if err != nil {
+		t.Fatalf("Expected the opengraph layout to be registered. Instead, got %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	tmpl.Execute(buf, &Page{Values: Values{Title: "some-title"}})
+
+	expectToContain(t, buf.String(), `<meta property="og:title" content="some-title" />`)
+	if strings.Contains(buf.String(), "twitter:") {
+		t.Error("Expected the opengraph layout not to include Twitter Cards")
+	}
+}
@@ -3,18 +3,220 @@
 package templates
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"strings"
 )
 
 // Values describe all the possible OpenGraph attributes a compliant website might have
 type Values struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	SiteName    string `json:"site_name"`
-	Type        string `json:"type"`
-	URL         string `json:"url"`
-	Image       string `json:"image"`
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	SiteName     string   `json:"site_name"`
+	Type         string   `json:"type"`
+	URL          string   `json:"url"`
+	CanonicalURL string   `json:"canonical_url,omitempty"`
+	TargetURL    string   `json:"target_url,omitempty"`
+	Image        string   `json:"image"`
+	Images       []Image  `json:"images,omitempty"`
+	Video        *Video   `json:"video,omitempty"`
+	Audio        *Audio   `json:"audio,omitempty"`
+	Article      *Article `json:"article,omitempty"`
+	TwitterCard  string   `json:"twitter_card"`
+	Determiner   string   `json:"determiner,omitempty"`
+
+	Locale           string   `json:"locale"`
+	AlternateLocales []string `json:"alternate_locales,omitempty"`
+}
+
+// DefaultLocale is the og:locale of links that don't specify one
+const DefaultLocale = "en_US"
+
+// EffectiveLocale returns the locale the values are in, defaulting to DefaultLocale
+func (values Values) EffectiveLocale() string {
+	if values.Locale == "" {
+		return DefaultLocale
+	}
+
+	return values.Locale
+}
+
+// Image is one of the og:image candidates of a link. Dimensions are optional, zero meaning unknown, and so is
+// Type, its MIME type, e.g. image/jpeg
+type Image struct {
+	URL    string `json:"url"`
+	Type   string `json:"type,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// SecureURL returns the URL of the image when it is served over HTTPS, for og:image:secure_url
+func (image Image) SecureURL() string {
+	if !strings.HasPrefix(strings.ToLower(image.URL), "https://") {
+		return ""
+	}
+
+	return image.URL
+}
+
+// Video is the video a link points to, rendered as og:video. Type is its MIME type, e.g. video/mp4
+type Video struct {
+	URL    string `json:"url"`
+	Type   string `json:"type,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// Audio is the audio a link points to, rendered as og:audio. Type is its MIME type, e.g. audio/mpeg
+type Audio struct {
+	URL  string `json:"url"`
+	Type string `json:"type,omitempty"`
+}
+
+// Article holds the article: properties of links of the article type. PublishedTime is an RFC 3339 date, and
+// Authors the URLs of the authors' profiles
+type Article struct {
+	PublishedTime string   `json:"published_time,omitempty"`
+	Authors       []string `json:"authors,omitempty"`
+	Section       string   `json:"section,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+// ArticleProperties returns the article the values describe, which is only the case for links of the article type
+func (values Values) ArticleProperties() *Article {
+	if values.Type != "article" {
+		return nil
+	}
+
+	return values.Article
+}
+
+// EffectiveCanonicalURL returns the URL rendered as og:url: the CanonicalURL or, when missing, the URL
+func (values Values) EffectiveCanonicalURL() string {
+	if values.CanonicalURL == "" {
+		return values.URL
+	}
+
+	return values.CanonicalURL
+}
+
+// EffectiveTargetURL returns the URL visitors are sent to: the TargetURL or, when missing, the URL
+func (values Values) EffectiveTargetURL() string {
+	if values.TargetURL == "" {
+		return values.URL
+	}
+
+	return values.TargetURL
+}
+
+// Resolve returns a copy of the values where a URL or CanonicalURL that is a path on our own domain, such as
+// "/about", is made absolute with the given base URL, as og:url values must be
+func (values Values) Resolve(baseURL string) Values {
+	values.URL = resolve(baseURL, values.URL)
+	values.CanonicalURL = resolve(baseURL, values.CanonicalURL)
+	return values
+}
+
+func resolve(baseURL, raw string) string {
+	if strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//") {
+		return strings.TrimSuffix(baseURL, "/") + raw
+	}
+
+	return raw
+}
+
+// ImageCandidates returns every image of the values, in order. The singular Image comes first unless
+// it is also part of Images, in which case the entry in Images (and its dimensions) is used instead
+func (values Values) ImageCandidates() []Image {
+	candidates := values.Images
+	if values.Image == "" {
+		return candidates
+	}
+
+	for _, image := range values.Images {
+		if image.URL == values.Image {
+			return candidates
+		}
+	}
+
+	return append([]Image{{URL: values.Image}}, candidates...)
+}
+
+// MainImage returns the URL of the first image candidate, if any
+func (values Values) MainImage() string {
+	candidates := values.ImageCandidates()
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	return candidates[0].URL
+}
+
+// Card returns the Twitter Card type: the one set explicitly or, by default, a large image summary for links
+// with an image and a plain summary otherwise
+func (values Values) Card() string {
+	if values.TwitterCard != "" {
+		return values.TwitterCard
+	}
+
+	if values.MainImage() != "" {
+		return "summary_large_image"
+	}
+
+	return "summary"
+}
+
+type structuredData struct {
+	Context     string `json:"@context"`
+	Type        string `json:"@type"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// StructuredData returns the schema.org JSON-LD describing the values. It is JSON encoded, which escapes <, > and &,
+// so it is safe to embed as is in a script tag
+func (values Values) StructuredData() template.JS {
+	data, err := json.Marshal(&structuredData{
+		Context:     "https://schema.org",
+		Type:        schemaType(values.Type),
+		Name:        values.Title,
+		Description: values.Description,
+		URL:         values.EffectiveCanonicalURL(),
+		Image:       values.MainImage(),
+	})
+	if err != nil {
+		return template.JS("{}")
+	}
+
+	return template.JS(data)
+}
+
+// Maps Open Graph types to their closest schema.org type
+func schemaType(ogType string) string {
+	switch {
+	case ogType == "video.movie":
+		return "Movie"
+	case ogType == "video" || strings.HasPrefix(ogType, "video."):
+		return "VideoObject"
+	case ogType == "website":
+		return "WebSite"
+	default:
+		return "WebPage"
+	}
+}
+
+// Page is what the template is rendered with: a link's values plus the page's discovery metadata.
+// BaseURL is where the API is publicly reachable, and LinkURL the shareable URL of the preview itself.
+// RedirectURL, when set, is where clients that are not scrapers are sent to with a meta refresh
+type Page struct {
+	Values
+	OEmbedURL   string
+	BaseURL     string
+	LinkURL     string
+	RedirectURL string
 }
 
 const templateStr = `
@@ -25,19 +227,64 @@ const templateStr = `
     <title>{{.Title}}</title>
     <meta property="og:title" content="{{.Title}}" />
     {{end}}
+    {{if .Determiner}}<meta property="og:determiner" content="{{.Determiner}}" />{{end}}
 
     {{if .SiteName}}<meta property="og:site_name" content="{{.SiteName}}" />{{end}}
     {{if .Description}}<meta property="og:description" content="{{.Description}}" />{{end}}
     {{if .Type}}<meta property="og:type" content="{{.Type}}" />{{end}}
-    {{if .URL}}<meta property="og:url" content="{{.URL}}" />{{end}}
-    {{if .Image}}<meta property="og:image" content="{{.Image}}" />{{end}}
+    {{with .EffectiveCanonicalURL}}<meta property="og:url" content="{{.}}" />{{end}}
+    <meta property="og:locale" content="{{.EffectiveLocale}}" />
+    {{range .AlternateLocales}}<meta property="og:locale:alternate" content="{{.}}" />{{end}}
+    {{range .ImageCandidates}}
+    <meta property="og:image" content="{{.URL}}" />
+    {{with .SecureURL}}<meta property="og:image:secure_url" content="{{.}}" />{{end}}
+    {{if .Type}}<meta property="og:image:type" content="{{.Type}}" />{{end}}
+    {{if .Width}}<meta property="og:image:width" content="{{.Width}}" />{{end}}
+    {{if .Height}}<meta property="og:image:height" content="{{.Height}}" />{{end}}
+    {{end}}
+
+    {{with .Video}}
+    <meta property="og:video" content="{{.URL}}" />
+    <meta property="og:video:url" content="{{.URL}}" />
+    {{if .Type}}<meta property="og:video:type" content="{{.Type}}" />{{end}}
+    {{if .Width}}<meta property="og:video:width" content="{{.Width}}" />{{end}}
+    {{if .Height}}<meta property="og:video:height" content="{{.Height}}" />{{end}}
+    {{end}}
+    {{with .Audio}}
+    <meta property="og:audio" content="{{.URL}}" />
+    {{if .Type}}<meta property="og:audio:type" content="{{.Type}}" />{{end}}
+    {{end}}
+
+    {{with .ArticleProperties}}
+    {{if .PublishedTime}}<meta property="article:published_time" content="{{.PublishedTime}}" />{{end}}
+    {{range .Authors}}<meta property="article:author" content="{{.}}" />{{end}}
+    {{if .Section}}<meta property="article:section" content="{{.Section}}" />{{end}}
+    {{range .Tags}}<meta property="article:tag" content="{{.}}" />{{end}}
+    {{end}}
+
+    <meta name="twitter:card" content="{{.Card}}" />
+    {{if .Title}}<meta name="twitter:title" content="{{.Title}}" />{{end}}
+    {{if .Description}}<meta name="twitter:description" content="{{.Description | htmlAttr | truncate 200}}" />{{end}}
+    {{if .MainImage}}<meta name="twitter:image" content="{{.MainImage}}" />{{end}}
+
+    <script type="application/ld+json">{{.StructuredData}}</script>
+
+    {{if .OEmbedURL}}<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" />{{end}}
+    {{if .RedirectURL}}<meta http-equiv="refresh" content="0; url={{.RedirectURL}}" />{{end}}
 </head>
 </html>
 `
 
+// The template is parsed once, when the package is loaded, and shared by every caller of Get
+var defaultTemplate = parse("template", templateStr)
+
 // Get the OpenGraph template we will be using
 func Get() *template.Template {
-	t, err := template.New("template").Parse(templateStr)
+	return defaultTemplate
+}
+
+func parse(name, text string) *template.Template {
+	t, err := template.New(name).Funcs(Funcs).Parse(text)
 	if err != nil {
 		panic(fmt.Sprintf("Unexpected error parsing the template: %s", err))
 	}
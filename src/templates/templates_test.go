@@ -2,7 +2,10 @@ package templates
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"html/template"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
@@ -17,6 +20,12 @@ func TestGetTemplate(t *testing.T) {
 	Get()
 }
 
+func TestGetParsesOnce(t *testing.T) {
+	if Get() != Get() {
+		t.Error("Expected every call to return the same parsed template")
+	}
+}
+
 func TestExecuteTemplateWithValues(t *testing.T) {
 	values := &Values{
 		Title:       "some-title",
@@ -42,6 +51,45 @@ func TestExecuteTemplateWithValues(t *testing.T) {
 	)
 }
 
+func TestExecuteTemplateWithCanonicalURL(t *testing.T) {
+	values := &Values{
+		URL:          "https://example.com/destination",
+		CanonicalURL: "https://fakelink.example.com/links/some-slug",
+		TargetURL:    "https://example.com/elsewhere",
+	}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, values)
+
+	expectToContain(t, buf.String(), `<meta property="og:url" content="https://fakelink.example.com/links/some-slug" />`)
+	if strings.Contains(buf.String(), "example.com/destination") || strings.Contains(buf.String(), "example.com/elsewhere") {
+		t.Errorf("Expected only the canonical URL to be rendered. Instead, got %s", buf.String())
+	}
+}
+
+func TestEffectiveURLs(t *testing.T) {
+	cases := []struct {
+		values    Values
+		canonical string
+		target    string
+	}{
+		{Values{}, "", ""},
+		{Values{URL: "http://a.com"}, "http://a.com", "http://a.com"},
+		{Values{URL: "http://a.com", CanonicalURL: "http://b.com"}, "http://b.com", "http://a.com"},
+		{Values{URL: "http://a.com", TargetURL: "http://c.com"}, "http://a.com", "http://c.com"},
+		{Values{CanonicalURL: "http://b.com", TargetURL: "http://c.com"}, "http://b.com", "http://c.com"},
+	}
+
+	for _, c := range cases {
+		if canonical := c.values.EffectiveCanonicalURL(); canonical != c.canonical {
+			t.Errorf("Expected the canonical URL of %+v to be %q. Instead, got %q", c.values, c.canonical, canonical)
+		}
+		if target := c.values.EffectiveTargetURL(); target != c.target {
+			t.Errorf("Expected the target URL of %+v to be %q. Instead, got %q", c.values, c.target, target)
+		}
+	}
+}
+
 func TestExecuteTemplateWithoutValues(t *testing.T) {
 	values := &Values{}
 
@@ -58,7 +106,329 @@ func TestExecuteTemplateWithoutValues(t *testing.T) {
 		"type",
 		"URL",
 		"image",
+		"video",
+		"audio",
+	)
+}
+
+func TestExecuteTemplateWithOEmbedDiscovery(t *testing.T) {
+	page := &Page{
+		Values:    Values{Title: "some-title"},
+		OEmbedURL: "http://example.com/oembed?url=some-url",
+	}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, page)
+
+	expectToContain(t, buf.String(), page.Title, `type="application/json+oembed"`, "http://example.com/oembed?url=some-url")
+}
+
+func TestExecuteTemplateWithTwitterCard(t *testing.T) {
+	values := &Values{
+		Title:       `Tom & "Jerry"`,
+		Description: "<b>some-description</b>",
+		Image:       "http://example.com/image.jpg",
+	}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, values)
+
+	expectToContain(
+		t,
+		buf.String(),
+		`<meta property="og:title" content="Tom &amp; &#34;Jerry&#34;" />`,
+		`<meta name="twitter:title" content="Tom &amp; &#34;Jerry&#34;" />`,
+		`<meta name="twitter:description" content="&lt;b&gt;some-description&lt;/b&gt;" />`,
+		`<meta name="twitter:image" content="http://example.com/image.jpg" />`,
+		`<meta name="twitter:card" content="summary_large_image" />`,
+	)
+}
+
+func TestExecuteTemplateWithMultipleImages(t *testing.T) {
+	values := &Values{
+		Image: "http://example.com/legacy.jpg",
+		Images: []Image{
+			{URL: "http://example.com/first.jpg", Width: 1200, Height: 630},
+			{URL: "http://example.com/second.jpg"},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, values)
+	generated := buf.String()
+
+	expectToContain(
+		t,
+		generated,
+		`<meta property="og:image:width" content="1200" />`,
+		`<meta property="og:image:height" content="630" />`,
+		`<meta name="twitter:image" content="http://example.com/legacy.jpg" />`,
 	)
+
+	legacy := strings.Index(generated, `<meta property="og:image" content="http://example.com/legacy.jpg" />`)
+	first := strings.Index(generated, `<meta property="og:image" content="http://example.com/first.jpg" />`)
+	second := strings.Index(generated, `<meta property="og:image" content="http://example.com/second.jpg" />`)
+	if legacy == -1 || !(legacy < first && first < second) {
+		t.Errorf("Expected one og:image tag per image, in order. Instead, got %s", generated)
+	}
+
+	if strings.Count(generated, "og:image:width") != 1 {
+		t.Error("Expected og:image:width only for images with known dimensions")
+	}
+}
+
+func TestExecuteTemplateWithDeterminer(t *testing.T) {
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, &Values{Title: "Godfather", Determiner: "the"})
+	expectToContain(t, buf.String(), `<meta property="og:determiner" content="the" />`)
+
+	buf.Reset()
+	Get().Execute(buf, &Values{Title: "Godfather"})
+	if strings.Contains(buf.String(), "og:determiner") {
+		t.Error("Expected no og:determiner tag for values without one")
+	}
+}
+
+func TestExecuteTemplateWithSecureImages(t *testing.T) {
+	values := &Values{
+		Images: []Image{
+			{URL: "https://example.com/secure.jpg", Type: "image/jpeg"},
+			{URL: "http://example.com/plain.png", Type: "image/png"},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, values)
+	generated := buf.String()
+
+	expectToContain(
+		t,
+		generated,
+		`<meta property="og:image:secure_url" content="https://example.com/secure.jpg" />`,
+		`<meta property="og:image:type" content="image/jpeg" />`,
+		`<meta property="og:image:type" content="image/png" />`,
+	)
+
+	if strings.Count(generated, "og:image:secure_url") != 1 {
+		t.Errorf("Expected og:image:secure_url only for images served over HTTPS. Instead, got %s", generated)
+	}
+}
+
+func TestExecuteTemplateWithVideoAndAudio(t *testing.T) {
+	values := &Values{
+		Type:  "video.movie",
+		Video: &Video{URL: "https://example.com/movie.mp4", Type: "video/mp4", Width: 1280, Height: 720},
+		Audio: &Audio{URL: "https://example.com/soundtrack.mp3", Type: "audio/mpeg"},
+	}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, values)
+
+	expectToContain(
+		t,
+		buf.String(),
+		`<meta property="og:type" content="video.movie" />`,
+		`<meta property="og:video" content="https://example.com/movie.mp4" />`,
+		`<meta property="og:video:url" content="https://example.com/movie.mp4" />`,
+		`<meta property="og:video:type" content="video/mp4" />`,
+		`<meta property="og:video:width" content="1280" />`,
+		`<meta property="og:video:height" content="720" />`,
+		`<meta property="og:audio" content="https://example.com/soundtrack.mp3" />`,
+		`<meta property="og:audio:type" content="audio/mpeg" />`,
+	)
+}
+
+func TestExecuteTemplateWithArticle(t *testing.T) {
+	values := &Values{
+		Type: "article",
+		Article: &Article{
+			PublishedTime: "2017-03-14T09:00:00Z",
+			Authors:       []string{"https://example.com/jane", "https://example.com/john"},
+			Section:       "Technology",
+			Tags:          []string{"go", "open graph"},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, values)
+
+	expectToContain(
+		t,
+		buf.String(),
+		`<meta property="article:published_time" content="2017-03-14T09:00:00Z" />`,
+		`<meta property="article:author" content="https://example.com/jane" />`,
+		`<meta property="article:author" content="https://example.com/john" />`,
+		`<meta property="article:section" content="Technology" />`,
+		`<meta property="article:tag" content="go" />`,
+		`<meta property="article:tag" content="open graph" />`,
+	)
+}
+
+func TestExecuteTemplateWithArticleOfAnotherType(t *testing.T) {
+	values := &Values{
+		Type:    "website",
+		Article: &Article{PublishedTime: "2017-03-14T09:00:00Z", Section: "Technology", Tags: []string{"go"}},
+	}
+
+	for _, tmpl := range []*template.Template{Get(), openGraphTemplate} {
+		buf := new(bytes.Buffer)
+		tmpl.Execute(buf, values)
+
+		if strings.Contains(buf.String(), "article:") {
+			t.Errorf("Expected the %s template to render no article tags for websites. Instead, got %s", tmpl.Name(), buf.String())
+		}
+	}
+}
+
+func TestExecuteTemplateWithLocales(t *testing.T) {
+	values := &Values{Locale: "pt_BR", AlternateLocales: []string{"en_US", "es_ES"}}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, values)
+
+	expectToContain(
+		t,
+		buf.String(),
+		`<meta property="og:locale" content="pt_BR" />`,
+		`<meta property="og:locale:alternate" content="en_US" />`,
+		`<meta property="og:locale:alternate" content="es_ES" />`,
+	)
+}
+
+func TestExecuteTemplateWithDefaultLocale(t *testing.T) {
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, &Values{})
+
+	expectToContain(t, buf.String(), `<meta property="og:locale" content="en_US" />`)
+	if strings.Contains(buf.String(), "og:locale:alternate") {
+		t.Error("Expected generated template not to include alternate locales when none were specified")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected string
+	}{
+		{"", ""},
+		{"/about", "https://fakelink.example.com/about"},
+		{"http://example.com/about", "http://example.com/about"},
+		{"//example.com/about", "//example.com/about"},
+	}
+
+	for _, c := range cases {
+		values := Values{URL: c.url, CanonicalURL: c.url}
+		resolved := values.Resolve("https://fakelink.example.com/")
+		if resolved.URL != c.expected || resolved.CanonicalURL != c.expected {
+			t.Errorf("Expected %q to resolve to %q. Instead, got %+v", c.url, c.expected, resolved)
+		}
+		if values.URL != c.url {
+			t.Error("Expected Resolve to leave the original values untouched")
+		}
+	}
+}
+
+func TestImageCandidates(t *testing.T) {
+	values := Values{
+		Image:  "http://example.com/b.jpg",
+		Images: []Image{{URL: "http://example.com/a.jpg"}, {URL: "http://example.com/b.jpg", Width: 10}},
+	}
+
+	candidates := values.ImageCandidates()
+	if len(candidates) != 2 || candidates[1].Width != 10 {
+		t.Errorf("Expected the singular image not to be repeated when it is part of Images. Instead, got %+v", candidates)
+	}
+
+	if (Values{}).MainImage() != "" {
+		t.Error("Expected values without images not to have a main image")
+	}
+}
+
+func TestCard(t *testing.T) {
+	cases := []struct {
+		values   Values
+		expected string
+	}{
+		{Values{}, "summary"},
+		{Values{Image: "http://example.com/image.jpg"}, "summary_large_image"},
+		{Values{Image: "http://example.com/image.jpg", TwitterCard: "summary"}, "summary"},
+		{Values{TwitterCard: "player"}, "player"},
+	}
+
+	for _, c := range cases {
+		if card := c.values.Card(); card != c.expected {
+			t.Errorf("Expected the card for %+v to be %s. Instead, it was %s", c.values, c.expected, card)
+		}
+	}
+}
+
+func TestExecuteTemplateWithStructuredData(t *testing.T) {
+	values := &Values{
+		Title: "</script><script>alert(1)</script>",
+		Type:  "video.movie",
+		URL:   "http://example.com/movie",
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Get().Execute(buf, &Page{Values: *values}); err != nil {
+		t.Fatalf("Unexpected error executing the template: %s", err)
+	}
+	generated := buf.String()
+
+	start := strings.Index(generated, `<script type="application/ld+json">`)
+	end := strings.Index(generated[start:], "</script>")
+	if start == -1 || end == -1 {
+		t.Fatalf("Expected generated template to include a JSON-LD script. Instead, got %s", generated)
+	}
+
+	data := map[string]string{}
+	raw := generated[start+len(`<script type="application/ld+json">`) : start+end]
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		t.Fatalf("Expected the JSON-LD script to contain valid JSON. Instead, got %s", raw)
+	}
+
+	if data["@type"] != "Movie" || data["name"] != values.Title || data["url"] != values.URL {
+		t.Errorf("Expected the JSON-LD to describe the movie. Instead, got %v", data)
+	}
+}
+
+func TestSchemaType(t *testing.T) {
+	cases := map[string]string{
+		"video.movie":   "Movie",
+		"video":         "VideoObject",
+		"video.episode": "VideoObject",
+		"website":       "WebSite",
+		"article":       "WebPage",
+		"":              "WebPage",
+	}
+
+	for ogType, expected := range cases {
+		if schema := schemaType(ogType); schema != expected {
+			t.Errorf("Expected %q to map to %s. Instead, it mapped to %s", ogType, expected, schema)
+		}
+	}
+}
+
+func TestExecuteTemplateEscapesValues(t *testing.T) {
+	injection := `"><script>alert(1)</script>`
+	values := &Values{
+		Title:       injection,
+		Description: injection,
+		SiteName:    injection,
+		Type:        injection,
+		URL:         "http://example.com/" + injection,
+		Image:       "http://example.com/" + injection,
+	}
+
+	buf := new(bytes.Buffer)
+	Get().Execute(buf, values)
+	generatedTemplate := buf.String()
+
+	for _, raw := range []string{"<script>", `"><`} {
+		if strings.Contains(generatedTemplate, raw) {
+			t.Errorf("Expected generated template to escape %s", raw)
+		}
+	}
 }
 
 func expectToContain(t *testing.T, template string, values ...string) {
@@ -76,3 +446,21 @@ func expectNotToContain(t *testing.T, template string, tags ...string) {
 		}
 	}
 }
+
+func BenchmarkGet(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Get()
+	}
+}
+
+func BenchmarkExecuteTemplate(b *testing.B) {
+	page := &Page{Values: Values{Title: "some-title", Description: "some-description", URL: "https://example.com"}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := Get().Execute(ioutil.Discard, page); err != nil {
+			b.Fatal(err)
+		}
+	}
+}